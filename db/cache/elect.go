@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/94peter/vulpes/constant"
+	"github.com/94peter/vulpes/log"
+)
+
+// LeaderCallbacks are called by Elect as this process gains and loses the leadership.
+type LeaderCallbacks struct {
+	// OnElected runs while this process leads, and is required. Its context is canceled when
+	// the leadership is lost or the context of Elect is done, and it should return then.
+	OnElected func(ctx context.Context)
+	// OnDemoted is called after OnElected returned and the leadership was given up.
+	OnDemoted func()
+}
+
+// Elect campaigns for the leadership of name among the processes calling Elect with that name,
+// and runs callbacks.OnElected while this process leads. The leadership is the lock name, so
+// at most one process leads at a time, and another one takes over within the lock TTL when
+// the leader stops. Errors while campaigning are logged and retried with backoff.
+//
+// When the leadership is lost, Elect calls OnDemoted and campaigns again. It returns the error
+// of ctx once ctx is done, or nil when OnElected returns on its own, giving up the leadership.
+//
+// Example:
+//
+//	go cache.Elect(ctx, "jobs:scan", cache.LeaderCallbacks{
+//		OnElected: func(ctx context.Context) {
+//			ticker := time.NewTicker(time.Minute)
+//			defer ticker.Stop()
+//			for {
+//				select {
+//				case <-ctx.Done():
+//					return
+//				case <-ticker.C:
+//					_ = cache.ScanExecute(ctx, "session:*", expireSession)
+//				}
+//			}
+//		},
+//	})
+func Elect(ctx context.Context, name string, callbacks LeaderCallbacks, opts ...LockOption) error {
+	o := newLockOptions(opts)
+	for attempt := 0; ; {
+		lock, err := AcquireLock(ctx, name, opts...)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrCacheNotConnected):
+			return err
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			log.Warn("cache election failed", log.String("name", name), log.Err(err))
+			if !sleep(ctx, o.backoff(attempt)) {
+				return ctx.Err()
+			}
+			attempt++
+			continue
+		}
+		attempt = 0
+		if !lead(ctx, lock, callbacks) {
+			return ctx.Err()
+		}
+	}
+}
+
+// lead runs OnElected while lock is held, then releases the lock. It reports whether the
+// leadership was lost while held.
+func lead(ctx context.Context, lock *Lock, callbacks LeaderCallbacks) (lost bool) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-leaderCtx.Done():
+		}
+	}()
+	callbacks.OnElected(leaderCtx)
+	cancel()
+	select {
+	case <-lock.Lost():
+		lost = true
+	default:
+	}
+	releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), constant.DefaultTimeout)
+	defer cancelRelease()
+	if err := lock.Release(releaseCtx); err != nil && !lost {
+		log.Warn("cache leadership release failed", log.String("key", lock.key), log.Err(err))
+	}
+	if callbacks.OnDemoted != nil {
+		callbacks.OnDemoted()
+	}
+	return lost
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElect(t *testing.T) {
+	opts := []LockOption{WithLockTTL(30 * time.Millisecond), WithLockBackoff(5*time.Millisecond, 10*time.Millisecond)}
+
+	t.Run("Demoted leaders campaign again", func(t *testing.T) {
+		// Arrange
+		server := useMiniredis(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var events []string
+
+		// Act: the first leadership is lost, the second one ends with ctx.
+		err := Elect(ctx, "scan", LeaderCallbacks{
+			OnElected: func(leaderCtx context.Context) {
+				events = append(events, "elected")
+				if len(events) == 1 {
+					server.Del("lock:scan")
+				} else {
+					cancel()
+				}
+				<-leaderCtx.Done()
+			},
+			OnDemoted: func() {
+				events = append(events, "demoted")
+			},
+		}, opts...)
+
+		// Assert
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, []string{"elected", "demoted", "elected", "demoted"}, events)
+		assert.False(t, server.Exists("lock:scan"), "the leadership is released")
+	})
+
+	t.Run("One leader at a time", func(t *testing.T) {
+		// Arrange
+		useMiniredis(t)
+		ctx := context.Background()
+		leader, err := TryLock(ctx, "scan")
+		require.NoError(t, err)
+		electCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		elected := false
+
+		// Act
+		err = Elect(electCtx, "scan", LeaderCallbacks{
+			OnElected: func(context.Context) { elected = true },
+		}, opts...)
+
+		// Assert
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.False(t, elected)
+		assert.NoError(t, leader.Release(ctx))
+	})
+
+	t.Run("Returns when OnElected gives up the leadership", func(t *testing.T) {
+		// Arrange
+		server := useMiniredis(t)
+
+		// Act
+		err := Elect(context.Background(), "scan", LeaderCallbacks{
+			OnElected: func(context.Context) {},
+		}, opts...)
+
+		// Assert
+		require.NoError(t, err)
+		assert.False(t, server.Exists("lock:scan"))
+	})
+}
@@ -11,9 +11,17 @@ import (
 var (
 	ErrCacheNotConnected = errors.New("cache not connected")
 	ErrCacheQueryFailed  = errors.New("cache query failed")
+	ErrCacheMiss         = errors.New("cache miss")
+	// ErrLockNotAcquired is returned when a lock is held by someone else.
+	ErrLockNotAcquired = errors.New("cache lock not acquired")
+	// ErrLockNotHeld is returned when releasing a lock that was lost or already released.
+	ErrLockNotHeld = errors.New("cache lock not held")
 
 	StatusCacheNotConnected = status.New(codes.Aborted, "cache not connected")
 	StatusCacheQueryFailed  = status.New(codes.Internal, "cache query failed")
+	StatusCacheMiss         = status.New(codes.NotFound, "cache miss")
+	StatusLockNotAcquired   = status.New(codes.Aborted, "cache lock not acquired")
+	StatusLockNotHeld       = status.New(codes.FailedPrecondition, "cache lock not held")
 )
 
 func ToStatus(err error) *status.Status {
@@ -27,6 +35,12 @@ func ToStatus(err error) *status.Status {
 		baseSt = StatusCacheNotConnected
 	case errors.Is(err, ErrCacheQueryFailed):
 		baseSt = StatusCacheQueryFailed
+	case errors.Is(err, ErrCacheMiss):
+		baseSt = StatusCacheMiss
+	case errors.Is(err, ErrLockNotAcquired):
+		baseSt = StatusLockNotAcquired
+	case errors.Is(err, ErrLockNotHeld):
+		baseSt = StatusLockNotHeld
 	default:
 		// For unhandled errors, create a generic internal error status.
 		return status.New(codes.Internal, err.Error())
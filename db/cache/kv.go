@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Get returns the value stored under key, or ErrCacheMiss when there is none.
+func Get(ctx context.Context, key string) ([]byte, error) {
+	if conn == nil {
+		return nil, ErrCacheNotConnected
+	}
+	val, err := conn.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	return val, nil
+}
+
+// Set stores value under key. The key expires after ttl, or never when ttl is 0.
+func Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if conn == nil {
+		return ErrCacheNotConnected
+	}
+	if err := conn.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	return nil
+}
+
+// Del removes keys and returns how many existed.
+func Del(ctx context.Context, keys ...string) (int64, error) {
+	if conn == nil {
+		return 0, ErrCacheNotConnected
+	}
+	n, err := conn.Del(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	lockKeyPrefix         = "lock:"
+	defaultLockTTL        = 30 * time.Second
+	defaultLockMinBackoff = 50 * time.Millisecond
+	defaultLockMaxBackoff = 2 * time.Second
+)
+
+var (
+	// releaseScript deletes the lock only if it still holds the token of its owner.
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	// extendScript resets the expiry of the lock only if it still holds the token of its owner.
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type lockOptions struct {
+	ttl        time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// LockOption configures TryLock, AcquireLock and Elect.
+type LockOption func(*lockOptions)
+
+// WithLockTTL sets the lease of a lock: the lock expires when its holder stops extending it
+// for that long, e.g. after a crash. Defaults to 30s.
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockBackoff sets the delays between the attempts of AcquireLock, which double from
+// minDelay up to maxDelay. Defaults to 50ms and 2s.
+func WithLockBackoff(minDelay, maxDelay time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.minBackoff = minDelay
+		o.maxBackoff = maxDelay
+	}
+}
+
+func newLockOptions(opts []LockOption) lockOptions {
+	o := lockOptions{ttl: defaultLockTTL, minBackoff: defaultLockMinBackoff, maxBackoff: defaultLockMaxBackoff}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl <= 0 {
+		o.ttl = defaultLockTTL
+	}
+	if o.minBackoff <= 0 {
+		o.minBackoff = defaultLockMinBackoff
+	}
+	o.maxBackoff = max(o.maxBackoff, o.minBackoff)
+	return o
+}
+
+// backoff returns the delay before the given retry, with jitter so that the replicas
+// waiting for a lock do not retry in step.
+func (o lockOptions) backoff(attempt int) time.Duration {
+	delay := o.maxBackoff
+	if attempt < 32 {
+		delay = min(o.minBackoff<<attempt, o.maxBackoff)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Lock is a distributed lock held in Redis under the key "lock:<name>".
+// The key stores a random token, so that only its holder can extend or release it.
+// While the lock is held, its lease is extended in the background every third of its TTL.
+type Lock struct {
+	lost    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	key     string
+	token   string
+	options lockOptions
+	release sync.Once
+}
+
+// TryLock acquires the lock name if it is free, and returns ErrLockNotAcquired otherwise.
+func TryLock(ctx context.Context, name string, opts ...LockOption) (*Lock, error) {
+	if conn == nil {
+		return nil, ErrCacheNotConnected
+	}
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{key: lockKeyPrefix + name, token: token, options: newLockOptions(opts)}
+	ok, err := conn.SetNX(ctx, l.key, l.token, l.options.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrLockNotAcquired, name)
+	}
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.keepAlive()
+	return l, nil
+}
+
+// AcquireLock waits until it acquires the lock name, retrying with backoff while another
+// holder has it. It returns the error of ctx, wrapped with ErrLockNotAcquired, when ctx is
+// done first, and query errors as they occur.
+//
+// Example:
+//
+//	lock, err := cache.AcquireLock(ctx, "jobs:expire-sessions")
+//	if err != nil {
+//		return err
+//	}
+//	defer lock.Release(context.Background())
+func AcquireLock(ctx context.Context, name string, opts ...LockOption) (*Lock, error) {
+	o := newLockOptions(opts)
+	for attempt := 0; ; attempt++ {
+		l, err := TryLock(ctx, name, opts...)
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return l, err
+		}
+		if !sleep(ctx, o.backoff(attempt)) {
+			return nil, fmt.Errorf("%w: %s: %w", ErrLockNotAcquired, name, ctx.Err())
+		}
+	}
+}
+
+// sleep waits for d and reports whether ctx was still active by then.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Lost returns a channel closed when the lock is lost while held: its key expired or was
+// taken over because it could not be extended in time. Work protected by the lock should
+// stop then.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops extending the lock and deletes it. It returns ErrLockNotHeld if the lock
+// was lost or already released.
+func (l *Lock) Release(ctx context.Context) error {
+	released := false
+	l.release.Do(func() {
+		close(l.stop)
+		<-l.done
+		released = true
+	})
+	if !released {
+		return ErrLockNotHeld
+	}
+	if conn == nil {
+		return ErrCacheNotConnected
+	}
+	n, err := releaseScript.Run(ctx, conn, []string{l.key}, l.token).Int64()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrLockNotHeld, l.key)
+	}
+	return nil
+}
+
+// keepAlive extends the lease of the lock until it is released or lost. Extensions that fail
+// are retried until the lease expires.
+func (l *Lock) keepAlive() {
+	defer close(l.done)
+	interval := max(l.options.ttl/3, time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expiry := time.Now().Add(l.options.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		start := time.Now()
+		n, err := extendScript.Run(ctx, conn, []string{l.key}, l.token, l.options.ttl.Milliseconds()).Int64()
+		cancel()
+		switch {
+		case err == nil && n == 1:
+			expiry = start.Add(l.options.ttl)
+			continue
+		case err == nil:
+			// The key expired or belongs to another holder.
+		case time.Now().Before(expiry):
+			log.Warn("cache lock extension failed", log.String("key", l.key), log.Err(err))
+			continue
+		}
+		log.Warn("cache lock lost", log.String("key", l.key))
+		close(l.lost)
+		return
+	}
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCacheQueryFailed, err)
+	}
+	return hex.EncodeToString(b), nil
+}
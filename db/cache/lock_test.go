@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useMiniredis points the connection of the package at an in-memory Redis server for the test.
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	original := conn
+	conn = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = conn.Close()
+		conn = original
+	})
+	return server
+}
+
+// waitLost fails the test if lock is not lost within a second.
+func waitLost(t *testing.T, lock *Lock) {
+	t.Helper()
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("the lock was not lost")
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("A held lock is not acquired again", func(t *testing.T) {
+		// Arrange
+		useMiniredis(t)
+		lock, err := TryLock(ctx, "job")
+		require.NoError(t, err)
+		defer lock.Release(ctx)
+
+		// Act
+		_, err = TryLock(ctx, "job")
+
+		// Assert
+		require.ErrorIs(t, err, ErrLockNotAcquired)
+	})
+
+	t.Run("A released lock is acquired again", func(t *testing.T) {
+		// Arrange
+		server := useMiniredis(t)
+		lock, err := TryLock(ctx, "job")
+		require.NoError(t, err)
+
+		// Act
+		require.NoError(t, lock.Release(ctx))
+		again, err := TryLock(ctx, "job")
+
+		// Assert
+		require.NoError(t, err)
+		defer again.Release(ctx)
+		assert.True(t, server.Exists("lock:job"))
+		assert.ErrorIs(t, lock.Release(ctx), ErrLockNotHeld)
+	})
+
+	t.Run("Not connected", func(t *testing.T) {
+		// Arrange
+		original := conn
+		conn = nil
+		defer func() { conn = original }()
+
+		// Act
+		_, err := TryLock(ctx, "job")
+
+		// Assert
+		require.ErrorIs(t, err, ErrCacheNotConnected)
+	})
+}
+
+func TestAcquireLock(t *testing.T) {
+	// Arrange
+	useMiniredis(t)
+	ctx := context.Background()
+	holder, err := TryLock(ctx, "job")
+	require.NoError(t, err)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	// The deadline passes while waiting for the next attempt.
+	_, err = AcquireLock(timeoutCtx, "job", WithLockBackoff(time.Second, time.Second))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = holder.Release(ctx)
+	}()
+	lock, acquireErr := AcquireLock(ctx, "job", WithLockBackoff(5*time.Millisecond, 10*time.Millisecond))
+
+	// Assert
+	require.ErrorIs(t, err, ErrLockNotAcquired)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, acquireErr)
+	assert.NoError(t, lock.Release(ctx))
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("The lease is extended while held", func(t *testing.T) {
+		// Arrange
+		server := useMiniredis(t)
+		lock, err := TryLock(ctx, "job", WithLockTTL(30*time.Millisecond))
+		require.NoError(t, err)
+		defer lock.Release(ctx)
+
+		// Act
+		time.Sleep(100 * time.Millisecond)
+
+		// Assert
+		assert.Equal(t, 30*time.Millisecond, server.TTL("lock:job"))
+		select {
+		case <-lock.Lost():
+			t.Fatal("the lock was lost")
+		default:
+		}
+	})
+
+	t.Run("Lost closes when the key is deleted", func(t *testing.T) {
+		// Arrange
+		server := useMiniredis(t)
+		lock, err := TryLock(ctx, "job", WithLockTTL(30*time.Millisecond))
+		require.NoError(t, err)
+
+		// Act
+		server.Del("lock:job")
+
+		// Assert
+		waitLost(t, lock)
+		assert.ErrorIs(t, lock.Release(ctx), ErrLockNotHeld)
+	})
+
+	t.Run("A stale holder cannot release the lock of another", func(t *testing.T) {
+		// Arrange: the lease of the first holder expired and the lock was taken over.
+		server := useMiniredis(t)
+		stale, err := TryLock(ctx, "job", WithLockTTL(30*time.Millisecond))
+		require.NoError(t, err)
+		server.Del("lock:job")
+		waitLost(t, stale)
+		holder, err := TryLock(ctx, "job")
+		require.NoError(t, err)
+		defer holder.Release(ctx)
+
+		// Act
+		err = stale.Release(ctx)
+
+		// Assert
+		require.ErrorIs(t, err, ErrLockNotHeld)
+		assert.True(t, server.Exists("lock:job"))
+	})
+}
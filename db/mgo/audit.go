@@ -0,0 +1,566 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	defaultAuditBufferSize    = 1024
+	defaultAuditBatchSize     = 100
+	defaultAuditFlushInterval = time.Second
+)
+
+// AuditEntry records a write made through the helpers.
+type AuditEntry struct {
+	Time time.Time `bson:"time"`
+	// DocumentID is the _id of the written document, when the write targets a single one.
+	DocumentID any         `bson:"document_id,omitempty"`
+	Actor      *AuditActor `bson:"actor,omitempty"`
+	// Operation is the name of the write, as used for the trace spans, e.g. "updateOne".
+	Operation  string `bson:"operation"`
+	Collection string `bson:"collection"`
+	Tenant     string `bson:"tenant,omitempty"`
+	// Filter and Update are the statement of updates, deletes and bulk operations.
+	Filter bson.Raw `bson:"filter,omitempty"`
+	Update bson.Raw `bson:"update,omitempty"`
+	// Before and After are the document before and after a single-document write, when known.
+	Before  bson.Raw      `bson:"before,omitempty"`
+	After   bson.Raw      `bson:"after,omitempty"`
+	Changes []AuditChange `bson:"changes,omitempty"`
+}
+
+// AuditChange is a field whose value differs between the Before and After documents of an entry.
+// Before or After is empty when the field was added or removed.
+type AuditChange struct {
+	Before bson.RawValue `bson:"before,omitempty"`
+	After  bson.RawValue `bson:"after,omitempty"`
+	// Field is the dotted path of the field. Arrays are compared as a whole.
+	Field string `bson:"field"`
+}
+
+// AuditActor is the user on whose behalf a write was made.
+type AuditActor struct {
+	ID      string `bson:"id"`
+	Account string `bson:"account,omitempty"`
+	Name    string `bson:"name,omitempty"`
+	Email   string `bson:"email,omitempty"`
+}
+
+// AuditSink stores audit entries. WriteAudit is called from a single goroutine.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, entries []AuditEntry) error
+}
+
+// AuditOptions configures the audit trail, see SetAudit.
+type AuditOptions struct {
+	// Sink stores the entries, e.g. NewMongoAuditSink("audit_logs"). Auditing is disabled when nil.
+	Sink AuditSink
+	// Actor returns the user of a write from its context. Defaults to MetadataActor.
+	Actor func(ctx context.Context) *AuditActor
+	// RedactFields lists the fields whose values are replaced by "<redacted>", as for the slow
+	// query log. The values of encrypted fields are always redacted.
+	RedactFields []string
+	// BufferSize is the number of entries waiting to be written beyond which new entries are
+	// dropped, so that writes never wait for the sink. Defaults to 1024.
+	BufferSize int
+	// BatchSize is the maximum number of entries passed to the sink at once. Defaults to 100.
+	BatchSize int
+	// FlushInterval is the longest time an entry waits for its batch to fill. Defaults to 1s.
+	FlushInterval time.Duration
+	// WithoutDiff skips reading the documents before and after single-document writes, which
+	// costs up to two reads per write. Entries then only hold the statement of the write.
+	WithoutDiff bool
+}
+
+// auditor writes the entries of the writes to its sink in the background.
+type auditor struct {
+	entries chan AuditEntry
+	stop    chan struct{}
+	done    chan struct{}
+	options AuditOptions
+}
+
+var auditLog *auditor
+
+// SetAudit records the writes of Save, UpdateOne, UpdateById, UpdateMany, ReplaceOne, DeleteOne,
+// DeleteById, DeleteMany and bulk operations:
+//
+//	restore := mgo.SetAudit(mgo.AuditOptions{
+//		Sink:         mgo.NewMongoAuditSink("audit_logs"),
+//		RedactFields: []string{"password"},
+//	})
+//	defer restore()
+//
+// Each entry holds the collection, the operation, the document _id, the actor and the changed
+// fields. Single-document writes read the document before and after the write to compute
+// the changes; this is not atomic with the write, so a concurrent write may show in the diff.
+// Entries are written asynchronously in batches, and dropped with a warning when the sink
+// falls behind. Failed writes are not recorded.
+//
+// It returns a function restoring the previous options, which waits for the queued entries
+// to be written.
+func SetAudit(o AuditOptions) (restore func()) {
+	original := auditLog
+	auditLog = newAuditor(o)
+	return func() {
+		current := auditLog
+		auditLog = original
+		current.close()
+	}
+}
+
+func newAuditor(o AuditOptions) *auditor {
+	if o.Sink == nil {
+		return nil
+	}
+	if o.Actor == nil {
+		o.Actor = MetadataActor
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = defaultAuditBufferSize
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultAuditBatchSize
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultAuditFlushInterval
+	}
+	a := &auditor{
+		entries: make(chan AuditEntry, o.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		options: o,
+	}
+	go a.run()
+	return a
+}
+
+// Metadata keys of the user, as set by ezgrpc.
+const (
+	metadataUserID      = "user-id"
+	metadataUserAccount = "user-account"
+	metadataUserName    = "user-name"
+	metadataUserEmail   = "user-email"
+)
+
+// MetadataActor returns the user of the incoming gRPC metadata, as read by ezgrpc.GetUser,
+// or nil when there is none.
+func MetadataActor(ctx context.Context) *AuditActor {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	id := get(metadataUserID)
+	if id == "" {
+		return nil
+	}
+	return &AuditActor{
+		ID:      id,
+		Account: get(metadataUserAccount),
+		Name:    get(metadataUserName),
+		Email:   get(metadataUserEmail),
+	}
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.options.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]AuditEntry, 0, a.options.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := a.options.Sink.WriteAudit(context.Background(), batch); err != nil {
+			log.Warn("mongo audit write failed", log.Int("entries", len(batch)), log.Err(err))
+		}
+		batch = make([]AuditEntry, 0, a.options.BatchSize)
+	}
+	add := func(entry AuditEntry) {
+		batch = append(batch, entry)
+		if len(batch) == a.options.BatchSize {
+			flush()
+		}
+	}
+	for {
+		select {
+		case entry := <-a.entries:
+			add(entry)
+		case <-ticker.C:
+			flush()
+		case <-a.stop:
+			// Write the queued entries before stopping.
+			for {
+				select {
+				case entry := <-a.entries:
+					add(entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// close stops the auditor once the queued entries are written.
+func (a *auditor) close() {
+	if a == nil {
+		return
+	}
+	close(a.stop)
+	<-a.done
+}
+
+func (a *auditor) enqueue(entry AuditEntry) {
+	select {
+	case a.entries <- entry:
+	default:
+		log.Warn("mongo audit entry dropped",
+			log.String("collection", entry.Collection), log.String("operation", entry.Operation))
+	}
+}
+
+// auditBefore reads the document that a single-document write with filter is about to modify,
+// when auditing with diffs. It returns nil when the document cannot be read.
+func auditBefore(ctx context.Context, store Datastore, collection string, filter any) bson.Raw {
+	if auditLog == nil || auditLog.options.WithoutDiff {
+		return nil
+	}
+	return auditRead(ctx, store, collection, filter)
+}
+
+// auditAfter reads the document with the given _id after a write, when auditing with diffs.
+func auditAfter(ctx context.Context, store Datastore, collection string, id any) bson.Raw {
+	if auditLog == nil || auditLog.options.WithoutDiff || id == nil {
+		return nil
+	}
+	return auditRead(ctx, store, collection, bson.D{bson.E{Key: "_id", Value: id}})
+}
+
+func auditRead(ctx context.Context, store Datastore, collection string, filter any) bson.Raw {
+	raw, err := store.FindOne(ctx, collection, filter).Raw()
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// auditDocument returns doc encoded for an entry, when auditing with diffs.
+func auditDocument(doc any) bson.Raw {
+	if auditLog == nil || auditLog.options.WithoutDiff {
+		return nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// auditWrite describes a write to record.
+type auditWrite struct {
+	// model is a model of the collection, which tells the encrypted fields.
+	model      any
+	id         any
+	filter     any
+	update     any
+	collection string
+	operation  string
+	before     bson.Raw
+	after      bson.Raw
+}
+
+// recordAudit queues the entry of w, when auditing is enabled. Before and after may be
+// encrypted, as read from the database, or not.
+func recordAudit(ctx context.Context, w auditWrite) {
+	a := auditLog
+	if a == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:       time.Now(),
+		DocumentID: w.id,
+		Actor:      a.options.Actor(ctx),
+		Operation:  w.operation,
+		Collection: w.collection,
+	}
+	if tenant, ok, err := resolveTenant(ctx); err == nil && ok {
+		entry.Tenant = tenant
+	}
+	if entry.DocumentID == nil {
+		entry.DocumentID = documentID(w.after, w.before)
+	}
+	fields := encryptedFieldsOf(reflect.TypeOf(w.model))
+	// Diff the values in clear, then redact them.
+	before, errBefore := auditOpen(ctx, w.model, w.before)
+	after, errAfter := auditOpen(ctx, w.model, w.after)
+	if errBefore == nil && errAfter == nil && (before != nil || after != nil) {
+		entry.Changes = diffDocuments(before, after)
+		for i := range entry.Changes {
+			change := &entry.Changes[i]
+			change.Before = a.redactValue(fields, change.Field, change.Before)
+			change.After = a.redactValue(fields, change.Field, change.After)
+		}
+	}
+	entry.Before = a.redactDocument(fields, before)
+	entry.After = a.redactDocument(fields, after)
+	entry.Filter = a.redactDocument(nil, w.filter)
+	entry.Update = a.redactDocument(nil, w.update)
+	a.enqueue(entry)
+}
+
+// auditOpen decrypts doc, a document of the collection of model, if any.
+func auditOpen(ctx context.Context, model any, doc bson.Raw) (bson.Raw, error) {
+	if doc == nil {
+		return nil, nil
+	}
+	return openDocument(ctx, reflect.TypeOf(model), doc)
+}
+
+// documentID returns the _id of the first of docs holding one.
+func documentID(docs ...bson.Raw) any {
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		if id, err := doc.LookupErr("_id"); err == nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// redactValue redacts the value of the field at path if it is encrypted or listed in RedactFields,
+// or the encrypted and listed fields of its documents.
+func (a *auditor) redactValue(fields *encryptedField, path string, rv bson.RawValue) bson.RawValue {
+	if rv.IsZero() {
+		return rv
+	}
+	if isRedacted(path, a.options.RedactFields) {
+		redacted, _ := toRawValue(redactedValue, nil)
+		return redacted
+	}
+	if node, below := fields.lookup(path); node != nil {
+		if below {
+			redacted, _ := toRawValue(redactedValue, nil)
+			return redacted
+		}
+		if transformed, err := node.transform(rv, redactEncrypted); err == nil {
+			rv = transformed
+		}
+	}
+	raw, err := bson.Marshal(bson.D{bson.E{Key: "v", Value: redact(rv, a.options.RedactFields)}})
+	if err != nil {
+		return rv
+	}
+	return bson.Raw(raw).Lookup("v")
+}
+
+// redactDocument returns doc, a document or statement, with its encrypted fields and the fields
+// listed in RedactFields redacted. Statements are sealed, so their encrypted values are redacted
+// wherever they are.
+func (a *auditor) redactDocument(fields *encryptedField, doc any) bson.Raw {
+	if doc == nil {
+		return nil
+	}
+	if raw, ok := doc.(bson.Raw); ok {
+		if raw == nil {
+			return nil
+		}
+		if fields != nil {
+			if redacted, err := transformDocument(fields, raw, redactEncrypted); err == nil {
+				doc = redacted
+			}
+		}
+	}
+	data, err := bson.Marshal(bson.D{bson.E{Key: "v", Value: doc}})
+	if err != nil {
+		return nil
+	}
+	var wrapped bson.D
+	if err := bson.Unmarshal(data, &wrapped); err != nil || len(wrapped) != 1 {
+		return nil
+	}
+	document, ok := redactCiphertexts(redactValue(wrapped[0].Value, a.options.RedactFields)).(bson.D)
+	if !ok {
+		return nil
+	}
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// redactCiphertexts replaces the encrypted values of v, a decoded document, by redactedValue.
+func redactCiphertexts(v any) any {
+	switch v := v.(type) {
+	case bson.D:
+		for i, e := range v {
+			v[i].Value = redactCiphertexts(e.Value)
+		}
+		return v
+	case bson.A:
+		for i, e := range v {
+			v[i] = redactCiphertexts(e)
+		}
+		return v
+	case bson.Binary:
+		if v.Subtype == encryptedSubtype {
+			return redactedValue
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func redactEncrypted(_ *encryptedField, rv bson.RawValue) (bson.RawValue, error) {
+	if rv.Type == bson.TypeNull {
+		return rv, nil
+	}
+	return toRawValue(redactedValue, nil)
+}
+
+// diffDocuments lists the fields whose values differ between before and after, sorted by path.
+func diffDocuments(before, after bson.Raw) []AuditChange {
+	beforeValues := map[string]bson.RawValue{}
+	afterValues := map[string]bson.RawValue{}
+	flattenDocument(before, "", beforeValues)
+	flattenDocument(after, "", afterValues)
+	var changes []AuditChange
+	for path, b := range beforeValues {
+		if a, ok := afterValues[path]; !ok || !a.Equal(b) {
+			changes = append(changes, AuditChange{Field: path, Before: b, After: a})
+		}
+	}
+	for path, a := range afterValues {
+		if _, ok := beforeValues[path]; !ok {
+			changes = append(changes, AuditChange{Field: path, After: a})
+		}
+	}
+	slices.SortFunc(changes, func(x, y AuditChange) int {
+		return strings.Compare(x.Field, y.Field)
+	})
+	return changes
+}
+
+// flattenDocument adds the values of doc to values by dotted path, descending into embedded
+// documents but not into arrays.
+func flattenDocument(doc bson.Raw, prefix string, values map[string]bson.RawValue) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return
+	}
+	for _, e := range elements {
+		path := e.Key()
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		value := e.Value()
+		// Empty documents are kept as values, so that they differ from missing ones.
+		if nested, ok := value.DocumentOK(); ok {
+			if elements, err := nested.Elements(); err == nil && len(elements) > 0 {
+				flattenDocument(nested, path, values)
+				continue
+			}
+		}
+		values[path] = value
+	}
+}
+
+// auditBulk records the operations of a bulk write that were executed.
+func auditBulk(ctx context.Context, collection string, operations []mongo.WriteModel, executed []bool) {
+	if auditLog == nil {
+		return
+	}
+	for i, operation := range operations {
+		if !executed[i] {
+			continue
+		}
+		w := auditWrite{collection: collection}
+		switch m := operation.(type) {
+		case *mongo.InsertOneModel:
+			w.operation, w.model, w.after = "bulk.insertOne", m.Document, auditDocument(m.Document)
+			if doc, ok := m.Document.(DocInter); ok && !reflect.ValueOf(doc.GetId()).IsZero() {
+				w.id = doc.GetId()
+			}
+		case *mongo.ReplaceOneModel:
+			w.operation, w.model, w.filter = "bulk.replaceOne", m.Replacement, m.Filter
+			w.after = auditDocument(m.Replacement)
+		case *mongo.UpdateOneModel:
+			w.operation, w.filter, w.update = "bulk.updateOne", m.Filter, m.Update
+		case *mongo.UpdateManyModel:
+			w.operation, w.filter, w.update = "bulk.updateMany", m.Filter, m.Update
+		case *mongo.DeleteOneModel:
+			w.operation, w.filter = "bulk.deleteOne", m.Filter
+		case *mongo.DeleteManyModel:
+			w.operation, w.filter = "bulk.deleteMany", m.Filter
+		default:
+			continue
+		}
+		recordAudit(ctx, w)
+	}
+}
+
+// mongoAuditSink writes audit entries to a collection.
+type mongoAuditSink struct {
+	db         *DB
+	collection string
+}
+
+// NewMongoAuditSink returns an AuditSink inserting the entries into the given collection of the
+// connected database. With SetTenancy, the entries of a tenant go to its own collection.
+func NewMongoAuditSink(collection string) AuditSink {
+	return NewMongoAuditSinkOn(defaultDB, collection)
+}
+
+// NewMongoAuditSinkOn is like NewMongoAuditSink but writes to db.
+func NewMongoAuditSinkOn(db *DB, collection string) AuditSink {
+	return &mongoAuditSink{db: db, collection: collection}
+}
+
+func (s *mongoAuditSink) WriteAudit(ctx context.Context, entries []AuditEntry) error {
+	store := s.db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	byTenant := map[string][]mongo.WriteModel{}
+	var tenants []string
+	for _, entry := range entries {
+		if _, ok := byTenant[entry.Tenant]; !ok {
+			tenants = append(tenants, entry.Tenant)
+		}
+		byTenant[entry.Tenant] = append(byTenant[entry.Tenant], mongo.NewInsertOneModel().SetDocument(entry))
+	}
+	for _, tenant := range tenants {
+		tenantCtx := ctx
+		if tenant != "" {
+			tenantCtx = WithTenant(ctx, tenant)
+		}
+		_, err := store.BulkWrite(tenantCtx, s.collection, byTenant[tenant], options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrWriteFailed, err)
+		}
+	}
+	return nil
+}
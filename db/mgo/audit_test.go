@@ -0,0 +1,184 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"google.golang.org/grpc/metadata"
+)
+
+// memoryAuditSink keeps the entries it is given.
+type memoryAuditSink struct {
+	entries []AuditEntry
+}
+
+func (s *memoryAuditSink) WriteAudit(_ context.Context, entries []AuditEntry) error {
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	// Arrange
+	defer SetKeyProvider(NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)}))()
+	defer SetDatastore(NewMemoryDatastore())()
+	sink := &memoryAuditSink{}
+	restore := SetAudit(AuditOptions{Sink: sink, RedactFields: []string{"label"}})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		metadataUserID, "u1", metadataUserName, "Peter",
+	))
+	user := &secretUser{
+		Name: "alice", Email: "alice@example.com",
+		Contacts: []secretContact{{Phone: "0912", Label: "home"}},
+	}
+
+	// Act
+	_, err := Save(ctx, user)
+	require.NoError(t, err)
+	_, err = UpdateById(ctx, user, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: "bob"},
+		{Key: "email", Value: "bob@example.com"},
+	}}})
+	require.NoError(t, err)
+	_, err = UpdateById(ctx, &secretUser{ID: bson.NewObjectID()}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: "nobody"},
+	}}})
+	require.NoError(t, err)
+	_, err = DeleteById(ctx, user)
+	require.NoError(t, err)
+	restore()
+
+	// Assert: writes that changed nothing are not recorded.
+	require.Len(t, sink.entries, 3)
+	save, update, del := sink.entries[0], sink.entries[1], sink.entries[2]
+	for i, op := range []string{"save", "updateOne", "deleteById"} {
+		entry := sink.entries[i]
+		assert.Equal(t, op, entry.Operation)
+		assert.Equal(t, "secret_users", entry.Collection)
+		assert.Equal(t, &AuditActor{ID: "u1", Name: "Peter"}, entry.Actor)
+		assert.False(t, entry.Time.IsZero())
+	}
+	assert.Equal(t, user.ID, save.DocumentID)
+	assert.Nil(t, save.Before)
+	assert.Equal(t, "alice", save.After.Lookup("name").StringValue())
+
+	t.Run("Updates hold the changed fields", func(t *testing.T) {
+		id, ok := update.DocumentID.(bson.RawValue)
+		require.True(t, ok)
+		assert.Equal(t, user.ID, id.ObjectID())
+		assert.Equal(t, "$set", update.Update.Index(0).Key())
+		require.Len(t, update.Changes, 2)
+		assert.Equal(t, "email", update.Changes[0].Field)
+		assert.Equal(t, "name", update.Changes[1].Field)
+		assert.Equal(t, "alice", update.Changes[1].Before.StringValue())
+		assert.Equal(t, "bob", update.Changes[1].After.StringValue())
+	})
+
+	t.Run("Encrypted and listed fields are redacted", func(t *testing.T) {
+		for _, value := range []bson.RawValue{
+			update.Changes[0].Before,
+			update.Changes[0].After,
+			update.Before.Lookup("email"),
+			update.After.Lookup("email"),
+			save.After.Lookup("contacts").Array().Index(0).Document().Lookup("phone"),
+			save.After.Lookup("contacts").Array().Index(0).Document().Lookup("label"),
+		} {
+			assert.Equal(t, redactedValue, value.StringValue())
+		}
+		assert.Equal(t, redactedValue, update.Update.Lookup("$set", "email").StringValue())
+	})
+
+	t.Run("Deletes hold the removed document", func(t *testing.T) {
+		assert.Equal(t, user.ID, del.DocumentID)
+		assert.Equal(t, "bob", del.Before.Lookup("name").StringValue())
+		assert.Nil(t, del.After)
+		assert.NotEmpty(t, del.Changes)
+	})
+}
+
+func TestAuditOptions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Without diff", func(t *testing.T) {
+		// Arrange
+		defer SetDatastore(NewMemoryDatastore())()
+		sink := &memoryAuditSink{}
+		restore := SetAudit(AuditOptions{Sink: sink, WithoutDiff: true})
+		user := &testUser{Name: "Peter", Age: 30}
+
+		// Act
+		_, err := Save(ctx, user)
+		require.NoError(t, err)
+		_, err = UpdateMany(ctx, user, bson.D{}, bson.D{{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}}})
+		require.NoError(t, err)
+		restore()
+
+		// Assert
+		require.Len(t, sink.entries, 2)
+		for _, entry := range sink.entries {
+			assert.Nil(t, entry.Actor)
+			assert.Nil(t, entry.Before)
+			assert.Nil(t, entry.After)
+			assert.Empty(t, entry.Changes)
+		}
+		assert.Equal(t, user.ID, sink.entries[0].DocumentID)
+		assert.Equal(t, "updateMany", sink.entries[1].Operation)
+		assert.Nil(t, sink.entries[1].DocumentID)
+		assert.Equal(t, int32(1), sink.entries[1].Update.Lookup("$inc", "age").Int32())
+	})
+
+	t.Run("Bulk operations", func(t *testing.T) {
+		// Arrange
+		defer SetDatastore(NewMemoryDatastore())()
+		sink := &memoryAuditSink{}
+		restore := SetAudit(AuditOptions{Sink: sink})
+		bulk, err := NewBulkOperation("users")
+		require.NoError(t, err)
+
+		// Act
+		_, err = bulk.
+			InsertOne(&testUser{Name: "Peter"}).
+			UpdateOne(bson.D{{Key: "name", Value: "Peter"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "age", Value: 3}}}}).
+			DeleteMany(bson.D{{Key: "age", Value: 3}}).
+			Execute(ctx)
+		require.NoError(t, err)
+		restore()
+
+		// Assert
+		var operations []string
+		for _, entry := range sink.entries {
+			operations = append(operations, entry.Operation)
+		}
+		assert.Equal(t, []string{"bulk.insertOne", "bulk.updateOne", "bulk.deleteMany"}, operations)
+		assert.Equal(t, "Peter", sink.entries[0].After.Lookup("name").StringValue())
+		assert.Equal(t, int32(3), sink.entries[2].Filter.Lookup("age").Int32())
+	})
+
+	t.Run("Writes are not recorded when disabled", func(t *testing.T) {
+		restore := SetAudit(AuditOptions{})
+		assert.Nil(t, auditLog)
+		restore()
+	})
+}
+
+func TestMongoAuditSink(t *testing.T) {
+	// Arrange
+	defer SetDatastore(NewMemoryDatastore())()
+	ctx := context.Background()
+	restore := SetAudit(AuditOptions{Sink: NewMongoAuditSink("audit_logs")})
+	user := &testUser{Name: "Peter"}
+
+	// Act
+	_, err := Save(ctx, user)
+	require.NoError(t, err)
+	_, err = DeleteById(ctx, user)
+	require.NoError(t, err)
+	restore()
+
+	// Assert
+	count, err := CountDocument(ctx, "audit_logs", bson.D{{Key: "document_id", Value: user.ID}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,364 @@
+package mgo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// FilterBuilder builds a query filter for the model T. Every field path is checked against
+// the bson tags of T when it is added, and the first unknown path is reported by Build:
+//
+//	filter, err := mgo.Filter[User]().
+//		Eq("email", email).
+//		Gte("age", 18).
+//		In("roles", "admin", "owner").
+//		Build()
+//
+// Conditions on the same field are combined, e.g. Gt("age", 18).Lt("age", 65) becomes
+// {age: {$gt: 18, $lt: 65}}.
+type FilterBuilder[T any] struct {
+	err  error
+	cond bson.D
+}
+
+// Filter returns an empty FilterBuilder for the model T, a struct or a pointer to one.
+func Filter[T any]() *FilterBuilder[T] {
+	return &FilterBuilder[T]{cond: bson.D{}}
+}
+
+// Eq matches documents whose field equals value.
+func (f *FilterBuilder[T]) Eq(field string, value any) *FilterBuilder[T] {
+	if !f.check(field) {
+		return f
+	}
+	for i, e := range f.cond {
+		if e.Key != field {
+			continue
+		}
+		if ops, ok := e.Value.(bson.D); ok && isOperatorDoc(ops) {
+			f.cond[i].Value = append(ops, bson.E{Key: "$eq", Value: value})
+			return f
+		}
+	}
+	f.cond = append(f.cond, bson.E{Key: field, Value: value})
+	return f
+}
+
+// Ne matches documents whose field does not equal value, including those without the field.
+func (f *FilterBuilder[T]) Ne(field string, value any) *FilterBuilder[T] {
+	return f.op(field, "$ne", value)
+}
+
+// Gt matches documents whose field is greater than value.
+func (f *FilterBuilder[T]) Gt(field string, value any) *FilterBuilder[T] {
+	return f.op(field, "$gt", value)
+}
+
+// Gte matches documents whose field is greater than or equal to value.
+func (f *FilterBuilder[T]) Gte(field string, value any) *FilterBuilder[T] {
+	return f.op(field, "$gte", value)
+}
+
+// Lt matches documents whose field is less than value.
+func (f *FilterBuilder[T]) Lt(field string, value any) *FilterBuilder[T] {
+	return f.op(field, "$lt", value)
+}
+
+// Lte matches documents whose field is less than or equal to value.
+func (f *FilterBuilder[T]) Lte(field string, value any) *FilterBuilder[T] {
+	return f.op(field, "$lte", value)
+}
+
+// In matches documents whose field equals one of values.
+func (f *FilterBuilder[T]) In(field string, values ...any) *FilterBuilder[T] {
+	return f.op(field, "$in", bson.A(values))
+}
+
+// Nin matches documents whose field equals none of values.
+func (f *FilterBuilder[T]) Nin(field string, values ...any) *FilterBuilder[T] {
+	return f.op(field, "$nin", bson.A(values))
+}
+
+// Exists matches documents that have the field, or that lack it when exists is false.
+func (f *FilterBuilder[T]) Exists(field string, exists bool) *FilterBuilder[T] {
+	return f.op(field, "$exists", exists)
+}
+
+// Regex matches documents whose string field matches pattern with the given options, e.g. "i".
+func (f *FilterBuilder[T]) Regex(field, pattern, options string) *FilterBuilder[T] {
+	return f.op(field, "$regex", bson.Regex{Pattern: pattern, Options: options})
+}
+
+// And matches documents that match all of filters.
+func (f *FilterBuilder[T]) And(filters ...*FilterBuilder[T]) *FilterBuilder[T] {
+	return f.logical("$and", filters)
+}
+
+// Or matches documents that match at least one of filters.
+func (f *FilterBuilder[T]) Or(filters ...*FilterBuilder[T]) *FilterBuilder[T] {
+	return f.logical("$or", filters)
+}
+
+// Nor matches documents that match none of filters.
+func (f *FilterBuilder[T]) Nor(filters ...*FilterBuilder[T]) *FilterBuilder[T] {
+	return f.logical("$nor", filters)
+}
+
+// Build returns the filter, or an error wrapping ErrInvalidDocument when a field path is not
+// a field of T.
+func (f *FilterBuilder[T]) Build() (bson.D, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.cond, nil
+}
+
+// String renders the filter as relaxed Extended JSON.
+func (f *FilterBuilder[T]) String() string {
+	return formatStatement(f.cond)
+}
+
+func (f *FilterBuilder[T]) check(field string) bool {
+	if f.err != nil {
+		return false
+	}
+	f.err = checkFieldPath[T](field)
+	return f.err == nil
+}
+
+func (f *FilterBuilder[T]) op(field, op string, value any) *FilterBuilder[T] {
+	if !f.check(field) {
+		return f
+	}
+	for i, e := range f.cond {
+		if e.Key != field {
+			continue
+		}
+		if ops, ok := e.Value.(bson.D); ok && isOperatorDoc(ops) {
+			f.cond[i].Value = append(ops, bson.E{Key: op, Value: value})
+		} else {
+			f.cond[i].Value = bson.D{bson.E{Key: "$eq", Value: e.Value}, bson.E{Key: op, Value: value}}
+		}
+		return f
+	}
+	f.cond = append(f.cond, bson.E{Key: field, Value: bson.D{bson.E{Key: op, Value: value}}})
+	return f
+}
+
+func (f *FilterBuilder[T]) logical(op string, filters []*FilterBuilder[T]) *FilterBuilder[T] {
+	if f.err != nil {
+		return f
+	}
+	clauses := make(bson.A, 0, len(filters))
+	for _, sub := range filters {
+		cond, err := sub.Build()
+		if err != nil {
+			f.err = err
+			return f
+		}
+		clauses = append(clauses, cond)
+	}
+	f.cond = append(f.cond, bson.E{Key: op, Value: clauses})
+	return f
+}
+
+func isOperatorDoc(d bson.D) bool {
+	return len(d) > 0 && strings.HasPrefix(d[0].Key, "$")
+}
+
+// UpdateBuilder builds an update document for the model T, checking field paths like FilterBuilder:
+//
+//	update, err := mgo.Update[User]().
+//		Set("name", name).
+//		Inc("login_count", 1).
+//		Build()
+type UpdateBuilder[T any] struct {
+	err    error
+	update bson.D
+}
+
+// Update returns an empty UpdateBuilder for the model T, a struct or a pointer to one.
+func Update[T any]() *UpdateBuilder[T] {
+	return &UpdateBuilder[T]{update: bson.D{}}
+}
+
+// Set assigns value to the field.
+func (u *UpdateBuilder[T]) Set(field string, value any) *UpdateBuilder[T] {
+	return u.op("$set", field, value)
+}
+
+// SetOnInsert assigns value to the field when an upsert inserts a document.
+func (u *UpdateBuilder[T]) SetOnInsert(field string, value any) *UpdateBuilder[T] {
+	return u.op("$setOnInsert", field, value)
+}
+
+// Unset removes the field.
+func (u *UpdateBuilder[T]) Unset(field string) *UpdateBuilder[T] {
+	return u.op("$unset", field, "")
+}
+
+// Inc adds delta to the numeric field.
+func (u *UpdateBuilder[T]) Inc(field string, delta any) *UpdateBuilder[T] {
+	return u.op("$inc", field, delta)
+}
+
+// Mul multiplies the numeric field by factor.
+func (u *UpdateBuilder[T]) Mul(field string, factor any) *UpdateBuilder[T] {
+	return u.op("$mul", field, factor)
+}
+
+// Min sets the field to value if value is less than its current value.
+func (u *UpdateBuilder[T]) Min(field string, value any) *UpdateBuilder[T] {
+	return u.op("$min", field, value)
+}
+
+// Max sets the field to value if value is greater than its current value.
+func (u *UpdateBuilder[T]) Max(field string, value any) *UpdateBuilder[T] {
+	return u.op("$max", field, value)
+}
+
+// Push appends values to the array field.
+func (u *UpdateBuilder[T]) Push(field string, values ...any) *UpdateBuilder[T] {
+	return u.op("$push", field, eachValue(values))
+}
+
+// AddToSet appends those of values that the array field does not contain yet.
+func (u *UpdateBuilder[T]) AddToSet(field string, values ...any) *UpdateBuilder[T] {
+	return u.op("$addToSet", field, eachValue(values))
+}
+
+// Pull removes the elements equal to value from the array field.
+func (u *UpdateBuilder[T]) Pull(field string, value any) *UpdateBuilder[T] {
+	return u.op("$pull", field, value)
+}
+
+// Build returns the update, or an error wrapping ErrInvalidDocument when a field path is not
+// a field of T or no operator was added.
+func (u *UpdateBuilder[T]) Build() (bson.D, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	if len(u.update) == 0 {
+		return nil, fmt.Errorf("%w: empty update", ErrInvalidDocument)
+	}
+	return u.update, nil
+}
+
+// String renders the update as relaxed Extended JSON.
+func (u *UpdateBuilder[T]) String() string {
+	return formatStatement(u.update)
+}
+
+func (u *UpdateBuilder[T]) op(op, field string, value any) *UpdateBuilder[T] {
+	if u.err != nil {
+		return u
+	}
+	if u.err = checkFieldPath[T](field); u.err != nil {
+		return u
+	}
+	for i, e := range u.update {
+		if e.Key == op {
+			fields, _ := e.Value.(bson.D)
+			u.update[i].Value = append(fields, bson.E{Key: field, Value: value})
+			return u
+		}
+	}
+	u.update = append(u.update, bson.E{Key: op, Value: bson.D{bson.E{Key: field, Value: value}}})
+	return u
+}
+
+// eachValue returns the argument of $push or $addToSet for values.
+func eachValue(values []any) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return bson.D{bson.E{Key: "$each", Value: bson.A(values)}}
+}
+
+// checkFieldPath returns an error when the dotted path does not name a field of T.
+// Paths may continue into the elements of slices directly or through an index or one of the
+// positional operators $, $[] and $[<identifier>]. Any path is accepted below maps, interfaces
+// and raw documents.
+func checkFieldPath[T any](path string) error {
+	root := reflect.TypeFor[T]()
+	t := root
+	segments := strings.Split(path, ".")
+	for len(segments) > 0 {
+		segment := segments[0]
+		segments = segments[1:]
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case t == rawType || t == dType:
+			return nil
+		case t == timeType || t == objectIDType:
+			return fmt.Errorf("%w: %q is not a field of %s", ErrInvalidDocument, path, root)
+		}
+		switch t.Kind() {
+		case reflect.Map, reflect.Interface:
+			return nil
+		case reflect.Slice, reflect.Array:
+			if !isArraySegment(segment) {
+				// Queries reach into the elements of arrays, e.g. "items.sku".
+				segments = append([]string{segment}, segments...)
+			}
+			t = t.Elem()
+		case reflect.Struct:
+			field, ok := structField(t, segment)
+			if !ok {
+				return fmt.Errorf("%w: %q is not a field of %s", ErrInvalidDocument, path, root)
+			}
+			t = field
+		default:
+			return fmt.Errorf("%w: %q is not a field of %s", ErrInvalidDocument, path, root)
+		}
+	}
+	return nil
+}
+
+func isArraySegment(segment string) bool {
+	if segment == "$" || (strings.HasPrefix(segment, "$[") && strings.HasSuffix(segment, "]")) {
+		return true
+	}
+	_, err := strconv.Atoi(segment)
+	return err == nil
+}
+
+// structField returns the type of the field of t stored under name, following inline fields.
+func structField(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tagName, flags, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if tagName == "-" {
+			continue
+		}
+		if strings.Contains(flags, "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Map {
+				return ft.Elem(), true
+			}
+			if field, ok := structField(ft, name); ok {
+				return field, true
+			}
+			continue
+		}
+		if tagName == "" {
+			tagName = strings.ToLower(f.Name)
+		}
+		if tagName == name {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,170 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type builderAddress struct {
+	City string `bson:"city"`
+}
+
+// builderUser exercises nested, inline and slice fields.
+type builderUser struct {
+	CreatedAt      time.Time         `bson:"created_at"`
+	Address        *builderAddress   `bson:"address"`
+	Labels         map[string]string `bson:"labels"`
+	mgo.SoftDelete `bson:",inline"`
+	Email          string           `bson:"email"`
+	Roles          []string         `bson:"roles"`
+	Previous       []builderAddress `bson:"previous"`
+	Age            int
+	ID             bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func TestFilterBuilder(t *testing.T) {
+	t.Run("Combines conditions", func(t *testing.T) {
+		// Act
+		filter, err := mgo.Filter[builderUser]().
+			Eq("email", "peter@x.io").
+			Gt("age", 18).
+			Lt("age", 65).
+			In("roles", "admin", "owner").
+			Eq("address.city", "Taipei").
+			Build()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{
+			{Key: "email", Value: "peter@x.io"},
+			{Key: "age", Value: bson.D{{Key: "$gt", Value: 18}, {Key: "$lt", Value: 65}}},
+			{Key: "roles", Value: bson.D{{Key: "$in", Value: bson.A{"admin", "owner"}}}},
+			{Key: "address.city", Value: "Taipei"},
+		}, filter)
+	})
+
+	t.Run("Logical operators", func(t *testing.T) {
+		// Act
+		filter, err := mgo.Filter[*builderUser]().
+			Exists("deleted_at", false).
+			Or(
+				mgo.Filter[*builderUser]().Eq("previous.city", "Tainan"),
+				mgo.Filter[*builderUser]().Regex("labels.team", "^core", "i"),
+			).
+			Build()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{
+			{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "previous.city", Value: "Tainan"}},
+				bson.D{{Key: "labels.team", Value: bson.D{{Key: "$regex", Value: bson.Regex{Pattern: "^core", Options: "i"}}}}},
+			}},
+		}, filter)
+	})
+
+	t.Run("String renders Extended JSON", func(t *testing.T) {
+		// Act
+		s := mgo.Filter[builderUser]().Eq("email", "a@x.io").Gte("age", 18).String()
+
+		// Assert
+		assert.Equal(t, `{"email":"a@x.io","age":{"$gte":18}}`, s)
+	})
+
+	tests := []struct {
+		build func() (bson.D, error)
+		name  string
+	}{
+		{name: "Unknown field", build: mgo.Filter[builderUser]().Eq("emial", "x").Build},
+		{name: "Untagged field uses the lowercase name", build: mgo.Filter[builderUser]().Eq("Age", 1).Build},
+		{name: "Unknown nested field", build: mgo.Filter[builderUser]().Eq("address.zip", "x").Build},
+		{name: "Path below a scalar", build: mgo.Filter[builderUser]().Eq("created_at.year", 1).Build},
+		{name: "Invalid sub-filter", build: mgo.Filter[builderUser]().Or(mgo.Filter[builderUser]().Eq("x", 1)).Build},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := tt.build()
+
+			// Assert
+			assert.ErrorIs(t, err, mgo.ErrInvalidDocument)
+		})
+	}
+}
+
+func TestUpdateBuilder(t *testing.T) {
+	t.Run("Groups fields by operator", func(t *testing.T) {
+		// Act
+		update, err := mgo.Update[builderUser]().
+			Set("email", "a@x.io").
+			Inc("age", 1).
+			Set("address.city", "Taipei").
+			Push("roles", "admin", "owner").
+			Unset("previous.0").
+			Build()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "email", Value: "a@x.io"}, {Key: "address.city", Value: "Taipei"}}},
+			{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}},
+			{Key: "$push", Value: bson.D{{Key: "roles", Value: bson.D{{Key: "$each", Value: bson.A{"admin", "owner"}}}}}},
+			{Key: "$unset", Value: bson.D{{Key: "previous.0", Value: ""}}},
+		}, update)
+	})
+
+	t.Run("Positional operators", func(t *testing.T) {
+		// Act
+		_, err := mgo.Update[builderUser]().Set("previous.$.city", "Taipei").Set("roles.$[r]", "x").Build()
+
+		// Assert
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		// Act
+		_, err := mgo.Update[builderUser]().Set("email", "a").Inc("agee", 1).Build()
+
+		// Assert
+		assert.ErrorIs(t, err, mgo.ErrInvalidDocument)
+	})
+
+	t.Run("Empty update", func(t *testing.T) {
+		// Act
+		_, err := mgo.Update[builderUser]().Build()
+
+		// Assert
+		assert.ErrorIs(t, err, mgo.ErrInvalidDocument)
+	})
+}
+
+func TestBuildersWithHelpers(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+	seedAccounts(t, 30, 20)
+	filter, err := mgo.Filter[memoryAccount]().Lt("age", 25).Build()
+	require.NoError(t, err)
+	update, err := mgo.Update[memoryAccount]().Set("email", "young@x.io").Build()
+	require.NoError(t, err)
+
+	// Act
+	modified, err := mgo.UpdateOne(ctx, &memoryAccount{}, filter, update)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), modified)
+	accounts, err := mgo.Find(ctx, &memoryAccount{}, filter, 0)
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "young@x.io", accounts[0].Email)
+}
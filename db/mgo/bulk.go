@@ -2,45 +2,109 @@ package mgo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-
-	"github.com/94peter/vulpes/log"
+	"reflect"
+	"slices"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// defaultBulkChunkSize is the number of operations sent per BulkWrite request unless ChunkSize
+// is used.
+const defaultBulkChunkSize = 1000
+
 // BulkOperation provides a fluent builder for constructing and executing
 // bulk write operations, leveraging MongoDB's BulkWrite capabilities.
-// It allows combining multiple insert, update, and delete operations into a single request.
+// It allows combining multiple insert, replace, update, and delete operations into a single request.
 type bulkOperation struct {
-	collection *mongo.Collection
+	store      Datastore
+	collection string
 	operations []mongo.WriteModel
+	errs       []error
+	chunkSize  int
+	unordered  bool
+}
+
+// BulkOperationError reports the failure of the operation at Index, the position at which it
+// was added to the bulk operation. Use BulkOperationErrors to list them from an Execute error.
+type BulkOperationError struct {
+	Err   error
+	Index int
+}
+
+func (e *BulkOperationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BulkOperationError) Unwrap() error {
+	return e.Err
+}
+
+// BulkOperationErrors returns the per-operation errors contained in an error returned by Execute.
+func BulkOperationErrors(err error) []*BulkOperationError {
+	if opErr, ok := err.(*BulkOperationError); ok {
+		return []*BulkOperationError{opErr}
+	}
+	var errs []*BulkOperationError
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			errs = append(errs, BulkOperationErrors(err)...)
+		}
+	case interface{ Unwrap() error }:
+		errs = BulkOperationErrors(e.Unwrap())
+	}
+	return errs
 }
 
 // NewBulkOperation creates a new builder for a bulk operation on a specific collection.
 // cname: The name of the collection to perform operations on.
 func NewBulkOperation(cname string) (BulkOperator, error) {
-	if dataStore == nil {
+	return NewBulkOperationOn(defaultDB, cname)
+}
+
+// NewBulkOperationOn is like NewBulkOperation but the operations are executed on db.
+func NewBulkOperationOn(db *DB, cname string) (BulkOperator, error) {
+	store := db.datastore()
+	if store == nil {
 		return nil, ErrNotConnected
 	}
-	return dataStore.NewBulkOperation(cname), nil
+	return store.NewBulkOperation(cname), nil
+}
+
+func newBulkOperation(store Datastore, cname string) *bulkOperation {
+	return &bulkOperation{
+		store:      store,
+		collection: cname,
+		operations: make([]mongo.WriteModel, 0),
+		chunkSize:  defaultBulkChunkSize,
+	}
 }
 
 // InsertOne adds an InsertOne operation to the bulk request.
-// The provided document will be validated before being added.
+// The provided document is validated; Execute fails without writing anything if it is invalid.
 func (b *bulkOperation) InsertOne(doc DocInter) BulkOperator {
-	if err := doc.Validate(); err != nil {
-		log.Warn("Invalid document in bulk operation: " + err.Error())
-		// To maintain the fluent API, we don't return an error here.
-		// The error will be caught by the driver during Execute.
-		// Consider pre-validating documents before adding them to the bulk operation.
-	}
+	b.validate(doc)
 	model := mongo.NewInsertOneModel().SetDocument(doc)
 	b.operations = append(b.operations, model)
 	return b
 }
 
+// ReplaceOne adds a ReplaceOne operation to the bulk request, validated like InsertOne.
+// The replacement document must not have an _id field if it's different from the filter's _id.
+func (b *bulkOperation) ReplaceOne(filter any, replacement DocInter) BulkOperator {
+	b.validate(replacement)
+	model := mongo.NewReplaceOneModel().
+		SetFilter(filter).
+		SetReplacement(replacement)
+	b.operations = append(b.operations, model)
+	return b
+}
+
 // UpdateOne adds an UpdateOne operation to the bulk request.
 // filter: The filter to select the document to update.
 // update: The update document (e.g., using $set, $inc).
@@ -57,6 +121,15 @@ func (b *bulkOperation) UpdateById(id any, update any) BulkOperator {
 	return b.UpdateOne(bson.M{"_id": id}, update)
 }
 
+// UpdateMany adds an UpdateMany operation updating every document matching filter.
+func (b *bulkOperation) UpdateMany(filter any, update any) BulkOperator {
+	model := mongo.NewUpdateManyModel().
+		SetFilter(filter).
+		SetUpdate(update)
+	b.operations = append(b.operations, model)
+	return b
+}
+
 func (b *bulkOperation) UpsertOne(filter any, update any) BulkOperator {
 	model := mongo.NewUpdateOneModel().
 		SetFilter(filter).
@@ -85,33 +158,189 @@ func (b *bulkOperation) DeleteById(id any) BulkOperator {
 	return b.DeleteOne(bson.M{"_id": id})
 }
 
-// ReplaceOne adds a ReplaceOne operation to the bulk request.
-// The replacement document must not have an _id field if it's different from the filter's _id.
-func (b *bulkOperation) ReplaceOne(filter any, replacement DocInter) BulkOperator {
-	model := mongo.NewReplaceOneModel().
-		SetFilter(filter).
-		SetReplacement(replacement)
+// DeleteMany adds a DeleteMany operation deleting every document matching filter.
+func (b *bulkOperation) DeleteMany(filter any) BulkOperator {
+	model := mongo.NewDeleteManyModel().SetFilter(filter)
 	b.operations = append(b.operations, model)
 	return b
 }
 
-// Execute sends the accumulated operations to the database as a single bulk write request.
-// Returns the result of the bulk write operation, or an error if it fails.
+// Unordered lets the server execute the operations in any order and continue after a failed
+// operation. By default operations run in order and stop at the first failure.
+func (b *bulkOperation) Unordered() BulkOperator {
+	b.unordered = true
+	return b
+}
+
+// ChunkSize sets the maximum number of operations sent in one BulkWrite request.
+// Values below 1 restore the default of 1000.
+func (b *bulkOperation) ChunkSize(n int) BulkOperator {
+	if n < 1 {
+		n = defaultBulkChunkSize
+	}
+	b.chunkSize = n
+	return b
+}
+
+func (b *bulkOperation) validate(doc DocInter) {
+	if err := doc.Validate(); err != nil {
+		b.errs = append(b.errs, &BulkOperationError{Index: len(b.operations), Err: err})
+	}
+}
+
+// Execute sends the accumulated operations to the database in requests of at most ChunkSize
+// operations and returns their combined result.
+//
+// If a document added by InsertOne or ReplaceOne is invalid, nothing is written and the error
+// wraps ErrInvalidDocument. If operations fail on the server, the error wraps ErrWriteFailed
+// and the result counts the operations that succeeded; in ordered mode no chunk after the
+// failing one is sent. Either way BulkOperationErrors lists the failed operations by index.
 func (b *bulkOperation) Execute(ctx context.Context) (*mongo.BulkWriteResult, error) {
 	if len(b.operations) == 0 {
 		return nil, fmt.Errorf("%w: no operations to execute", ErrInvalidDocument)
 	}
-	result, err := b.collection.BulkWrite(ctx, b.operations)
+	_, span := b.store.startTraceSpan(ctx, b.collection, "bulkWrite", nil)
+	defer span.End()
+	chunks := (len(b.operations) + b.chunkSize - 1) / b.chunkSize
+	span.SetAttributes(
+		attribute.Int("db.bulk.operations", len(b.operations)),
+		attribute.Int("db.bulk.chunks", chunks),
+		attribute.Bool("db.bulk.ordered", !b.unordered),
+	)
+	if len(b.errs) > 0 {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrInvalidDocument, errors.Join(b.errs...)), span)
+	}
+
+	operations, err := sealOperations(ctx, b.operations)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrWriteFailed, err)
+		return nil, spanErrorHandler(err, span)
 	}
 
-	return result, nil
+	total := &mongo.BulkWriteResult{UpsertedIDs: map[int64]any{}, Acknowledged: true}
+	var errs []error
+	// executed marks the operations the database applied, for the audit trail.
+	executed := make([]bool, len(b.operations))
+	opts := options.BulkWrite().SetOrdered(!b.unordered)
+	for start := 0; start < len(b.operations); start += b.chunkSize {
+		end := min(start+b.chunkSize, len(b.operations))
+		result, err := retryValue(ctx, span, false, func() (*mongo.BulkWriteResult, error) {
+			return b.store.BulkWrite(ctx, b.collection, operations[start:end], opts)
+		})
+		mergeBulkWriteResult(total, result, int64(start))
+		if err == nil {
+			markExecuted(executed[start:end], nil, false)
+			continue
+		}
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) {
+			errs = append(errs, err)
+			break
+		}
+		markExecuted(executed[start:end], bulkErr.WriteErrors, !b.unordered)
+		for _, we := range bulkErr.WriteErrors {
+			we.Index += start
+			errs = append(errs, &BulkOperationError{Index: we.Index, Err: we.WriteError})
+		}
+		if bulkErr.WriteConcernError != nil {
+			errs = append(errs, bulkErr.WriteConcernError)
+		}
+		if !b.unordered {
+			break
+		}
+	}
+	auditBulk(ctx, b.collection, b.operations, executed)
+	if len(errs) > 0 {
+		return total, spanErrorHandler(fmt.Errorf("%w: %w", ErrWriteFailed, errors.Join(errs...)), span)
+	}
+	return total, spanErrorHandler(nil, span)
+}
+
+// markExecuted marks the operations of a chunk as applied, except those that failed.
+// An ordered chunk stops at its first failure.
+func markExecuted(chunk []bool, failed []mongo.BulkWriteError, ordered bool) {
+	end := len(chunk)
+	if ordered {
+		for _, we := range failed {
+			end = min(end, we.Index)
+		}
+	}
+	for i := range end {
+		chunk[i] = true
+	}
+	for _, we := range failed {
+		if we.Index < len(chunk) {
+			chunk[we.Index] = false
+		}
+	}
+}
+
+// sealOperations returns operations with the encrypted fields of the inserted and replacing
+// documents encrypted. Updates and filters are sent as they are, see EncryptedValue.
+func sealOperations(ctx context.Context, operations []mongo.WriteModel) ([]mongo.WriteModel, error) {
+	var sealed []mongo.WriteModel
+	for i, operation := range operations {
+		switch m := operation.(type) {
+		case *mongo.InsertOneModel:
+			if encryptedFieldsOf(reflect.TypeOf(m.Document)) == nil {
+				continue
+			}
+			doc, err := sealDocument(ctx, m.Document)
+			if err != nil {
+				return nil, &BulkOperationError{Index: i, Err: err}
+			}
+			operation = mongo.NewInsertOneModel().SetDocument(doc)
+		case *mongo.ReplaceOneModel:
+			if encryptedFieldsOf(reflect.TypeOf(m.Replacement)) == nil {
+				continue
+			}
+			replacement, err := sealDocument(ctx, m.Replacement)
+			if err != nil {
+				return nil, &BulkOperationError{Index: i, Err: err}
+			}
+			copied := *m
+			copied.Replacement = replacement
+			operation = &copied
+		default:
+			continue
+		}
+		if sealed == nil {
+			sealed = slices.Clone(operations)
+		}
+		sealed[i] = operation
+	}
+	if sealed == nil {
+		return operations, nil
+	}
+	return sealed, nil
+}
+
+// mergeBulkWriteResult adds the result of the chunk starting at offset to total.
+func mergeBulkWriteResult(total, result *mongo.BulkWriteResult, offset int64) {
+	if result == nil {
+		return
+	}
+	total.InsertedCount += result.InsertedCount
+	total.MatchedCount += result.MatchedCount
+	total.ModifiedCount += result.ModifiedCount
+	total.DeletedCount += result.DeletedCount
+	total.UpsertedCount += result.UpsertedCount
+	for i, id := range result.UpsertedIDs {
+		total.UpsertedIDs[i+offset] = id
+	}
+	total.Acknowledged = total.Acknowledged && result.Acknowledged
 }
 
 func (m *mongoStore) NewBulkOperation(cname string) BulkOperator {
-	return &bulkOperation{
-		operations: make([]mongo.WriteModel, 0),
-		collection: m.getCollection(cname),
+	return newBulkOperation(m, cname)
+}
+
+func (m *mongoStore) BulkWrite(
+	ctx context.Context, collection string, models []mongo.WriteModel,
+	opts ...options.Lister[options.BulkWriteOptions],
+) (*mongo.BulkWriteResult, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return m.getCollection(collection).BulkWrite(ctx, models, opts...)
 }
@@ -0,0 +1,137 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestBulkOperation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Executes every write model", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+		defer restore()
+		seedAccounts(t, 30, 20, 40)
+		bulk, err := mgo.NewBulkOperation((&memoryAccount{}).C())
+		require.NoError(t, err)
+
+		// Act
+		result, err := bulk.
+			InsertOne(&memoryAccount{Email: "d@x.io", Age: 50}).
+			ReplaceOne(bson.D{{Key: "email", Value: "a@x.io"}}, &memoryAccount{Email: "a@x.io", Age: 31}).
+			UpdateMany(bson.D{{Key: "age", Value: bson.D{{Key: "$gte", Value: 40}}}},
+				bson.D{{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}}}).
+			DeleteMany(bson.D{{Key: "age", Value: bson.D{{Key: "$lt", Value: 30}}}}).
+			Execute(ctx)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), result.InsertedCount)
+		assert.Equal(t, int64(3), result.ModifiedCount)
+		assert.Equal(t, int64(1), result.DeletedCount)
+		accounts, err := mgo.Find(ctx, &memoryAccount{}, bson.D{}, 0)
+		require.NoError(t, err)
+		ages := map[string]int{}
+		for _, a := range accounts {
+			ages[a.Email] = a.Age
+		}
+		assert.Equal(t, map[string]int{"a@x.io": 31, "c@x.io": 41, "d@x.io": 51}, ages)
+	})
+
+	t.Run("Splits operations into chunks", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		bulk, err := mgo.NewBulkOperation("import_users")
+		require.NoError(t, err)
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			bulk.InsertOne(&importUser{Name: name})
+		}
+
+		// Act
+		result, err := bulk.ChunkSize(2).Execute(ctx)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, batches, 3)
+		assert.Len(t, batches[2], 1)
+		assert.Equal(t, int64(5), result.InsertedCount)
+	})
+
+	t.Run("Invalid document fails without writing", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		bulk, err := mgo.NewBulkOperation("import_users")
+		require.NoError(t, err)
+
+		// Act
+		_, err = bulk.
+			InsertOne(&importUser{Name: "a"}).
+			DeleteById(bson.NewObjectID()).
+			ReplaceOne(bson.D{{Key: "name", Value: "b"}}, &importUser{}).
+			Execute(ctx)
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrInvalidDocument)
+		opErrs := mgo.BulkOperationErrors(err)
+		require.Len(t, opErrs, 1)
+		assert.Equal(t, 2, opErrs[0].Index)
+		assert.EqualError(t, opErrs[0], "operation 2: name is required")
+		assert.Empty(t, batches)
+	})
+}
+
+func TestBulkOperationOrdering(t *testing.T) {
+	// The third and fifth inserts violate the unique email index.
+	emails := []string{"a@x.io", "b@x.io", "a@x.io", "c@x.io", "b@x.io", "d@x.io"}
+	tests := []struct {
+		name      string
+		wantIndex []int
+		wantCount int64
+		unordered bool
+	}{
+		{name: "Ordered stops at the first failure", wantIndex: []int{2}, wantCount: 2},
+		{name: "Unordered continues after failures", wantIndex: []int{2, 4}, wantCount: 4, unordered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+			defer restore()
+			bulk, err := mgo.NewBulkOperation((&memoryAccount{}).C())
+			require.NoError(t, err)
+			for _, email := range emails {
+				bulk.InsertOne(&memoryAccount{Email: email})
+			}
+			if tt.unordered {
+				bulk.Unordered()
+			}
+
+			// Act
+			result, err := bulk.ChunkSize(2).Execute(context.Background())
+
+			// Assert
+			require.ErrorIs(t, err, mgo.ErrWriteFailed)
+			var indexes []int
+			for _, opErr := range mgo.BulkOperationErrors(err) {
+				indexes = append(indexes, opErr.Index)
+			}
+			assert.Equal(t, tt.wantIndex, indexes)
+			assert.Equal(t, tt.wantCount, result.InsertedCount)
+			count, err := mgo.CountDocument(context.Background(), (&memoryAccount{}).C(), bson.D{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+		})
+	}
+}
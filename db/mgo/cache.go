@@ -0,0 +1,204 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/94peter/vulpes/db/cache"
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cacheable is implemented by models whose documents are cached by FindById once a cache is
+// installed with SetCache. FindOne is cached too when its filter only selects an _id, and
+// UpdateById, ReplaceOne and DeleteById invalidate the entry of the document they write.
+// Other writes, e.g. UpdateMany, do not invalidate entries, so CacheTTL bounds their staleness.
+//
+// An invalidated entry is replaced by a short-lived tombstone, and a read that missed the cache
+// only caches its document while no tombstone appeared meanwhile. A write invalidating the entry
+// between that check and the cache write, or a read outlasting the tombstone, can still cache
+// the previous document until CacheTTL.
+type Cacheable interface {
+	// CacheKey returns the key of the document, derived from its _id, e.g. "user:" + u.ID.Hex().
+	CacheKey() string
+	// CacheTTL returns how long a cached document is served.
+	CacheTTL() time.Duration
+}
+
+// Cache stores cached documents as BSON.
+type Cache interface {
+	// Get returns the value of key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
+
+// cacheTombstoneTTL is how long an invalidated entry keeps concurrent reads from caching the
+// document they read before the write.
+const cacheTombstoneTTL = 5 * time.Second
+
+var (
+	docCache   Cache
+	cacheGroup singleflight.Group
+)
+
+// SetCache installs the cache used for Cacheable models; nil disables caching.
+// It returns a function restoring the previous cache.
+func SetCache(c Cache) (restore func()) {
+	original := docCache
+	docCache = c
+	return func() {
+		docCache = original
+	}
+}
+
+// NewRedisCache returns a Cache backed by the db/cache connection, which must be initialized
+// with cache.InitConnection.
+func NewRedisCache() Cache {
+	return redisCache{}
+}
+
+type redisCache struct{}
+
+func (redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrCacheMiss) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return cache.Set(ctx, key, value, ttl)
+}
+
+func (redisCache) Del(ctx context.Context, keys ...string) error {
+	_, err := cache.Del(ctx, keys...)
+	return err
+}
+
+type withoutCacheKey struct{}
+
+// WithoutCache returns a context that makes FindById and FindOne read from the database
+// without consulting or populating the cache. Writes still invalidate cached documents.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutCacheKey{}, true)
+}
+
+func isWithoutCache(ctx context.Context) bool {
+	without, _ := ctx.Value(withoutCacheKey{}).(bool)
+	return without
+}
+
+// cacheable returns the cache key of doc when a read with filter and the given number of
+// options can be served from the cache. Reads with options such as projections bypass the
+// cache, as do reads including soft-deleted documents.
+func cacheable[T DocInter](ctx context.Context, doc T, filter any, opts int) (Cacheable, string, bool) {
+	c, ok := any(doc).(Cacheable)
+	if !ok || docCache == nil || opts > 0 || isWithoutCache(ctx) || isIncludeDeleted(ctx) {
+		return nil, "", false
+	}
+	id, ok := idFilterValue(filter)
+	if !ok {
+		return nil, "", false
+	}
+	// SetId may ignore an id of another type, e.g. a hex string for an ObjectID, and the key
+	// would then be derived from the previous id of doc.
+	doc.SetId(id)
+	if !reflect.DeepEqual(doc.GetId(), id) {
+		return nil, "", false
+	}
+	key, ok := tenantCacheKey(ctx, c)
+	return c, key, ok
+}
+
+// idFilterValue returns the _id selected by a filter of the form {_id: value}.
+func idFilterValue(filter any) (any, bool) {
+	var id any
+	switch f := filter.(type) {
+	case bson.M:
+		v, ok := f["_id"]
+		if len(f) != 1 || !ok {
+			return nil, false
+		}
+		id = v
+	case bson.D:
+		if len(f) != 1 || f[0].Key != "_id" {
+			return nil, false
+		}
+		id = f[0].Value
+	default:
+		return nil, false
+	}
+	switch id.(type) {
+	case nil, bson.M, bson.D:
+		return nil, false
+	}
+	return id, true
+}
+
+// findOneCached serves doc from the cache, or reads it with find and caches it. Concurrent misses
+// for the same key share a single read.
+func findOneCached[T DocInter](
+	ctx context.Context, doc T, c Cacheable, key string, find func() (bson.Raw, error),
+) (bool, error) {
+	data, found, err := docCache.Get(ctx, key)
+	if err != nil {
+		log.Warn("mongodb cache read failed", log.String("key", key), log.Err(err))
+	}
+	if found && !isTombstone(data) {
+		return true, unmarshalDocument(ctx, data, &doc)
+	}
+	shared, err, _ := cacheGroup.Do(key, func() (any, error) {
+		raw, err := find()
+		if err != nil {
+			return nil, err
+		}
+		cacheDocument(ctx, c, key, raw)
+		return raw, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return false, unmarshalDocument(ctx, shared.(bson.Raw), &doc)
+}
+
+// cacheDocument caches raw under key, unless the entry was invalidated since the read began.
+func cacheDocument(ctx context.Context, c Cacheable, key string, raw bson.Raw) {
+	data, found, err := docCache.Get(ctx, key)
+	if err != nil {
+		log.Warn("mongodb cache read failed", log.String("key", key), log.Err(err))
+		return
+	}
+	if found && isTombstone(data) {
+		return
+	}
+	if err := docCache.Set(ctx, key, raw, c.CacheTTL()); err != nil {
+		log.Warn("mongodb cache write failed", log.String("key", key), log.Err(err))
+	}
+}
+
+// isTombstone reports whether a cached value marks an invalidated entry; a BSON document is
+// never empty.
+func isTombstone(data []byte) bool {
+	return len(data) == 0
+}
+
+// invalidateCache replaces the cached entry of doc with a tombstone after it was written.
+func invalidateCache(ctx context.Context, doc any) {
+	c, ok := doc.(Cacheable)
+	if !ok || docCache == nil {
+		return
+	}
+	key, ok := tenantCacheKey(ctx, c)
+	if !ok {
+		return
+	}
+	if err := docCache.Set(ctx, key, []byte{}, cacheTombstoneTTL); err != nil {
+		log.Warn("mongodb cache invalidation failed", log.String("key", key), log.Err(err))
+	}
+}
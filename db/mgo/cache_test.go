@@ -0,0 +1,218 @@
+package mgo_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// cachedUser is a Cacheable model.
+type cachedUser struct {
+	Name string        `bson:"name"`
+	ID   bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*cachedUser) C() string                   { return "cached_users" }
+func (*cachedUser) Indexes() []mongo.IndexModel { return nil }
+func (*cachedUser) Validate() error             { return nil }
+func (u *cachedUser) GetId() any                { return u.ID }
+func (u *cachedUser) SetId(id any)              { u.ID, _ = id.(bson.ObjectID) }
+func (u *cachedUser) CacheKey() string          { return "user:" + u.ID.Hex() }
+func (*cachedUser) CacheTTL() time.Duration     { return time.Minute }
+
+// mapCache is an in-memory Cache recording the TTLs it was given.
+type mapCache struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+	mu     sync.Mutex
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (c *mapCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok, nil
+}
+
+func (c *mapCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key], c.ttls[key] = value, ttl
+	return nil
+}
+
+func (c *mapCache) Del(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		delete(c.values, k)
+	}
+	return nil
+}
+
+func TestReadThroughCache(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	c := newMapCache()
+	defer mgo.SetCache(c)()
+	ctx := context.Background()
+	user, err := mgo.Save(ctx, &cachedUser{Name: "Peter"})
+	require.NoError(t, err)
+	key := user.CacheKey()
+
+	t.Run("Miss populates the cache", func(t *testing.T) {
+		// Act
+		got := &cachedUser{ID: user.ID}
+		err := mgo.FindById(ctx, got)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, "Peter", got.Name)
+		assert.Contains(t, c.values, key)
+		assert.Equal(t, time.Minute, c.ttls[key])
+	})
+
+	t.Run("Hit skips the database", func(t *testing.T) {
+		// Arrange: UpdateMany does not invalidate the entry.
+		_, err := mgo.UpdateMany(ctx, &cachedUser{}, bson.D{},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Amy"}}}})
+		require.NoError(t, err)
+
+		// Act
+		got := &cachedUser{}
+		err = mgo.FindOne(ctx, got, bson.M{"_id": user.ID})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, "Peter", got.Name)
+	})
+
+	t.Run("WithoutCache reads the database", func(t *testing.T) {
+		// Act
+		got := &cachedUser{ID: user.ID}
+		err := mgo.FindById(mgo.WithoutCache(ctx), got)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, "Amy", got.Name)
+	})
+
+	t.Run("Id of another type bypasses the cache", func(t *testing.T) {
+		// Arrange: SetId ignores a hex string, so doc keeps the id of the cached user.
+		got := &cachedUser{ID: user.ID}
+
+		// Act
+		err := mgo.FindOne(ctx, got, bson.M{"_id": user.ID.Hex()})
+
+		// Assert
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("UpdateById invalidates the entry", func(t *testing.T) {
+		// Act
+		_, err := mgo.UpdateById(ctx, user, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Bob"}}}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Empty(t, c.values[key], "the entry is replaced by a tombstone")
+		got := &cachedUser{ID: user.ID}
+		require.NoError(t, mgo.FindById(ctx, got))
+		assert.Equal(t, "Bob", got.Name)
+	})
+
+	t.Run("DeleteById invalidates the entry", func(t *testing.T) {
+		// Act
+		_, err := mgo.DeleteById(ctx, user)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Empty(t, c.values[key], "the entry is replaced by a tombstone")
+		assert.ErrorIs(t, mgo.FindById(ctx, &cachedUser{ID: user.ID}), mongo.ErrNoDocuments)
+	})
+}
+
+func TestReadThroughCacheSingleFlight(t *testing.T) {
+	// Arrange
+	var reads atomic.Int32
+	release := make(chan struct{})
+	id := bson.NewObjectID()
+	restore := mgo.SetDatastore(&mgo.MockDatastore{
+		OnFindOne: func(
+			context.Context, string, any, ...options.Lister[options.FindOneOptions],
+		) *mongo.SingleResult {
+			reads.Add(1)
+			<-release
+			return mongo.NewSingleResultFromDocument(bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "Peter"}}, nil, nil)
+		},
+	})
+	defer restore()
+	defer mgo.SetCache(newMapCache())()
+
+	// Act
+	var wg sync.WaitGroup
+	names := make([]string, 10)
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u := &cachedUser{ID: id}
+			if err := mgo.FindById(context.Background(), u); err == nil {
+				names[i] = u.Name
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, int32(1), reads.Load())
+	for _, name := range names {
+		assert.Equal(t, "Peter", name)
+	}
+}
+
+func TestReadThroughCacheInvalidatedDuringRead(t *testing.T) {
+	// Arrange: the document is updated and invalidated while the cache miss reads it.
+	ctx := context.Background()
+	id := bson.NewObjectID()
+	set := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Amy"}}}}
+	restore := mgo.SetDatastore(&mgo.MockDatastore{
+		OnFindOne: func(
+			context.Context, string, any, ...options.Lister[options.FindOneOptions],
+		) *mongo.SingleResult {
+			_, err := mgo.UpdateById(ctx, &cachedUser{ID: id}, set)
+			return mongo.NewSingleResultFromDocument(bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "Peter"}}, err, nil)
+		},
+		OnUpdateOne: func(context.Context, string, bson.D, bson.D) (int64, error) {
+			return 1, nil
+		},
+	})
+	defer restore()
+	c := newMapCache()
+	defer mgo.SetCache(c)()
+	user := &cachedUser{ID: id}
+
+	// Act
+	err := mgo.FindById(ctx, user)
+
+	// Assert: the stale document is served but not cached.
+	require.NoError(t, err)
+	assert.Equal(t, "Peter", user.Name)
+	assert.Empty(t, c.values[user.CacheKey()])
+}
@@ -91,56 +91,62 @@ var isConnected bool
 func InitConnection(ctx context.Context, dbName string, tracer trace.Tracer, opts ...Option) error {
 	var err error
 	once.Do(func() {
-		var client *mongo.Client
-		clientOpts := options.Client()
-		// Default to reading from secondary nodes if available, improving read performance.
-		clientOpts.SetReadPreference(readpref.SecondaryPreferred())
-
-		// Apply all user-provided configuration options.
-		for _, o := range opts {
-			o(clientOpts)
-		}
-
-		// Establish the connection to the server.
-		client, err = mongo.Connect(clientOpts)
+		var store *mongoStore
+		store, err = newMongoStore(ctx, dbName, tracer, opts...)
 		if err != nil {
-			err = errors.Join(ErrConnectionFailed, err)
 			return
 		}
+		dataStore = store
+		isConnected = true
+	})
 
-		// Ping the primary node to verify that the connection is alive.
-		err = client.Ping(ctx, readpref.Primary())
-		if err != nil {
-			err = errors.Join(ErrPingFailed, err)
-			return
-		}
+	return err
+}
 
-		if tracer == nil {
-			tracer = noop.NewTracerProvider().Tracer("mongo")
-		}
+// newMongoStore connects to the server, verifies the connection and returns a Datastore bound to dbName.
+func newMongoStore(ctx context.Context, dbName string, tracer trace.Tracer, opts ...Option) (*mongoStore, error) {
+	clientOpts := options.Client()
+	// Default to reading from secondary nodes if available, improving read performance.
+	clientOpts.SetReadPreference(readpref.SecondaryPreferred())
 
-		_, span := tracer.Start(context.Background(), "check")
-		isNoop := !span.IsRecording() // 如果連測試 Span 都不錄製，就是 No-op
-		span.End()
+	// Apply all user-provided configuration options.
+	for _, o := range opts {
+		o(clientOpts)
+	}
+	// Feed the pool gauges of RegisterMetrics, keeping any monitor set by the options.
+	clientOpts.SetPoolMonitor(newPoolMonitor(clientOpts.PoolMonitor))
 
-		dataStore = &mongoStore{
-			db:     client.Database(dbName),
-			tracer: tracer,
-			isNoop: isNoop,
-		}
-		isConnected = true
-	})
+	// Establish the connection to the server.
+	client, err := mongo.Connect(clientOpts)
+	if err != nil {
+		return nil, errors.Join(ErrConnectionFailed, err)
+	}
 
-	return err
+	// Ping the primary node to verify that the connection is alive.
+	err = client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return nil, errors.Join(ErrPingFailed, err)
+	}
+
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("mongo")
+	}
+
+	_, span := tracer.Start(context.Background(), "check")
+	isNoop := !span.IsRecording() // 如果連測試 Span 都不錄製，就是 No-op
+	span.End()
+
+	return &mongoStore{
+		db:     client.Database(dbName),
+		tracer: tracer,
+		isNoop: isNoop,
+	}, nil
 }
 
 // Close gracefully disconnects the client from the MongoDB server.
 // It should be called at the end of the application's lifecycle, for example, using defer in main.
 func Close(ctx context.Context) error {
-	if dataStore != nil {
-		return dataStore.close(ctx)
-	}
-	return nil
+	return defaultDB.Close(ctx)
 }
 
 func cleanDb(ctx context.Context) error {
@@ -155,7 +161,14 @@ func IsConnected() bool {
 }
 
 func IsCollectionExist(ctx context.Context, collectionName string) (bool, error) {
-	result, err := dataStore.getDatabase().ListCollectionNames(ctx, bson.D{
+	if dataStore == nil {
+		return false, ErrNotConnected
+	}
+	database := dataStore.getDatabase()
+	if database == nil {
+		return false, errNoServer("IsCollectionExist")
+	}
+	result, err := database.ListCollectionNames(ctx, bson.D{
 		bson.E{Key: "name", Value: collectionName},
 	})
 	if err != nil {
@@ -165,11 +178,7 @@ func IsCollectionExist(ctx context.Context, collectionName string) (bool, error)
 }
 
 func IsHealth(ctx context.Context) error {
-	err := dataStore.getClient().Ping(ctx, readpref.Primary())
-	if err != nil {
-		return errors.Join(ErrPingFailed, err)
-	}
-	return nil
+	return defaultDB.IsHealth(ctx)
 }
 
 func InitTestContainer(ctx context.Context) (drop func(), close func(), err error) {
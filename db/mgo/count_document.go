@@ -5,13 +5,41 @@ import (
 	"fmt"
 )
 
+// CountDocument counts the documents of the collection matching filter.
+// It only knows the name of the collection, so it skips soft-deleted documents only if the
+// collection is registered with RegisterIndex, either as a SoftDeleter model or with a
+// NewCollectDef marked WithSoftDelete. Use CountModel to count the documents of a model.
 func CountDocument(ctx context.Context, collectionName string, filter any) (int64, error) {
-	if dataStore == nil {
+	return CountDocumentOn(ctx, defaultDB, collectionName, filter)
+}
+
+// CountDocumentOn is like CountDocument but counts in db.
+func CountDocumentOn(ctx context.Context, db *DB, collectionName string, filter any) (int64, error) {
+	return countDocument(ctx, db, collectionName, filter, isSoftDeleteCollection(collectionName))
+}
+
+// CountModel counts the documents of doc.C() matching filter. Like Find, it skips soft-deleted
+// documents when doc is a SoftDeleter, unless the context is marked with IncludeDeleted.
+func CountModel[T DocInter](ctx context.Context, doc T, filter any) (int64, error) {
+	return CountModelOn(ctx, defaultDB, doc, filter)
+}
+
+// CountModelOn is like CountModel but counts in db.
+func CountModelOn[T DocInter](ctx context.Context, db *DB, doc T, filter any) (int64, error) {
+	return countDocument(ctx, db, doc.C(), filter, isSoftDeleter(doc) || isSoftDeleteCollection(doc.C()))
+}
+
+func countDocument(ctx context.Context, db *DB, collectionName string, filter any, softDelete bool) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "count_document", filter)
+	filter = excludeDeleted(ctx, softDelete, filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "count_document", filter)
 	defer span.End()
-	result, err := dataStore.CountDocument(ctx, collectionName, filter)
+	result, err := retryValue(ctx, span, true, func() (int64, error) {
+		return store.CountDocument(ctx, collectionName, filter)
+	})
 	if err != nil {
 		return 0, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
@@ -19,6 +47,10 @@ func CountDocument(ctx context.Context, collectionName string, filter any) (int6
 }
 
 func (m *mongoStore) CountDocument(ctx context.Context, collectionName string, filter any) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
 	collection := m.getCollection(collectionName)
 	result, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -3,6 +3,8 @@ package mgo
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -40,6 +42,10 @@ type Datastore interface {
 		ctx context.Context, collection string, filter any, replacement any,
 		opts ...options.Lister[options.ReplaceOptions],
 	) (*mongo.UpdateResult, error)
+	BulkWrite(
+		ctx context.Context, collection string, models []mongo.WriteModel,
+		opts ...options.Lister[options.BulkWriteOptions],
+	) (*mongo.BulkWriteResult, error)
 
 	PipeFind(
 		ctx context.Context, collection string, pipeline mongo.Pipeline,
@@ -55,7 +61,18 @@ type Datastore interface {
 		ctx context.Context, collectionName string, reader io.Reader,
 	) error
 
+	WithTransaction(
+		ctx context.Context, fn func(txCtx context.Context) error,
+		opts ...options.Lister[options.TransactionOptions],
+	) error
+
+	Watch(
+		ctx context.Context, collection string, pipeline mongo.Pipeline,
+		opts ...options.Lister[options.ChangeStreamOptions],
+	) (ChangeStream, error)
+
 	NewBulkOperation(cname string) BulkOperator
+	tenantStore(ctx context.Context) (Datastore, error)
 	getCollection(name string) *mongo.Collection
 	getDatabase() *mongo.Database
 	close(ctx context.Context) error
@@ -72,11 +89,17 @@ type Datastore interface {
 // BulkOperator defines the interface for the fluent bulk operation builder.
 type BulkOperator interface {
 	InsertOne(doc DocInter) BulkOperator
+	ReplaceOne(filter any, replacement DocInter) BulkOperator
 	UpdateOne(filter any, update any) BulkOperator
 	UpdateById(id any, update any) BulkOperator
+	UpdateMany(filter any, update any) BulkOperator
 	UpsertOne(filter any, update any) BulkOperator
 	DeleteOne(filter any) BulkOperator
 	DeleteById(id any) BulkOperator
+	DeleteMany(filter any) BulkOperator
+
+	Unordered() BulkOperator
+	ChunkSize(n int) BulkOperator
 
 	Execute(ctx context.Context) (*mongo.BulkWriteResult, error)
 }
@@ -86,10 +109,16 @@ var dataStore Datastore
 type mongoStore struct {
 	db     *mongo.Database
 	tracer trace.Tracer
-	isNoop bool
+	// tenant is set on the stores returned by forTenant.
+	tenant            string
+	isNoop            bool
+	prefixCollections bool
 }
 
 func (m *mongoStore) getCollection(name string) *mongo.Collection {
+	if m.prefixCollections {
+		name = tenantName(name, m.tenant)
+	}
 	return m.db.Collection(name)
 }
 
@@ -132,9 +161,13 @@ func (m *mongoStore) startTraceSpan(
 	if m.isNoop {
 		// 直接返回一個 no-op 的 Span，或者根據你的需求返回 ctx, nil
 		// 注意：如果返回 nil，後續呼叫 span.End() 時要加檢查
-		return ctx, trace.SpanFromContext(ctx)
+		span := meterSpan(trace.SpanFromContext(ctx), collectionName, operation)
+		return ctx, watchSlowQuery(span, collectionName, operation, statement, m.explainFor(ctx))
+	}
+	name := "mongo." + operation
+	if collectionName != "" {
+		name += "." + collectionName
 	}
-	name := "mongo." + operation + "." + collectionName
 	ctx, span := m.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
 	span.SetAttributes(
 		attribute.String("db.system", dbSystem),
@@ -142,10 +175,30 @@ func (m *mongoStore) startTraceSpan(
 		attribute.String("db.operation", operation),
 	)
 	if span.IsRecording() && statement != nil {
-		data, _ := json.Marshal(statement)
-		span.SetAttributes(attribute.String("db.statement", string(data)))
+		span.SetAttributes(attribute.String("db.statement", formatStatement(statement)))
 	}
-	return ctx, span
+	metered := meterSpan(span, collectionName, operation)
+	return ctx, watchSlowQuery(metered, collectionName, operation, statement, m.explainFor(ctx))
+}
+
+// formatStatement renders a filter, update or pipeline as relaxed Extended JSON, falling back to
+// JSON for values that cannot be marshaled to BSON.
+func formatStatement(statement any) string {
+	switch statement.(type) {
+	case bson.D, bson.M:
+		// Their String method renders canonical Extended JSON, which spells out every number type.
+	default:
+		if s, ok := statement.(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	// Wrap the statement so that arrays such as pipelines can be marshaled too.
+	const prefix = `{"s":`
+	if data, err := bson.MarshalExtJSON(bson.D{bson.E{Key: "s", Value: statement}}, false, false); err == nil {
+		return string(data[len(prefix) : len(data)-1])
+	}
+	data, _ := json.Marshal(statement)
+	return string(data)
 }
 
 func spanErrorHandler(err error, span trace.Span) error {
@@ -154,12 +207,24 @@ func spanErrorHandler(err error, span trace.Span) error {
 	}
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
+		recordError(err, span)
 	} else {
 		span.SetStatus(codes.Ok, "ok")
 	}
 	return err
 }
 
+// GetDatabase returns the underlying driver database, or nil when not connected or when the
+// datastore has no MongoDB server, such as MemoryDatastore.
 func GetDatabase() *mongo.Database {
+	if dataStore == nil {
+		return nil
+	}
 	return dataStore.getDatabase()
 }
+
+// errNoServer is returned by the helpers that manage the server, its indexes or collections when
+// the datastore has none, such as MemoryDatastore.
+func errNoServer(helper string) error {
+	return fmt.Errorf("%w: %s needs a MongoDB server", errors.ErrUnsupported, helper)
+}
@@ -0,0 +1,96 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DB is a handle to one MongoDB database. It lets a service work with several
+// databases at once, e.g. a tenant database and an audit database:
+//
+//	auditDB, err := mgo.Connect(ctx, "audit", tracer, mgo.WithURI(auditURI))
+//	if err != nil {
+//		return err
+//	}
+//	defer auditDB.Close(ctx)
+//	_, err = mgo.SaveOn(ctx, auditDB, entry)
+//
+// Every helper of this package has an ...On variant taking a *DB. The plain helpers
+// (Save, Find, UpdateOne, ...) operate on Default, the connection set up by InitConnection.
+type DB struct {
+	store Datastore
+}
+
+// defaultDB resolves to the package-level datastore, so that InitConnection and
+// SetDatastore keep driving the plain helpers.
+var defaultDB = &DB{}
+
+// Default returns the handle of the connection established by InitConnection.
+func Default() *DB {
+	return defaultDB
+}
+
+// Connect establishes a new, independent connection and returns its handle.
+// Unlike InitConnection it is not a singleton: each call opens its own client,
+// which must be released with Close.
+func Connect(ctx context.Context, dbName string, tracer trace.Tracer, opts ...Option) (*DB, error) {
+	store, err := newMongoStore(ctx, dbName, tracer, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{store: store}, nil
+}
+
+// NewDB wraps a Datastore, typically a MockDatastore, in a handle.
+func NewDB(store Datastore) *DB {
+	return &DB{store: store}
+}
+
+// datastore returns the Datastore behind db, or nil when it is not connected. Only a nil db
+// and Default resolve to the package-level datastore; NewDB(nil) stays disconnected instead
+// of silently using it.
+func (db *DB) datastore() Datastore {
+	if db == nil || db == defaultDB {
+		return dataStore
+	}
+	return db.store
+}
+
+// Close disconnects the client of db.
+func (db *DB) Close(ctx context.Context) error {
+	if store := db.datastore(); store != nil {
+		return store.close(ctx)
+	}
+	return nil
+}
+
+// IsHealth pings the primary node of db.
+func (db *DB) IsHealth(ctx context.Context) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	client := store.getClient()
+	if client == nil {
+		return errNoServer("IsHealth")
+	}
+	err := client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return errors.Join(ErrPingFailed, err)
+	}
+	return nil
+}
+
+// Database returns the underlying driver database of db, or nil when it is not connected or
+// has no MongoDB server, such as MemoryDatastore.
+func (db *DB) Database() *mongo.Database {
+	store := db.datastore()
+	if store == nil {
+		return nil
+	}
+	return store.getDatabase()
+}
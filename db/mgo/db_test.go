@@ -0,0 +1,89 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDB(t *testing.T) {
+	t.Run("Helpers use the given handle", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnFind: mgo.NewOnFindMock(testUser{Name: "default"}),
+		})
+		defer restore()
+		var savedIn []string
+		auditDB := mgo.NewDB(&mgo.MockDatastore{
+			OnFind: mgo.NewOnFindMock(testUser{Name: "audit"}),
+			OnSave: func(_ context.Context, doc mgo.DocInter) (mgo.DocInter, error) {
+				savedIn = append(savedIn, "audit")
+				doc.SetId(bson.NewObjectID())
+				return doc, nil
+			},
+		})
+
+		// Act
+		fromAudit, err := mgo.FindOn(context.Background(), auditDB, &testUser{}, nil, 10)
+		require.NoError(t, err)
+		fromDefault, err := mgo.Find(context.Background(), &testUser{}, nil, 10)
+		require.NoError(t, err)
+		_, err = mgo.SaveOn(context.Background(), auditDB, &testUser{Name: "entry"})
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, fromAudit, 1)
+		assert.Equal(t, "audit", fromAudit[0].Name)
+		require.Len(t, fromDefault, 1)
+		assert.Equal(t, "default", fromDefault[0].Name)
+		assert.Equal(t, []string{"audit"}, savedIn)
+	})
+
+	t.Run("Default follows SetDatastore", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnCountDocument: func(context.Context, string, any) (int64, error) {
+				return 7, nil
+			},
+		})
+		defer restore()
+
+		// Act
+		count, err := mgo.CountDocumentOn(context.Background(), mgo.Default(), testCollectionName, bson.D{})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), count)
+	})
+
+	t.Run("Not connected", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(nil)
+		defer restore()
+
+		// Act
+		_, err := mgo.FindOn(context.Background(), mgo.Default(), &testUser{}, nil, 10)
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrNotConnected)
+	})
+
+	t.Run("Handle without a datastore does not fall back to the default", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnFind: mgo.NewOnFindMock(testUser{Name: "default"}),
+		})
+		defer restore()
+
+		// Act
+		_, err := mgo.FindOn(context.Background(), mgo.NewDB(nil), &testUser{}, nil, 10)
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrNotConnected)
+	})
+}
@@ -11,45 +11,132 @@ import (
 // DeleteOne deletes a single document matching the filter.
 // doc: An instance of the document type, used to determine the collection.
 func DeleteOne[T DocInter](ctx context.Context, doc T, filter bson.D) (int64, error) {
-	if dataStore == nil {
+	return DeleteOneOn(ctx, defaultDB, doc, filter)
+}
+
+// DeleteOneOn is like DeleteOne but deletes from db.
+func DeleteOneOn[T DocInter](ctx context.Context, db *DB, doc T, filter bson.D) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, doc.C(), "deleteOne", filter)
+	if err := beforeDelete(ctx, doc); err != nil {
+		return 0, err
+	}
+	filter, err := sealFilterD(ctx, doc, filter)
+	if err != nil {
+		return 0, err
+	}
+	_, span := store.startTraceSpan(ctx, doc.C(), "deleteOne", filter)
 	defer span.End()
-	affected, err := dataStore.DeleteOne(ctx, doc.C(), filter)
+	before := auditBefore(ctx, store, doc.C(), filter)
+	affected, err := retryValue(ctx, span, pinsSingleID(filter), func() (int64, error) {
+		return store.DeleteOne(ctx, doc.C(), filter)
+	})
 	if err != nil {
 		return 0, spanErrorHandler(err, span)
 	}
+	if affected > 0 {
+		recordAudit(ctx, auditWrite{
+			model: doc, filter: filter, before: before, collection: doc.C(), operation: "deleteOne",
+		})
+		err = afterDelete(ctx, doc)
+	}
 	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
-	return affected, spanErrorHandler(nil, span)
+	return affected, spanErrorHandler(err, span)
 }
 
 // DeleteMany deletes all documents matching the filter.
 // doc: An instance of the document type, used to determine the collection.
 func DeleteMany[T DocInter](ctx context.Context, doc T, filter bson.D) (int64, error) {
-	if dataStore == nil {
+	return DeleteManyOn(ctx, defaultDB, doc, filter)
+}
+
+// DeleteManyOn is like DeleteMany but deletes from db.
+func DeleteManyOn[T DocInter](ctx context.Context, db *DB, doc T, filter bson.D) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, doc.C(), "deleteMany", filter)
+	if err := beforeDelete(ctx, doc); err != nil {
+		return 0, err
+	}
+	filter, err := sealFilterD(ctx, doc, filter)
+	if err != nil {
+		return 0, err
+	}
+	_, span := store.startTraceSpan(ctx, doc.C(), "deleteMany", filter)
 	defer span.End()
-	affected, err := dataStore.DeleteMany(ctx, doc.C(), filter)
+	affected, err := retryValue(ctx, span, true, func() (int64, error) {
+		return store.DeleteMany(ctx, doc.C(), filter)
+	})
 	if err != nil {
 		return 0, spanErrorHandler(err, span)
 	}
+	if affected > 0 {
+		recordAudit(ctx, auditWrite{model: doc, filter: filter, collection: doc.C(), operation: "deleteMany"})
+		err = afterDelete(ctx, doc)
+	}
 	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
-	return affected, spanErrorHandler(nil, span)
+	return affected, spanErrorHandler(err, span)
 }
 
 // DeleteById deletes a single document identified by the _id of the provided document instance.
+// Documents implementing SoftDeleter are only marked as deleted.
 // doc: An instance of the document, from which the _id is extracted for the filter.
 func DeleteById[T DocInter](ctx context.Context, doc T) (int64, error) {
-	if dataStore == nil {
+	return DeleteByIdOn(ctx, defaultDB, doc)
+}
+
+// DeleteByIdOn is like DeleteById but deletes from db.
+func DeleteByIdOn[T DocInter](ctx context.Context, db *DB, doc T) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	return dataStore.DeleteOne(ctx, doc.C(), bson.D{bson.E{Key: "_id", Value: doc.GetId()}})
+	if err := beforeDelete(ctx, doc); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	var err error
+	before := auditBefore(ctx, store, doc.C(), bson.D{bson.E{Key: "_id", Value: doc.GetId()}})
+	w := auditWrite{model: doc, id: doc.GetId(), before: before, collection: doc.C(), operation: "deleteById"}
+	if isSoftDeleter(doc) {
+		deleted, err = softDeleteById(ctx, store, doc)
+		w.operation, w.after = "softDeleteById", auditAfter(ctx, store, doc.C(), doc.GetId())
+	} else {
+		deleted, err = deleteById(ctx, store, doc)
+	}
+	if err == nil {
+		invalidateCache(ctx, doc)
+	}
+	if err == nil && deleted > 0 {
+		recordAudit(ctx, w)
+		err = afterDelete(ctx, doc)
+	}
+	return deleted, err
+}
+
+// deleteById removes the document identified by the _id of doc.
+func deleteById[T DocInter](ctx context.Context, store Datastore, doc T) (int64, error) {
+	filter := bson.D{bson.E{Key: "_id", Value: doc.GetId()}}
+	_, span := store.startTraceSpan(ctx, doc.C(), "deleteById", filter)
+	defer span.End()
+	affected, err := retryValue(ctx, span, true, func() (int64, error) {
+		return store.DeleteOne(ctx, doc.C(), filter)
+	})
+	if err != nil {
+		return 0, spanErrorHandler(err, span)
+	}
+	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
+	return affected, spanErrorHandler(nil, span)
 }
 
 func (m *mongoStore) DeleteMany(ctx context.Context, collection string, filter bson.D) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
 	result, err := m.getCollection(collection).DeleteMany(ctx, filter)
 	if err != nil {
 		return 0, errors.Join(ErrWriteFailed, err)
@@ -58,6 +145,10 @@ func (m *mongoStore) DeleteMany(ctx context.Context, collection string, filter b
 }
 
 func (m *mongoStore) DeleteOne(ctx context.Context, collection string, filter bson.D) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
 	result, err := m.getCollection(collection).DeleteOne(ctx, filter)
 	if err != nil {
 		return 0, errors.Join(ErrWriteFailed, err)
@@ -12,12 +12,23 @@ func Distinct[T any](
 	ctx context.Context, collectionName string, field string, filter any,
 	opts ...options.Lister[options.DistinctOptions],
 ) ([]T, error) {
-	if dataStore == nil {
+	return DistinctOn[T](ctx, defaultDB, collectionName, field, filter, opts...)
+}
+
+// DistinctOn is like Distinct but reads from db.
+func DistinctOn[T any](
+	ctx context.Context, db *DB, collectionName string, field string, filter any,
+	opts ...options.Lister[options.DistinctOptions],
+) ([]T, error) {
+	store := db.datastore()
+	if store == nil {
 		return nil, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "distinct", filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "distinct", filter)
 	defer span.End()
-	values, err := dataStore.Distinct(ctx, collectionName, field, filter, opts...)
+	values, err := retryValue(ctx, span, true, func() ([]bson.RawValue, error) {
+		return store.Distinct(ctx, collectionName, field, filter, opts...)
+	})
 	if err != nil {
 		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
@@ -37,6 +48,10 @@ func (m *mongoStore) Distinct(
 	ctx context.Context, collectionName string, field string, filter any,
 	opts ...options.Lister[options.DistinctOptions],
 ) ([]bson.RawValue, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
 	collection := m.getCollection(collectionName)
 	result := collection.Distinct(ctx, field, filter, opts...)
 	rows, err := result.Raw()
@@ -0,0 +1,828 @@
+package mgo
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// KeyProvider supplies the keys of field-level encryption, see SetKeyProvider.
+// Keys are identified by an ID stored next to every encrypted value, so that values
+// encrypted before a key rotation can still be decrypted.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key that new values are encrypted with.
+	// Keys must hold at least 32 random bytes.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// KeyLister is implemented by key providers able to list the IDs of their keys. Equality
+// filters on deterministic fields then match the values encrypted with any of them, instead of
+// only those encrypted with the current key.
+type KeyLister interface {
+	KeyIDs(ctx context.Context) ([]string, error)
+}
+
+var keyProvider KeyProvider
+
+// SetKeyProvider enables the encryption of the model fields tagged `vulpes:"encrypt"`:
+//
+//	type User struct {
+//		...
+//		Email string `bson:"email" vulpes:"encrypt,deterministic"`
+//		Phone string `bson:"phone" vulpes:"encrypt"`
+//	}
+//
+// Save, ReplaceOne, the update helpers and bulk inserts and replacements encrypt these fields,
+// and the read helpers decrypt them. Fields are encrypted with AES-256-GCM under a random nonce,
+// or with the "deterministic" option under a nonce derived from the value, so that equal values
+// have equal ciphertexts. Equality filters ({field: value}, $eq, $ne, $in and $nin) on
+// deterministic fields are encrypted by the helpers taking a model; other queries on encrypted
+// fields fail with ErrEncryptionFailed. Values stored in clear, e.g. before the field was
+// tagged, are read as they are.
+//
+// It returns a function restoring the previous provider.
+func SetKeyProvider(p KeyProvider) (restore func()) {
+	original := keyProvider
+	keyProvider = p
+	return func() {
+		keyProvider = original
+	}
+}
+
+// staticKeys is a KeyProvider holding its keys in memory.
+type staticKeys struct {
+	keys    map[string][]byte
+	current string
+}
+
+// NewStaticKeyProvider returns a KeyProvider encrypting with the key currentID of keys, and
+// decrypting with any of them. To rotate keys, add a new key and make it the current one;
+// remove a key once no document holds values encrypted with it.
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) KeyProvider {
+	return &staticKeys{keys: keys, current: currentID}
+}
+
+func (s *staticKeys) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := s.Key(ctx, s.current)
+	return s.current, key, err
+}
+
+func (s *staticKeys) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+func (s *staticKeys) KeyIDs(context.Context) ([]string, error) {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// EncryptedValue returns value as it is stored in the encrypted field path of T, for the filters
+// and updates the helpers do not encrypt, such as those of pipelines and bulk operations.
+// Filters can only match the values of deterministic fields encrypted with the current key.
+func EncryptedValue[T DocInter](ctx context.Context, path string, value any) (any, error) {
+	field, _ := encryptedFieldsOf(reflect.TypeFor[T]()).lookup(path)
+	if field == nil || !field.encrypted {
+		return nil, fmt.Errorf("%w: %q is not an encrypted field of %s", ErrEncryptionFailed, path, reflect.TypeFor[T]())
+	}
+	rv, err := toRawValue(value, field.goType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	sealed, err := newFieldCipher(ctx).seal(field, rv)
+	if err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+const (
+	// encryptedSubtype is the user-defined binary subtype of encrypted values.
+	encryptedSubtype byte = 0x80
+	encryptionFormat byte = 1
+	nonceSize             = 12
+	minKeySize            = 32
+)
+
+// encryptedField is a node of the tree of the encrypted fields of a model, keyed by BSON name.
+type encryptedField struct {
+	children map[string]*encryptedField
+	// goType is the type of an encrypted field, used to encode filter values like the field.
+	goType        reflect.Type
+	path          string
+	encrypted     bool
+	deterministic bool
+}
+
+var encryptedFieldCache sync.Map // reflect.Type -> *encryptedField
+
+// encryptedFieldsOf returns the tree of the encrypted fields of t, or nil if it has none.
+func encryptedFieldsOf(t reflect.Type) *encryptedField {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	if fields, ok := encryptedFieldCache.Load(t); ok {
+		return fields.(*encryptedField)
+	}
+	fields := collectEncryptedFields(t, "", map[reflect.Type]bool{})
+	encryptedFieldCache.Store(t, fields)
+	return fields
+}
+
+func collectEncryptedFields(t reflect.Type, prefix string, visiting map[reflect.Type]bool) *encryptedField {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || t == objectIDType || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	node := &encryptedField{path: prefix, children: map[string]*encryptedField{}}
+	addEncryptedFields(node, t, prefix, visiting)
+	if len(node.children) == 0 {
+		return nil
+	}
+	return node
+}
+
+func addEncryptedFields(node *encryptedField, t reflect.Type, prefix string, visiting map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, flags, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(flags, "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addEncryptedFields(node, ft, prefix, visiting)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if encrypt, deterministic := parseEncryptTag(f.Tag.Get("vulpes")); encrypt {
+			node.children[name] = &encryptedField{
+				goType: f.Type, path: path, encrypted: true, deterministic: deterministic,
+			}
+			continue
+		}
+		if child := collectEncryptedFields(f.Type, path, visiting); child != nil {
+			node.children[name] = child
+		}
+	}
+}
+
+// parseEncryptTag parses a vulpes tag of the form "encrypt" or "encrypt,deterministic".
+func parseEncryptTag(tag string) (encrypt, deterministic bool) {
+	options := strings.Split(tag, ",")
+	return slices.Contains(options, "encrypt"), slices.Contains(options, "deterministic")
+}
+
+// lookup returns the node of the dotted path, skipping array indexes and positional operators,
+// and whether path goes below an encrypted field.
+func (f *encryptedField) lookup(path string) (*encryptedField, bool) {
+	node := f
+	for segment := range strings.SplitSeq(path, ".") {
+		if node == nil {
+			return nil, false
+		}
+		if node.encrypted {
+			return node, true
+		}
+		if isArraySegment(segment) {
+			continue
+		}
+		node = node.children[segment]
+	}
+	return node, false
+}
+
+// transform returns rv with leaf applied to the values of the encrypted fields below f.
+// Arrays are traversed, as the fields of their documents share the path of the array.
+func (f *encryptedField) transform(
+	rv bson.RawValue, leaf func(*encryptedField, bson.RawValue) (bson.RawValue, error),
+) (bson.RawValue, error) {
+	if f.encrypted {
+		return leaf(f, rv)
+	}
+	switch rv.Type {
+	case bson.TypeEmbeddedDocument:
+		elements, err := bson.Raw(rv.Value).Elements()
+		if err != nil {
+			return rv, err
+		}
+		doc := make(bson.D, 0, len(elements))
+		for _, e := range elements {
+			value := e.Value()
+			if child, ok := f.children[e.Key()]; ok {
+				if value, err = child.transform(value, leaf); err != nil {
+					return rv, err
+				}
+			}
+			doc = append(doc, bson.E{Key: e.Key(), Value: value})
+		}
+		return toRawValue(doc, nil)
+	case bson.TypeArray:
+		values, err := bson.Raw(rv.Value).Values()
+		if err != nil {
+			return rv, err
+		}
+		array := make(bson.A, 0, len(values))
+		for _, value := range values {
+			if value, err = f.transform(value, leaf); err != nil {
+				return rv, err
+			}
+			array = append(array, value)
+		}
+		return toRawValue(array, nil)
+	default:
+		return rv, nil
+	}
+}
+
+// toRawValue encodes value, converted to the numeric or string type t when given, so that
+// values of filters are encoded like the field they are compared with.
+func toRawValue(value any, t reflect.Type) (bson.RawValue, error) {
+	if rv, ok := value.(bson.RawValue); ok {
+		return rv, nil
+	}
+	if v := reflect.ValueOf(value); t != nil && v.IsValid() && v.Type() != t {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if sameKindClass(v.Kind(), t.Kind()) && v.CanConvert(t) {
+			value = v.Convert(t).Interface()
+		}
+	}
+	bsonType, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	return bson.RawValue{Type: bsonType, Value: data}, nil
+}
+
+func sameKindClass(a, b reflect.Kind) bool {
+	class := func(k reflect.Kind) int {
+		switch {
+		case k == reflect.String:
+			return 1
+		case k >= reflect.Int && k <= reflect.Float64:
+			return 2
+		default:
+			return 0
+		}
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
+
+func isEncryptedValue(rv bson.RawValue) bool {
+	if rv.Type != bson.TypeBinary {
+		return false
+	}
+	subtype, _, ok := rv.BinaryOK()
+	return ok && subtype == encryptedSubtype
+}
+
+// fieldKey is a key derived for field-level encryption.
+type fieldKey struct {
+	aead cipher.AEAD
+	id   string
+	mac  []byte
+}
+
+// fieldCipher encrypts and decrypts the fields of one operation, resolving each key once.
+type fieldCipher struct {
+	ctx     context.Context
+	current *fieldKey
+	keys    map[string]*fieldKey
+}
+
+func newFieldCipher(ctx context.Context) *fieldCipher {
+	return &fieldCipher{ctx: ctx, keys: map[string]*fieldKey{}}
+}
+
+func (c *fieldCipher) currentKey() (*fieldKey, error) {
+	if c.current != nil {
+		return c.current, nil
+	}
+	if keyProvider == nil {
+		return nil, fmt.Errorf("%w: no key provider", ErrEncryptionFailed)
+	}
+	id, material, err := keyProvider.CurrentKey(c.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	key, err := deriveFieldKey(id, material)
+	if err != nil {
+		return nil, err
+	}
+	c.current = key
+	c.keys[id] = key
+	return key, nil
+}
+
+func (c *fieldCipher) key(id string) (*fieldKey, error) {
+	if key, ok := c.keys[id]; ok {
+		return key, nil
+	}
+	if keyProvider == nil {
+		return nil, fmt.Errorf("%w: no key provider", ErrEncryptionFailed)
+	}
+	material, err := keyProvider.Key(c.ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	key, err := deriveFieldKey(id, material)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[id] = key
+	return key, nil
+}
+
+// queryKeys returns the keys deterministic values are searched with: every key when the
+// provider lists them, the current one otherwise.
+func (c *fieldCipher) queryKeys() ([]*fieldKey, error) {
+	current, err := c.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := keyProvider.(KeyLister)
+	if !ok {
+		return []*fieldKey{current}, nil
+	}
+	ids, err := lister.KeyIDs(c.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	keys := []*fieldKey{current}
+	for _, id := range ids {
+		if id == current.id {
+			continue
+		}
+		key, err := c.key(id)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func deriveFieldKey(id string, material []byte) (*fieldKey, error) {
+	if len(material) < minKeySize {
+		return nil, fmt.Errorf("%w: key %q is shorter than %d bytes", ErrEncryptionFailed, id, minKeySize)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("%w: key ID %q is longer than 255 bytes", ErrEncryptionFailed, id)
+	}
+	derived, err := hkdf.Key(sha256.New, material, nil, "vulpes field encryption", 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	return &fieldKey{aead: aead, mac: derived[32:], id: id}, nil
+}
+
+// seal encrypts rv for field with the current key. Null values and values already encrypted
+// are returned as they are.
+func (c *fieldCipher) seal(field *encryptedField, rv bson.RawValue) (bson.RawValue, error) {
+	if rv.Type == bson.TypeNull || rv.Type == bson.TypeUndefined || isEncryptedValue(rv) {
+		return rv, nil
+	}
+	key, err := c.currentKey()
+	if err != nil {
+		return rv, err
+	}
+	return encryptValue(key, field, rv)
+}
+
+// encryptValue encrypts the type and bytes of rv into a binary value laid out as
+// format, mode, key ID length, key ID, nonce and ciphertext. The header and the field path
+// are authenticated, so that a value cannot be moved to another field.
+func encryptValue(key *fieldKey, field *encryptedField, rv bson.RawValue) (bson.RawValue, error) {
+	plaintext := append([]byte{byte(rv.Type)}, rv.Value...)
+	mode := byte(0)
+	if field.deterministic {
+		mode = 1
+	}
+	header := append([]byte{encryptionFormat, mode, byte(len(key.id))}, key.id...)
+	nonce := make([]byte, nonceSize)
+	if field.deterministic {
+		mac := hmac.New(sha256.New, key.mac)
+		mac.Write([]byte(field.path))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return rv, fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	}
+	data := append(header, nonce...)
+	data = key.aead.Seal(data, nonce, plaintext, additionalData(header, field))
+	return toRawValue(bson.Binary{Subtype: encryptedSubtype, Data: data}, nil)
+}
+
+// open decrypts rv if it is an encrypted value.
+func (c *fieldCipher) open(field *encryptedField, rv bson.RawValue) (bson.RawValue, error) {
+	if !isEncryptedValue(rv) {
+		return rv, nil
+	}
+	_, data, _ := rv.BinaryOK()
+	if len(data) < 3 || data[0] != encryptionFormat || len(data) < 3+int(data[2])+nonceSize {
+		return rv, fmt.Errorf("%w: malformed value of %q", ErrEncryptionFailed, field.path)
+	}
+	headerSize := 3 + int(data[2])
+	header := data[:headerSize]
+	key, err := c.key(string(data[3:headerSize]))
+	if err != nil {
+		return rv, err
+	}
+	nonce := data[headerSize : headerSize+nonceSize]
+	plaintext, err := key.aead.Open(nil, nonce, data[headerSize+nonceSize:], additionalData(header, field))
+	if err != nil || len(plaintext) == 0 {
+		return rv, fmt.Errorf("%w: cannot decrypt %q: %w", ErrEncryptionFailed, field.path, err)
+	}
+	return bson.RawValue{Type: bson.Type(plaintext[0]), Value: plaintext[1:]}, nil
+}
+
+func additionalData(header []byte, field *encryptedField) []byte {
+	return append(slices.Clip(header), field.path...)
+}
+
+// sealDocument returns doc encoded with its encrypted fields encrypted, or doc itself when
+// its type has no encrypted fields.
+func sealDocument(ctx context.Context, doc any) (any, error) {
+	fields := encryptedFieldsOf(reflect.TypeOf(doc))
+	if fields == nil {
+		return doc, nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	return transformDocument(fields, raw, newFieldCipher(ctx).seal)
+}
+
+// openDocument returns raw with the encrypted fields of T decrypted.
+func openDocument(ctx context.Context, t reflect.Type, raw bson.Raw) (bson.Raw, error) {
+	fields := encryptedFieldsOf(t)
+	if fields == nil {
+		return raw, nil
+	}
+	return transformDocument(fields, raw, newFieldCipher(ctx).open)
+}
+
+func transformDocument(
+	fields *encryptedField, raw bson.Raw, leaf func(*encryptedField, bson.RawValue) (bson.RawValue, error),
+) (bson.Raw, error) {
+	rv, err := fields.transform(bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: raw}, leaf)
+	if err != nil {
+		return nil, err
+	}
+	return bson.Raw(rv.Value), nil
+}
+
+// hasEncryptedFields reports whether documents decoded into T hold encrypted fields.
+func hasEncryptedFields[T any]() bool {
+	return encryptedFieldsOf(reflect.TypeFor[T]()) != nil
+}
+
+// unmarshalDocument decodes raw into val, a pointer to a model, after decrypting its fields.
+func unmarshalDocument(ctx context.Context, raw bson.Raw, val any) error {
+	raw, err := openDocument(ctx, reflect.TypeOf(val), raw)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, val)
+}
+
+// sealedDoc is a model whose encrypted fields are encoded by MarshalBSON, so that a datastore
+// inserts the encrypted document and sets the _id of the model.
+type sealedDoc struct {
+	DocInter
+	raw bson.Raw
+}
+
+func (d *sealedDoc) MarshalBSON() ([]byte, error) {
+	return d.raw, nil
+}
+
+// sealModel returns doc ready to be inserted, sealed when it has encrypted fields.
+func sealModel(ctx context.Context, doc DocInter) (DocInter, error) {
+	sealed, err := sealDocument(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	if raw, ok := sealed.(bson.Raw); ok {
+		return &sealedDoc{DocInter: doc, raw: raw}, nil
+	}
+	return doc, nil
+}
+
+// unsealModel returns the model of a document returned by Datastore.Save.
+func unsealModel(doc DocInter) DocInter {
+	if sealed, ok := doc.(*sealedDoc); ok {
+		return sealed.DocInter
+	}
+	return doc
+}
+
+// sealUpdate encrypts the values that the $set and $setOnInsert operators of update assign to
+// the encrypted fields of doc. Other operators cannot modify encrypted fields.
+func sealUpdate(ctx context.Context, doc any, update bson.D) (bson.D, error) {
+	fields := encryptedFieldsOf(reflect.TypeOf(doc))
+	if fields == nil {
+		return update, nil
+	}
+	c := newFieldCipher(ctx)
+	result := make(bson.D, 0, len(update))
+	for _, op := range update {
+		assignments, ok := toDocumentValue(op.Value)
+		if !ok {
+			result = append(result, op)
+			continue
+		}
+		sealed := make(bson.D, 0, len(assignments))
+		for _, e := range assignments {
+			field, _ := fields.lookup(e.Key)
+			if field == nil {
+				sealed = append(sealed, e)
+				continue
+			}
+			if op.Key != "$set" && op.Key != "$setOnInsert" && op.Key != "$unset" {
+				return nil, fmt.Errorf("%w: %s cannot modify encrypted field %q", ErrEncryptionFailed, op.Key, e.Key)
+			}
+			if op.Key == "$unset" {
+				sealed = append(sealed, e)
+				continue
+			}
+			rv, err := toRawValue(e.Value, field.goType)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+			}
+			if rv, err = field.transform(rv, c.seal); err != nil {
+				return nil, err
+			}
+			sealed = append(sealed, bson.E{Key: e.Key, Value: rv})
+		}
+		result = append(result, bson.E{Key: op.Key, Value: sealed})
+	}
+	return result, nil
+}
+
+// sealFilter encrypts the values compared for equality with the deterministic fields of doc
+// in filter, which may combine conditions with $and, $or and $nor. Any other condition on an
+// encrypted field fails, except $exists.
+func sealFilter(ctx context.Context, doc any, filter any) (any, error) {
+	fields := encryptedFieldsOf(reflect.TypeOf(doc))
+	if fields == nil || filter == nil {
+		return filter, nil
+	}
+	return newFilterSealer(ctx, fields).seal(filter)
+}
+
+// sealFilterD is sealFilter for the bson.D filters of the update and delete helpers.
+func sealFilterD(ctx context.Context, doc any, filter bson.D) (bson.D, error) {
+	sealed, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return nil, err
+	}
+	return sealed.(bson.D), nil
+}
+
+type filterSealer struct {
+	fields *encryptedField
+	cipher *fieldCipher
+	keys   []*fieldKey
+}
+
+func newFilterSealer(ctx context.Context, fields *encryptedField) *filterSealer {
+	return &filterSealer{fields: fields, cipher: newFieldCipher(ctx)}
+}
+
+// seal returns the bson.D or bson.M filter with its encrypted conditions rewritten as a bson.D.
+// Filters of other types are returned as they are.
+func (s *filterSealer) seal(filter any) (any, error) {
+	var conditions bson.D
+	switch f := filter.(type) {
+	case bson.D:
+		conditions = f
+	case bson.M:
+		for _, key := range slices.Sorted(maps.Keys(f)) {
+			conditions = append(conditions, bson.E{Key: key, Value: f[key]})
+		}
+	default:
+		return filter, nil
+	}
+	sealed := make(bson.D, 0, len(conditions))
+	for _, e := range conditions {
+		value, err := s.condition(e.Key, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, bson.E{Key: e.Key, Value: value})
+	}
+	return sealed, nil
+}
+
+func (s *filterSealer) condition(key string, value any) (any, error) {
+	switch key {
+	case "$and", "$or", "$nor":
+		clauses, ok := value.(bson.A)
+		if !ok {
+			return value, nil
+		}
+		sealed := make(bson.A, len(clauses))
+		for i, clause := range clauses {
+			var err error
+			if sealed[i], err = s.seal(clause); err != nil {
+				return nil, err
+			}
+		}
+		return sealed, nil
+	}
+	field, below := s.fields.lookup(key)
+	if field == nil {
+		return value, nil
+	}
+	if below || !field.encrypted {
+		return nil, fmt.Errorf("%w: cannot query %q below encrypted fields", ErrEncryptionFailed, key)
+	}
+	operators, isOperators := toOperators(value)
+	if !isOperators {
+		sealed, err := s.equal(field, value, "$in")
+		if values, ok := sealed.(multiValue); ok {
+			return bson.D{bson.E{Key: values.op, Value: values.values}}, err
+		}
+		return sealed, err
+	}
+	sealed := make(bson.D, 0, len(operators))
+	for _, op := range operators {
+		var err error
+		switch op.Key {
+		case "$exists":
+		case "$eq":
+			op.Value, err = s.equal(field, op.Value, "$in")
+		case "$ne":
+			op.Value, err = s.equal(field, op.Value, "$nin")
+		case "$in", "$nin":
+			op.Value, err = s.values(field, op.Value)
+		default:
+			err = fmt.Errorf("%w: %s cannot query encrypted field %q", ErrEncryptionFailed, op.Key, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// $eq and $ne of a value encrypted with several keys become $in and $nin.
+		if values, ok := op.Value.(multiValue); ok {
+			op = bson.E{Key: values.op, Value: values.values}
+		}
+		sealed = append(sealed, op)
+	}
+	return sealed, nil
+}
+
+// multiValue is a value encrypted with several keys, matched with the operator op.
+type multiValue struct {
+	op     string
+	values bson.A
+}
+
+// equal encrypts value for an equality condition, returning a multiValue when it has one
+// ciphertext per key, or {op: values} outside of an operator.
+func (s *filterSealer) equal(field *encryptedField, value any, op string) (any, error) {
+	values, err := s.encrypt(field, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return multiValue{op: op, values: values}, nil
+}
+
+func (s *filterSealer) values(field *encryptedField, value any) (bson.A, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w: expected an array of values for %q", ErrEncryptionFailed, field.path)
+	}
+	result := bson.A{}
+	for i := range v.Len() {
+		encrypted, err := s.encrypt(field, v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, encrypted...)
+	}
+	return result, nil
+}
+
+// encrypt returns the ciphertexts of value with the query keys. nil is matched as it is.
+func (s *filterSealer) encrypt(field *encryptedField, value any) (bson.A, error) {
+	if !field.deterministic {
+		return nil, fmt.Errorf("%w: %q is not deterministic and cannot be queried", ErrEncryptionFailed, field.path)
+	}
+	rv, err := toRawValue(value, field.goType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	if rv.Type == bson.TypeNull || isEncryptedValue(rv) {
+		return bson.A{rv}, nil
+	}
+	if s.keys == nil {
+		if s.keys, err = s.cipher.queryKeys(); err != nil {
+			return nil, err
+		}
+	}
+	values := make(bson.A, 0, len(s.keys))
+	for _, key := range s.keys {
+		encrypted, err := encryptValue(key, field, rv)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encrypted)
+	}
+	return values, nil
+}
+
+// toOperators returns the operators of a condition such as {$in: [...]}.
+func toOperators(value any) (bson.D, bool) {
+	var operators bson.D
+	switch v := value.(type) {
+	case bson.D:
+		operators = v
+	case bson.M:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			operators = append(operators, bson.E{Key: key, Value: v[key]})
+		}
+	default:
+		return nil, false
+	}
+	if len(operators) == 0 || !strings.HasPrefix(operators[0].Key, "$") {
+		return nil, false
+	}
+	return operators, true
+}
+
+// toDocumentValue returns the elements of a bson.D or bson.M update argument.
+func toDocumentValue(value any) (bson.D, bool) {
+	switch v := value.(type) {
+	case bson.D:
+		return v, true
+	case bson.M:
+		d := make(bson.D, 0, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			d = append(d, bson.E{Key: key, Value: v[key]})
+		}
+		return d, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,238 @@
+package mgo
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type secretContact struct {
+	Phone string `bson:"phone" vulpes:"encrypt"`
+	Label string `bson:"label"`
+}
+
+type secretUser struct {
+	Token    *string         `bson:"token,omitempty" vulpes:"encrypt"`
+	Email    string          `bson:"email" vulpes:"encrypt,deterministic"`
+	Name     string          `bson:"name"`
+	Contacts []secretContact `bson:"contacts"`
+	Age      int             `bson:"age" vulpes:"encrypt,deterministic"`
+	ID       bson.ObjectID   `bson:"_id,omitempty"`
+}
+
+func (*secretUser) C() string                   { return "secret_users" }
+func (*secretUser) Indexes() []mongo.IndexModel { return nil }
+func (*secretUser) Validate() error             { return nil }
+func (u *secretUser) GetId() any                { return u.ID }
+func (u *secretUser) SetId(id any)              { u.ID = id.(bson.ObjectID) }
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// storedUser returns the document of user as stored, bypassing decryption.
+func storedUser(t *testing.T, store Datastore, user *secretUser) bson.Raw {
+	t.Helper()
+	raw, err := store.FindOne(context.Background(), user.C(), bson.D{{Key: "_id", Value: user.ID}}).Raw()
+	require.NoError(t, err)
+	return raw
+}
+
+func TestFieldEncryption(t *testing.T) {
+	// Arrange
+	defer SetKeyProvider(NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)}))()
+	store := NewMemoryDatastore()
+	defer SetDatastore(store)()
+	ctx := context.Background()
+	token := "s3cret"
+	user := &secretUser{
+		Name: "alice", Email: "alice@example.com", Age: 30, Token: &token,
+		Contacts: []secretContact{{Phone: "0912", Label: "home"}},
+	}
+
+	// Act
+	_, err := Save(ctx, user)
+	require.NoError(t, err)
+
+	// Assert: encrypted fields are stored as binary values, others in clear.
+	raw := storedUser(t, store, user)
+	for _, path := range [][]string{{"email"}, {"age"}, {"token"}} {
+		assert.True(t, isEncryptedValue(raw.Lookup(path...)), "%v is encrypted", path)
+	}
+	assert.Equal(t, "alice", raw.Lookup("name").StringValue())
+	contact := raw.Lookup("contacts").Array().Index(0).Document()
+	assert.True(t, isEncryptedValue(contact.Lookup("phone")))
+	assert.Equal(t, "home", contact.Lookup("label").StringValue())
+	assert.False(t, bytes.Contains(raw, []byte("alice@example.com")))
+
+	t.Run("Read helpers decrypt", func(t *testing.T) {
+		found := &secretUser{ID: user.ID}
+		require.NoError(t, FindById(ctx, found))
+		assert.Equal(t, user, found)
+
+		all, err := Find(ctx, &secretUser{}, bson.D{}, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, user, all[0])
+	})
+
+	t.Run("Deterministic fields are queryable by equality", func(t *testing.T) {
+		filters := []any{
+			bson.D{{Key: "email", Value: "alice@example.com"}},
+			bson.M{"email": bson.M{"$eq": "alice@example.com"}},
+			bson.D{{Key: "email", Value: bson.D{{Key: "$in", Value: bson.A{"bob@example.com", "alice@example.com"}}}}},
+			bson.D{{Key: "age", Value: int64(30)}},
+			bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "email", Value: "alice@example.com"}}}}},
+		}
+		for _, filter := range filters {
+			found := &secretUser{}
+			require.NoError(t, FindOne(ctx, found, filter), "%v", filter)
+			assert.Equal(t, user.ID, found.ID)
+		}
+		found := &secretUser{}
+		err := FindOne(ctx, found, bson.D{{Key: "email", Value: "bob@example.com"}})
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("Other queries on encrypted fields fail", func(t *testing.T) {
+		filters := []any{
+			bson.D{{Key: "token", Value: "s3cret"}},
+			bson.D{{Key: "contacts.phone", Value: "0912"}},
+			bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 18}}}},
+		}
+		for _, filter := range filters {
+			_, err := Find(ctx, &secretUser{}, filter, 0)
+			assert.ErrorIs(t, err, ErrEncryptionFailed, "%v", filter)
+		}
+	})
+
+	t.Run("Updates encrypt assigned values", func(t *testing.T) {
+		_, err := UpdateById(ctx, user, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "email", Value: "alice@example.org"},
+			{Key: "contacts.0.phone", Value: "0987"},
+		}}})
+		require.NoError(t, err)
+
+		raw := storedUser(t, store, user)
+		assert.True(t, isEncryptedValue(raw.Lookup("email")))
+		found := &secretUser{}
+		require.NoError(t, FindOne(ctx, found, bson.D{{Key: "email", Value: "alice@example.org"}}))
+		assert.Equal(t, "0987", found.Contacts[0].Phone)
+
+		_, err = UpdateById(ctx, user, bson.D{{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}}})
+		assert.ErrorIs(t, err, ErrEncryptionFailed)
+	})
+
+	t.Run("Replacements are encrypted", func(t *testing.T) {
+		user.Email = "alice@example.net"
+		_, err := ReplaceOne(ctx, user, bson.D{{Key: "_id", Value: user.ID}})
+		require.NoError(t, err)
+
+		count, err := CountDocument(ctx, user.C(), bson.D{{Key: "email", Value: "alice@example.net"}})
+		require.NoError(t, err)
+		assert.Zero(t, count, "the value is not stored in clear")
+		found := &secretUser{}
+		require.NoError(t, FindOne(ctx, found, bson.D{{Key: "email", Value: "alice@example.net"}}))
+	})
+}
+
+func TestFieldEncryptionKeyRotation(t *testing.T) {
+	// Arrange: a document is written with k1, then k2 becomes the current key.
+	store := NewMemoryDatastore()
+	defer SetDatastore(store)()
+	ctx := context.Background()
+	restore := SetKeyProvider(NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)}))
+	old, err := Save(ctx, &secretUser{Name: "old", Email: "old@example.com"})
+	restore()
+	require.NoError(t, err)
+	defer SetKeyProvider(NewStaticKeyProvider("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)}))()
+
+	// Act
+	current, err := Save(ctx, &secretUser{Name: "current", Email: "current@example.com"})
+	require.NoError(t, err)
+	users, err := Find(ctx, &secretUser{}, bson.D{{Key: "email", Value: bson.D{
+		{Key: "$in", Value: bson.A{"old@example.com", "current@example.com"}},
+	}}}, 0)
+
+	// Assert: values carry their key ID, and filters match the values of every key.
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+	for user, keyID := range map[*secretUser]string{old: "k1", current: "k2"} {
+		_, data := storedUser(t, store, user).Lookup("email").Binary()
+		assert.Equal(t, keyID, string(data[3:3+data[2]]))
+	}
+
+	t.Run("Unknown keys fail", func(t *testing.T) {
+		defer SetKeyProvider(NewStaticKeyProvider("k2", map[string][]byte{"k2": testKey(2)}))()
+		err := FindById(ctx, &secretUser{ID: old.ID})
+		assert.ErrorIs(t, err, ErrEncryptionFailed)
+		assert.ErrorContains(t, err, `unknown key "k1"`)
+	})
+}
+
+func TestEncryptValue(t *testing.T) {
+	key, err := deriveFieldKey("k1", testKey(1))
+	require.NoError(t, err)
+	value, err := toRawValue("alice@example.com", nil)
+	require.NoError(t, err)
+	email := &encryptedField{path: "email", encrypted: true, deterministic: true}
+	phone := &encryptedField{path: "phone", encrypted: true}
+	defer SetKeyProvider(NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey(1)}))()
+
+	tests := []struct {
+		tamper   func(data []byte)
+		field    *encryptedField
+		openAs   *encryptedField
+		name     string
+		wantErr  bool
+		wantSame bool
+	}{
+		{name: "Deterministic", field: email, openAs: email, wantSame: true},
+		{name: "Random", field: phone, openAs: phone},
+		{name: "Moved to another field", field: phone, openAs: email, wantErr: true},
+		{
+			name: "Tampered", field: phone, openAs: phone, wantErr: true,
+			tamper: func(data []byte) { data[len(data)-1] ^= 1 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			first, err := encryptValue(key, tt.field, value)
+			require.NoError(t, err)
+			second, err := encryptValue(key, tt.field, value)
+			require.NoError(t, err)
+			if tt.tamper != nil {
+				_, data, _ := first.BinaryOK()
+				tt.tamper(data)
+			}
+			opened, err := newFieldCipher(context.Background()).open(tt.openAs, first)
+
+			// Assert
+			assert.Equal(t, tt.wantSame, first.Equal(second))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrEncryptionFailed)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice@example.com", opened.StringValue())
+		})
+	}
+
+	t.Run("Values stored in clear are read as they are", func(t *testing.T) {
+		opened, err := newFieldCipher(context.Background()).open(email, value)
+		require.NoError(t, err)
+		assert.Equal(t, value, opened)
+	})
+
+	t.Run("Short keys are rejected", func(t *testing.T) {
+		_, err := deriveFieldKey("short", []byte(strings.Repeat("k", 16)))
+		assert.ErrorIs(t, err, ErrEncryptionFailed)
+	})
+}
@@ -5,6 +5,8 @@ package mgo
 import (
 	"errors"
 
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -28,6 +30,22 @@ var (
 	ErrWriteFailed = errors.New("mongodb write failed")
 	// ErrReadFailed is returned when a read operation fails.
 	ErrReadFailed = errors.New("mongodb read failed")
+	// ErrTransactionFailed is returned when a transaction cannot start or fails to commit.
+	ErrTransactionFailed = errors.New("mongodb transaction failed")
+	// ErrMigrationLocked is returned when another process is already running migrations.
+	ErrMigrationLocked = errors.New("mongodb migration locked")
+	// ErrMigrationFailed is returned when a migration step fails or cannot be reverted.
+	ErrMigrationFailed = errors.New("mongodb migration failed")
+	// ErrInvalidCursor is returned when a pagination continuation token is malformed or has been tampered with.
+	ErrInvalidCursor = errors.New("mongodb invalid pagination cursor")
+	// ErrVersionConflict is returned when a versioned document was modified by someone else since it was read.
+	ErrVersionConflict = errors.New("mongodb version conflict")
+	// ErrInvalidTenant is returned when an operation has no tenant while tenancy requires one, or an invalid one.
+	ErrInvalidTenant = errors.New("mongodb invalid tenant")
+	// ErrEncryptionFailed is returned when an encrypted field cannot be encrypted, decrypted or queried.
+	ErrEncryptionFailed = errors.New("mongodb field encryption failed")
+	// ErrHookFailed is returned when a lifecycle hook of a model, such as BeforeSave, returns an error.
+	ErrHookFailed = errors.New("mongodb hook failed")
 
 	StatusMongoDBInvalidDocument      = status.New(codes.InvalidArgument, "mongodb invalid document")
 	StatusMongoDBNotConnected         = status.New(codes.Aborted, "mongodb not connected")
@@ -37,6 +55,14 @@ var (
 	StatusMongoDBListCollectionFailed = status.New(codes.Aborted, "mongodb list collection failed")
 	StatusMongoDBWriteFailed          = status.New(codes.Internal, "mongodb write failed")
 	StatusMongoDBReadFailed           = status.New(codes.Internal, "mongodb read failed")
+	StatusMongoDBTransactionFailed    = status.New(codes.Aborted, "mongodb transaction failed")
+	StatusMongoDBMigrationLocked      = status.New(codes.Aborted, "mongodb migration locked")
+	StatusMongoDBMigrationFailed      = status.New(codes.Internal, "mongodb migration failed")
+	StatusMongoDBInvalidCursor        = status.New(codes.InvalidArgument, "mongodb invalid pagination cursor")
+	StatusMongoDBVersionConflict      = status.New(codes.Aborted, "mongodb version conflict")
+	StatusMongoDBInvalidTenant        = status.New(codes.InvalidArgument, "mongodb invalid tenant")
+	StatusMongoDBEncryptionFailed     = status.New(codes.Internal, "mongodb field encryption failed")
+	StatusMongoDBHookFailed           = status.New(codes.FailedPrecondition, "mongodb hook failed")
 )
 
 func ToStatus(err error) *status.Status {
@@ -48,6 +74,8 @@ func ToStatus(err error) *status.Status {
 	switch {
 	case errors.Is(err, ErrInvalidDocument):
 		baseSt = StatusMongoDBInvalidDocument
+	case errors.Is(err, ErrInvalidTenant):
+		baseSt = StatusMongoDBInvalidTenant
 	case errors.Is(err, ErrNotConnected):
 		baseSt = StatusMongoDBNotConnected
 	case errors.Is(err, ErrConnectionFailed):
@@ -58,10 +86,24 @@ func ToStatus(err error) *status.Status {
 		baseSt = StatusMongoDBCreateIndexFailed
 	case errors.Is(err, ErrListCollectionFailed):
 		baseSt = StatusMongoDBListCollectionFailed
+	case errors.Is(err, ErrEncryptionFailed):
+		baseSt = StatusMongoDBEncryptionFailed
 	case errors.Is(err, ErrWriteFailed):
 		baseSt = StatusMongoDBWriteFailed
 	case errors.Is(err, ErrReadFailed):
 		baseSt = StatusMongoDBReadFailed
+	case errors.Is(err, ErrTransactionFailed):
+		baseSt = StatusMongoDBTransactionFailed
+	case errors.Is(err, ErrMigrationLocked):
+		baseSt = StatusMongoDBMigrationLocked
+	case errors.Is(err, ErrMigrationFailed):
+		baseSt = StatusMongoDBMigrationFailed
+	case errors.Is(err, ErrInvalidCursor):
+		baseSt = StatusMongoDBInvalidCursor
+	case errors.Is(err, ErrVersionConflict):
+		baseSt = StatusMongoDBVersionConflict
+	case errors.Is(err, ErrHookFailed):
+		baseSt = StatusMongoDBHookFailed
 	default:
 		return status.New(codes.Internal, err.Error())
 	}
@@ -87,3 +129,46 @@ func ToStatus(err error) *status.Status {
 	}
 	return st
 }
+
+// transientErrorCodes are server error codes raised while a replica set elects
+// a new primary or a node shuts down. The operation may succeed when retried.
+var transientErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isTransientError reports whether err is a network failure, a timeout or a server
+// error labelled or coded as retryable.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var le mongo.LabeledError
+	if errors.As(err, &le) &&
+		(le.HasErrorLabel("RetryableWriteError") ||
+			le.HasErrorLabel("TransientTransactionError") ||
+			le.HasErrorLabel("ResumableChangeStreamError")) {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		for _, code := range transientErrorCodes {
+			if se.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package mgo
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type exportConfig struct {
+	format   DataFormat
+	fields   []string
+	findOpts []options.Lister[options.FindOptions]
+}
+
+// ExportOption configures Export.
+type ExportOption func(*exportConfig)
+
+// WithExportFormat sets the output format; the default is FormatNDJSON.
+func WithExportFormat(format DataFormat) ExportOption {
+	return func(c *exportConfig) {
+		c.format = format
+	}
+}
+
+// WithExportFields sets the CSV columns. By default the fields of the first document are used.
+func WithExportFields(fields ...string) ExportOption {
+	return func(c *exportConfig) {
+		c.fields = fields
+	}
+}
+
+// WithExportFindOptions passes options such as a sort or projection to the underlying Find.
+func WithExportFindOptions(opts ...options.Lister[options.FindOptions]) ExportOption {
+	return func(c *exportConfig) {
+		c.findOpts = append(c.findOpts, opts...)
+	}
+}
+
+// Export streams the documents of the collection matching filter to w and returns how many
+// were written. Documents are written as relaxed Extended JSON, so that Import reads them back.
+// In CSV, ObjectIDs are written as hex, dates as RFC 3339 and nested values as Extended JSON.
+// Soft-deleted documents are skipped unless the context is marked with IncludeDeleted, provided
+// the collection is registered with RegisterIndex as a SoftDeleter model or with WithSoftDelete;
+// ExportModel knows it from the model.
+func Export(
+	ctx context.Context, collectionName string, w io.Writer, filter any, opts ...ExportOption,
+) (int64, error) {
+	return ExportOn(ctx, defaultDB, collectionName, w, filter, opts...)
+}
+
+// ExportOn is like Export but reads from db.
+func ExportOn(
+	ctx context.Context, db *DB, collectionName string, w io.Writer, filter any, opts ...ExportOption,
+) (int64, error) {
+	return export(ctx, db, collectionName, w, filter, isSoftDeleteCollection(collectionName), opts)
+}
+
+// ExportModel is like Export but reads doc.C(), skipping soft-deleted documents when doc is
+// a SoftDeleter.
+func ExportModel[T DocInter](
+	ctx context.Context, doc T, w io.Writer, filter any, opts ...ExportOption,
+) (int64, error) {
+	return ExportModelOn(ctx, defaultDB, doc, w, filter, opts...)
+}
+
+// ExportModelOn is like ExportModel but reads from db.
+func ExportModelOn[T DocInter](
+	ctx context.Context, db *DB, doc T, w io.Writer, filter any, opts ...ExportOption,
+) (int64, error) {
+	softDelete := isSoftDeleter(doc) || isSoftDeleteCollection(doc.C())
+	return export(ctx, db, doc.C(), w, filter, softDelete, opts)
+}
+
+func export(
+	ctx context.Context, db *DB, collectionName string, w io.Writer, filter any, softDelete bool,
+	opts []ExportOption,
+) (int64, error) {
+	store := db.datastore()
+	if store == nil {
+		return 0, ErrNotConnected
+	}
+	cfg := &exportConfig{format: FormatNDJSON}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	filter = excludeDeleted(ctx, softDelete, filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "export", filter)
+	defer span.End()
+
+	var writer docWriter
+	switch cfg.format {
+	case FormatNDJSON, FormatJSONArray:
+		writer = &jsonDocWriter{w: bufio.NewWriter(w), array: cfg.format == FormatJSONArray}
+	case FormatCSV:
+		writer = &csvDocWriter{w: csv.NewWriter(w), fields: cfg.fields}
+	default:
+		return 0, spanErrorHandler(fmt.Errorf("%w: unsupported format %q", ErrInvalidDocument, cfg.format), span)
+	}
+	cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.Find(ctx, collectionName, filter, cfg.findOpts...)
+	})
+	if err != nil {
+		return 0, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	defer cursor.Close(ctx)
+
+	var count int64
+	for cursor.Next(ctx) {
+		if err := writer.write(cursor.Current); err != nil {
+			return count, spanErrorHandler(err, span)
+		}
+		count++
+	}
+	span.SetAttributes(attribute.Int64("db.export.written", count))
+	if err := cursor.Err(); err != nil {
+		return count, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	return count, spanErrorHandler(writer.close(), span)
+}
+
+type docWriter interface {
+	write(doc bson.Raw) error
+	close() error
+}
+
+type jsonDocWriter struct {
+	w       *bufio.Writer
+	array   bool
+	written bool
+}
+
+func (j *jsonDocWriter) write(doc bson.Raw) error {
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	if j.array {
+		prefix := byte(',')
+		if !j.written {
+			prefix = '['
+		}
+		if err := j.w.WriteByte(prefix); err != nil {
+			return err
+		}
+	}
+	j.written = true
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	if !j.array {
+		return j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (j *jsonDocWriter) close() error {
+	if j.array {
+		end := "]\n"
+		if !j.written {
+			end = "[]\n"
+		}
+		if _, err := j.w.WriteString(end); err != nil {
+			return err
+		}
+	}
+	return j.w.Flush()
+}
+
+type csvDocWriter struct {
+	w      *csv.Writer
+	fields []string
+	header bool
+}
+
+func (c *csvDocWriter) write(doc bson.Raw) error {
+	if !c.header {
+		if len(c.fields) == 0 {
+			elems, err := doc.Elements()
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+			}
+			for _, e := range elems {
+				c.fields = append(c.fields, e.Key())
+			}
+		}
+		if err := c.w.Write(c.fields); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	record := make([]string, len(c.fields))
+	for i, field := range c.fields {
+		value, err := doc.LookupErr(field)
+		if err != nil {
+			continue
+		}
+		record[i] = formatCSVCell(value)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvDocWriter) close() error {
+	if !c.header && len(c.fields) > 0 {
+		if err := c.w.Write(c.fields); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatCSVCell renders value as a CSV cell; strings, integers and booleans round-trip through parseCSVCell.
+func formatCSVCell(value bson.RawValue) string {
+	switch value.Type {
+	case bson.TypeString:
+		return value.StringValue()
+	case bson.TypeObjectID:
+		return value.ObjectID().Hex()
+	case bson.TypeInt32:
+		return strconv.FormatInt(int64(value.Int32()), 10)
+	case bson.TypeInt64:
+		return strconv.FormatInt(value.Int64(), 10)
+	case bson.TypeDouble:
+		return strconv.FormatFloat(value.Double(), 'g', -1, 64)
+	case bson.TypeBoolean:
+		return strconv.FormatBool(value.Boolean())
+	case bson.TypeDateTime:
+		return value.Time().UTC().Format(time.RFC3339Nano)
+	case bson.TypeNull, bson.TypeUndefined:
+		return ""
+	default:
+		return value.String()
+	}
+}
@@ -0,0 +1,150 @@
+package mgo_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// findDocuments returns a datastore whose Find yields docs and records the filter.
+func findDocuments(t *testing.T, filter *any, docs ...bson.D) *mgo.MockDatastore {
+	t.Helper()
+	return &mgo.MockDatastore{
+		OnFind: func(
+			_ context.Context, _ string, f any, _ ...options.Lister[options.FindOptions],
+		) (*mongo.Cursor, error) {
+			*filter = f
+			items := make([]any, len(docs))
+			for i, d := range docs {
+				items[i] = d
+			}
+			return mongo.NewCursorFromDocuments(items, nil, nil)
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	id, _ := bson.ObjectIDFromHex("60f6a9b4a4b7c2a3e4f5a6b7")
+	createdAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	docs := []bson.D{
+		{{Key: "_id", Value: id}, {Key: "name", Value: "Peter"}, {Key: "age", Value: int64(30)}},
+		{{Key: "_id", Value: id}, {Key: "name", Value: "Amy, Jr."}, {Key: "created_at", Value: createdAt}},
+	}
+
+	t.Run("NDJSON", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(findDocuments(t, &filter, docs...))
+		defer restore()
+		var buf bytes.Buffer
+
+		// Act
+		n, err := mgo.Export(context.Background(), "users", &buf, bson.D{{Key: "age", Value: 30}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+		assert.Equal(t, bson.D{{Key: "age", Value: 30}}, filter)
+		assert.Equal(t,
+			`{"_id":{"$oid":"60f6a9b4a4b7c2a3e4f5a6b7"},"name":"Peter","age":30}`+"\n"+
+				`{"_id":{"$oid":"60f6a9b4a4b7c2a3e4f5a6b7"},"name":"Amy, Jr.",`+
+				`"created_at":{"$date":"2024-05-01T08:00:00Z"}}`+"\n",
+			buf.String())
+	})
+
+	t.Run("JSON array", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(findDocuments(t, &filter, docs[0]))
+		defer restore()
+		var buf bytes.Buffer
+
+		// Act
+		_, err := mgo.Export(context.Background(), "users", &buf, nil, mgo.WithExportFormat(mgo.FormatJSONArray))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{}, filter)
+		assert.Equal(t, `[{"_id":{"$oid":"60f6a9b4a4b7c2a3e4f5a6b7"},"name":"Peter","age":30}]`+"\n", buf.String())
+	})
+
+	t.Run("Empty JSON array", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(findDocuments(t, &filter))
+		defer restore()
+		var buf bytes.Buffer
+
+		// Act
+		n, err := mgo.Export(context.Background(), "users", &buf, nil, mgo.WithExportFormat(mgo.FormatJSONArray))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Zero(t, n)
+		assert.Equal(t, "[]\n", buf.String())
+	})
+
+	t.Run("CSV with selected fields", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(findDocuments(t, &filter, docs...))
+		defer restore()
+		var buf bytes.Buffer
+
+		// Act
+		_, err := mgo.Export(context.Background(), "users", &buf, nil,
+			mgo.WithExportFormat(mgo.FormatCSV), mgo.WithExportFields("_id", "name", "age", "created_at"))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t,
+			"_id,name,age,created_at\n"+
+				"60f6a9b4a4b7c2a3e4f5a6b7,Peter,30,\n"+
+				"60f6a9b4a4b7c2a3e4f5a6b7,\"Amy, Jr.\",,2024-05-01T08:00:00Z\n",
+			buf.String())
+	})
+
+	t.Run("Round trip through ImportStream", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(findDocuments(t, &filter, docs...))
+		var buf bytes.Buffer
+		_, err := mgo.Export(context.Background(), "users", &buf, nil)
+		restore()
+		require.NoError(t, err)
+		var batches [][]mongo.WriteModel
+		restore = mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", &buf)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), report.Read)
+		require.Len(t, batches, 1)
+		// Relaxed Extended JSON reads small integers back as int32.
+		assert.Equal(t, bson.M{"_id": id, "name": "Peter", "age": int32(30)}, insertedDoc(t, batches[0][0]))
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{})
+		defer restore()
+
+		// Act
+		_, err := mgo.Export(context.Background(), "users", &bytes.Buffer{}, nil, mgo.WithExportFormat("xml"))
+
+		// Assert
+		assert.ErrorIs(t, err, mgo.ErrInvalidDocument)
+	})
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
 
@@ -15,21 +17,37 @@ func Find[T DocInter](
 	ctx context.Context, doc T, filter any, limit uint16,
 	opts ...options.Lister[options.FindOptions],
 ) ([]T, error) {
-	if dataStore == nil {
+	return FindOn(ctx, defaultDB, doc, filter, limit, opts...)
+}
+
+// FindOn is like Find but reads from db.
+func FindOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any, limit uint16,
+	opts ...options.Lister[options.FindOptions],
+) ([]T, error) {
+	store := db.datastore()
+	if store == nil {
 		return nil, ErrNotConnected
 	}
 	if limit == 0 {
 		limit = 100
 	}
+	filter, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return nil, err
+	}
+	filter = excludeDeleted(ctx, isSoftDeleter(doc), filter)
 	collectionName := doc.C()
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "find", filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "find", filter)
 	defer span.End()
 
 	finalArgs := make([]options.Lister[options.FindOptions], 0, len(opts)+1)
 	finalArgs = append(finalArgs, options.Find().SetLimit(int64(limit)))
 	finalArgs = append(finalArgs, opts...)
 
-	result, err := dataStore.Find(ctx, doc.C(), filter, finalArgs...)
+	result, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.Find(ctx, doc.C(), filter, finalArgs...)
+	})
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			span.SetStatus(codes.Ok, "ok")
@@ -41,6 +59,9 @@ func Find[T DocInter](
 	if err != nil {
 		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
+	if err := afterFindAll(ctx, ret); err != nil {
+		return nil, spanErrorHandler(err, span)
+	}
 	return ret, spanErrorHandler(nil, span)
 }
 
@@ -48,13 +69,40 @@ func FindOne[T DocInter](
 	ctx context.Context, doc T, filter any,
 	opts ...options.Lister[options.FindOneOptions],
 ) error {
-	if dataStore == nil {
+	return FindOneOn(ctx, defaultDB, doc, filter, opts...)
+}
+
+// FindOneOn is like FindOne but reads from db.
+func FindOneOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any,
+	opts ...options.Lister[options.FindOneOptions],
+) error {
+	store := db.datastore()
+	if store == nil {
 		return ErrNotConnected
 	}
+	filter, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return err
+	}
+	c, key, useCache := cacheable(ctx, doc, filter, len(opts))
+	filter = excludeDeleted(ctx, isSoftDeleter(doc), filter)
 	collectionName := doc.C()
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "findOne", filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "findOne", filter)
 	defer span.End()
-	err := dataStore.FindOne(ctx, doc.C(), filter, opts...).Decode(&doc)
+	if useCache {
+		var hit bool
+		hit, err = findOneCached(ctx, doc, c, key, func() (bson.Raw, error) {
+			return retryValue(ctx, span, true, func() (bson.Raw, error) {
+				return store.FindOne(ctx, collectionName, filter).Raw()
+			})
+		})
+		span.SetAttributes(attribute.Bool("db.cache.hit", hit))
+	} else {
+		err = retry(ctx, span, true, func() error {
+			return decodeSingleResult(ctx, store.FindOne(ctx, doc.C(), filter, opts...), &doc)
+		})
+	}
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			span.SetStatus(codes.Ok, "ok")
@@ -62,20 +110,29 @@ func FindOne[T DocInter](
 		}
 		return spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
-	return spanErrorHandler(nil, span)
+	return spanErrorHandler(afterFind(ctx, doc), span)
 }
 
 func FindById[T DocInter](ctx context.Context, doc T) error {
-	if dataStore == nil {
+	return FindByIdOn(ctx, defaultDB, doc)
+}
+
+// FindByIdOn is like FindById but reads from db.
+func FindByIdOn[T DocInter](ctx context.Context, db *DB, doc T) error {
+	if db.datastore() == nil {
 		return ErrNotConnected
 	}
-	return FindOne(ctx, doc, bson.M{"_id": doc.GetId()})
+	return FindOneOn(ctx, db, doc, bson.M{"_id": doc.GetId()})
 }
 
 func (m *mongoStore) Find(
 	ctx context.Context, collectionName string, filter any,
 	opts ...options.Lister[options.FindOptions],
 ) (*mongo.Cursor, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
 	collection := m.getCollection(collectionName)
 	cursor, err := collection.Find(ctx, filter, opts...)
 	if err != nil {
@@ -88,6 +145,10 @@ func (m *mongoStore) FindOne(
 	ctx context.Context, collectionName string, filter any,
 	opts ...options.Lister[options.FindOneOptions],
 ) *mongo.SingleResult {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
 	collection := m.getCollection(collectionName)
 	return collection.FindOne(ctx, filter, opts...)
 }
@@ -95,20 +156,60 @@ func (m *mongoStore) FindOne(
 func cursorToSlice[T any](ctx context.Context, cursor *mongo.Cursor, cap int) ([]T, error) {
 	ret := make([]T, 0, cap)
 	for cursor.Next(ctx) {
-		var t T
-		// 如果 T 是指標類型 (例如 *ComplexStruct)，需要初始化
-		// 這裡利用 any(t) 進行 UnmarshalBSON 斷言，實現高效解碼
-		if unmarshaler, ok := any(&t).(bson.Unmarshaler); ok {
-			if err := unmarshaler.UnmarshalBSON(cursor.Current); err != nil {
-				return nil, err
-			}
-		} else {
-			// 備援方案：若沒實作 UnmarshalBSON 則走預設解碼
-			if err := cursor.Decode(&t); err != nil {
-				return nil, err
-			}
+		t, err := decodeCursor[T](ctx, cursor)
+		if err != nil {
+			return nil, err
 		}
 		ret = append(ret, t)
 	}
 	return ret, nil
 }
+
+// decodeSingleResult decodes result into val, decrypting the encrypted fields of val.
+func decodeSingleResult(ctx context.Context, result *mongo.SingleResult, val any) error {
+	if encryptedFieldsOf(reflect.TypeOf(val)) == nil {
+		return result.Decode(val)
+	}
+	raw, err := result.Raw()
+	if err != nil {
+		return err
+	}
+	return unmarshalDocument(ctx, raw, val)
+}
+
+// decodeCursor decodes the current document of cursor into a new T.
+func decodeCursor[T any](ctx context.Context, cursor *mongo.Cursor) (T, error) {
+	if hasEncryptedFields[T]() {
+		return decodeRaw[T](ctx, cursor.Current)
+	}
+	var t T
+	// 如果 T 是指標類型 (例如 *ComplexStruct)，需要初始化
+	// 這裡利用 any(t) 進行 UnmarshalBSON 斷言，實現高效解碼
+	if unmarshaler, ok := any(&t).(bson.Unmarshaler); ok {
+		if err := unmarshaler.UnmarshalBSON(cursor.Current); err != nil {
+			return t, err
+		}
+		return t, nil
+	}
+	// 備援方案：若沒實作 UnmarshalBSON 則走預設解碼
+	if err := cursor.Decode(&t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// andFilter returns filter restricted by the additional condition cond.
+// bson.D filters get cond appended; other filters are combined with $and.
+func andFilter(filter any, cond bson.E) any {
+	switch f := filter.(type) {
+	case nil:
+		return bson.D{cond}
+	case bson.D:
+		return append(f[:len(f):len(f)], cond)
+	case bson.M:
+		if len(f) == 0 {
+			return bson.D{cond}
+		}
+	}
+	return bson.D{bson.E{Key: "$and", Value: bson.A{filter, bson.D{cond}}}}
+}
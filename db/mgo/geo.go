@@ -0,0 +1,209 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/94peter/vulpes/db/mgo/types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// geoDistanceField is the field GeoNear stores the computed distance in.
+const geoDistanceField = "_geo_distance"
+
+// GeoIndex returns a 2dsphere index on fields, which must hold GeoJSON objects such as
+// types.Location. Near, Within and GeoNear need one on the queried field.
+func GeoIndex(fields ...string) mongo.IndexModel {
+	keys := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: "2dsphere"})
+	}
+	return mongo.IndexModel{Keys: keys}
+}
+
+// WithGeoIndex adds a 2dsphere index on each of fields to a collection definition:
+//
+//	mgo.RegisterIndex(mgo.NewCollectDef("places", placeIndexes, mgo.WithGeoIndex("location")))
+func WithGeoIndex(fields ...string) CollectDefOption {
+	return func(c *collectDef) {
+		for _, field := range fields {
+			c.indexes = append(c.indexes, GeoIndex(field))
+		}
+	}
+}
+
+// GeoResult is a document returned by GeoNear with its distance from the queried point in meters.
+type GeoResult[T any] struct {
+	Doc      T
+	Distance float64
+}
+
+// GeoOption configures Near, Within and GeoNear.
+type GeoOption func(*geoConfig)
+
+type geoConfig struct {
+	filter      any
+	maxDistance *float64
+	minDistance *float64
+}
+
+// WithMaxDistance limits Near and GeoNear to documents at most meters away from the point.
+func WithMaxDistance(meters float64) GeoOption {
+	return func(c *geoConfig) {
+		c.maxDistance = &meters
+	}
+}
+
+// WithMinDistance limits Near and GeoNear to documents at least meters away from the point.
+func WithMinDistance(meters float64) GeoOption {
+	return func(c *geoConfig) {
+		c.minDistance = &meters
+	}
+}
+
+// WithGeoFilter adds a query filter that the returned documents must match as well.
+func WithGeoFilter(filter any) GeoOption {
+	return func(c *geoConfig) {
+		c.filter = filter
+	}
+}
+
+// validateGeometry validates a point or an area, rejecting a nil one before Validate
+// dereferences it.
+func validateGeometry(g interface{ Validate() error }) error {
+	if v := reflect.ValueOf(g); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return fmt.Errorf("%w: %w", ErrInvalidDocument, errors.New("geometry cannot be nil"))
+	}
+	if err := g.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	return nil
+}
+
+func newGeoConfig(opts []GeoOption) *geoConfig {
+	cfg := &geoConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Near returns up to limit documents whose GeoJSON field is near point, nearest first.
+// A limit of 0 returns up to 100 documents, like Find.
+func Near[T DocInter](
+	ctx context.Context, doc T, field string, point *types.Location, limit uint16, opts ...GeoOption,
+) ([]T, error) {
+	return NearOn(ctx, defaultDB, doc, field, point, limit, opts...)
+}
+
+// NearOn is like Near but reads from db.
+func NearOn[T DocInter](
+	ctx context.Context, db *DB, doc T, field string, point *types.Location, limit uint16, opts ...GeoOption,
+) ([]T, error) {
+	if err := validateGeometry(point); err != nil {
+		return nil, err
+	}
+	cfg := newGeoConfig(opts)
+	near := bson.D{bson.E{Key: "$geometry", Value: point}}
+	if cfg.maxDistance != nil {
+		near = append(near, bson.E{Key: "$maxDistance", Value: *cfg.maxDistance})
+	}
+	if cfg.minDistance != nil {
+		near = append(near, bson.E{Key: "$minDistance", Value: *cfg.minDistance})
+	}
+	cond := bson.E{Key: field, Value: bson.D{bson.E{Key: "$near", Value: near}}}
+	return FindOn(ctx, db, doc, andFilter(cfg.filter, cond), limit)
+}
+
+// Within returns up to limit documents whose GeoJSON field lies within area.
+// A limit of 0 returns up to 100 documents, like Find. Distance options are ignored.
+func Within[T DocInter](
+	ctx context.Context, doc T, field string, area types.Area, limit uint16, opts ...GeoOption,
+) ([]T, error) {
+	return WithinOn(ctx, defaultDB, doc, field, area, limit, opts...)
+}
+
+// WithinOn is like Within but reads from db.
+func WithinOn[T DocInter](
+	ctx context.Context, db *DB, doc T, field string, area types.Area, limit uint16, opts ...GeoOption,
+) ([]T, error) {
+	if err := validateGeometry(area); err != nil {
+		return nil, err
+	}
+	cfg := newGeoConfig(opts)
+	within := bson.D{bson.E{Key: "$geoWithin", Value: bson.D{bson.E{Key: "$geometry", Value: area}}}}
+	return FindOn(ctx, db, doc, andFilter(cfg.filter, bson.E{Key: field, Value: within}), limit)
+}
+
+// GeoNear runs a $geoNear aggregation and returns up to limit documents whose GeoJSON field
+// is near point, nearest first, with their distance in meters. A limit of 0 returns up to 100
+// documents. Soft-deleted documents are excluded unless the context is IncludeDeleted.
+func GeoNear[T DocInter](
+	ctx context.Context, doc T, field string, point *types.Location, limit uint16, opts ...GeoOption,
+) ([]GeoResult[T], error) {
+	return GeoNearOn(ctx, defaultDB, doc, field, point, limit, opts...)
+}
+
+// GeoNearOn is like GeoNear but runs the aggregation on db.
+func GeoNearOn[T DocInter](
+	ctx context.Context, db *DB, doc T, field string, point *types.Location, limit uint16, opts ...GeoOption,
+) ([]GeoResult[T], error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	if err := validateGeometry(point); err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = 100
+	}
+	cfg := newGeoConfig(opts)
+	geoNear := bson.D{
+		bson.E{Key: "near", Value: point},
+		bson.E{Key: "key", Value: field},
+		bson.E{Key: "distanceField", Value: geoDistanceField},
+		bson.E{Key: "spherical", Value: true},
+	}
+	if query := excludeDeleted(ctx, isSoftDeleter(doc), cfg.filter); query != nil {
+		geoNear = append(geoNear, bson.E{Key: "query", Value: query})
+	}
+	if cfg.maxDistance != nil {
+		geoNear = append(geoNear, bson.E{Key: "maxDistance", Value: *cfg.maxDistance})
+	}
+	if cfg.minDistance != nil {
+		geoNear = append(geoNear, bson.E{Key: "minDistance", Value: *cfg.minDistance})
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: geoNear}},
+		{{Key: "$limit", Value: int64(limit)}},
+	}
+	collectionName := doc.C()
+	_, span := store.startTraceSpan(ctx, collectionName, "geoNear", pipeline)
+	defer span.End()
+
+	cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.PipeFind(ctx, collectionName, pipeline)
+	})
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	defer cursor.Close(ctx)
+	results := make([]GeoResult[T], 0, cursor.RemainingBatchLength())
+	for cursor.Next(ctx) {
+		d, err := decodeRaw[T](ctx, cursor.Current)
+		if err != nil {
+			return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+		}
+		distance, _ := cursor.Current.Lookup(geoDistanceField).DoubleOK()
+		results = append(results, GeoResult[T]{Doc: d, Distance: distance})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	return results, spanErrorHandler(nil, span)
+}
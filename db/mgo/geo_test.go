@@ -0,0 +1,204 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+	"github.com/94peter/vulpes/db/mgo/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type geoPlace struct {
+	Location *types.Location `bson:"location"`
+	Name     string          `bson:"name"`
+	ID       bson.ObjectID   `bson:"_id,omitempty"`
+}
+
+func (*geoPlace) C() string                   { return "geo_places" }
+func (*geoPlace) Indexes() []mongo.IndexModel { return []mongo.IndexModel{mgo.GeoIndex("location")} }
+func (*geoPlace) Validate() error             { return nil }
+func (p *geoPlace) GetId() any                { return p.ID }
+func (p *geoPlace) SetId(id any)              { p.ID, _ = id.(bson.ObjectID) }
+
+// square returns a closed ring around the given position.
+func square(lng, lat, size float64) [][]float64 {
+	return [][]float64{{lng, lat}, {lng + size, lat}, {lng + size, lat + size}, {lng, lat + size}, {lng, lat}}
+}
+
+func TestGeoJSONValidate(t *testing.T) {
+	tests := []struct {
+		geometry interface{ Validate() error }
+		name     string
+		wantErr  bool
+	}{
+		{name: "Point", geometry: types.NewLocationPoint(121.5, 25.0)},
+		{name: "Point out of range", geometry: types.NewLocationPoint(25.0, 121.5), wantErr: true},
+		{name: "LineString", geometry: types.NewLineString([]float64{121, 25}, []float64{121.1, 25.1})},
+		{name: "LineString with one position", geometry: types.NewLineString([]float64{121, 25}), wantErr: true},
+		{name: "Polygon with hole", geometry: types.NewPolygon(square(121, 25, 1), square(121.2, 25.2, 0.1))},
+		{name: "Polygon with open ring", geometry: types.NewPolygon(square(121, 25, 1)[:4]), wantErr: true},
+		{name: "Polygon out of range", geometry: types.NewPolygon(square(179.5, 25, 1)), wantErr: true},
+		{name: "MultiPolygon", geometry: types.NewMultiPolygon(
+			[][][]float64{square(121, 25, 1)}, [][][]float64{square(120, 22, 1)},
+		)},
+		{name: "MultiPolygon without polygons", geometry: types.NewMultiPolygon(), wantErr: true},
+		{name: "Wrong type", geometry: &types.Polygon{Type: "Point"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := tt.geometry.Validate()
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorIs(t, err, types.ErrInvalidGeoJSON)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWithGeoIndex(t *testing.T) {
+	// Act
+	def := mgo.NewCollectDef("geo_places", func() []mongo.IndexModel { return nil }, mgo.WithGeoIndex("location", "area"))
+
+	// Assert
+	require.Len(t, def.Indexes(), 2)
+	assert.Equal(t, bson.D{{Key: "area", Value: "2dsphere"}}, def.Indexes()[1].Keys)
+}
+
+func TestNearAndWithin(t *testing.T) {
+	ctx := context.Background()
+	point := types.NewLocationPoint(121.5, 25.0)
+
+	t.Run("Near", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnFind: func(
+				ctx context.Context, c string, f any, opts ...options.Lister[options.FindOptions],
+			) (*mongo.Cursor, error) {
+				filter = f
+				return mgo.NewOnFindMock(bson.D{{Key: "name", Value: "Taipei 101"}})(ctx, c, f, opts...)
+			},
+		})
+		defer restore()
+
+		// Act
+		places, err := mgo.Near(ctx, &geoPlace{}, "location", point, 10,
+			mgo.WithMaxDistance(500), mgo.WithGeoFilter(bson.D{{Key: "name", Value: bson.D{{Key: "$ne", Value: ""}}}}))
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, places, 1)
+		assert.Equal(t, "Taipei 101", places[0].Name)
+		assert.Equal(t, bson.D{
+			{Key: "name", Value: bson.D{{Key: "$ne", Value: ""}}},
+			{Key: "location", Value: bson.D{{Key: "$near", Value: bson.D{
+				{Key: "$geometry", Value: point}, {Key: "$maxDistance", Value: 500.0},
+			}}}},
+		}, filter)
+	})
+
+	t.Run("Within", func(t *testing.T) {
+		// Arrange
+		var filter any
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnFind: func(
+				ctx context.Context, c string, f any, opts ...options.Lister[options.FindOptions],
+			) (*mongo.Cursor, error) {
+				filter = f
+				return mgo.NewOnFindMock()(ctx, c, f, opts...)
+			},
+		})
+		defer restore()
+		area := types.NewPolygon(square(121, 25, 1))
+
+		// Act
+		_, err := mgo.Within(ctx, &geoPlace{}, "location", area, 0)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{{Key: "location", Value: bson.D{
+			{Key: "$geoWithin", Value: bson.D{{Key: "$geometry", Value: area}}},
+		}}}, filter)
+	})
+
+	t.Run("Invalid geometry", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{})
+		defer restore()
+
+		// Act
+		_, nearErr := mgo.Near(ctx, &geoPlace{}, "location", types.NewLocationPoint(200, 0), 0)
+		_, withinErr := mgo.Within(ctx, &geoPlace{}, "location", types.NewPolygon(square(121, 25, 1)[:3]), 0)
+
+		// Assert
+		assert.ErrorIs(t, nearErr, mgo.ErrInvalidDocument)
+		assert.ErrorIs(t, withinErr, mgo.ErrInvalidDocument)
+	})
+
+	t.Run("Nil geometry", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{})
+		defer restore()
+		var polygon *types.Polygon
+
+		// Act
+		_, nearErr := mgo.Near(ctx, &geoPlace{}, "location", nil, 0)
+		_, withinErr := mgo.Within(ctx, &geoPlace{}, "location", polygon, 0)
+		_, nilAreaErr := mgo.Within(ctx, &geoPlace{}, "location", nil, 0)
+		_, geoNearErr := mgo.GeoNear(ctx, &geoPlace{}, "location", nil, 0)
+
+		// Assert
+		assert.ErrorIs(t, nearErr, mgo.ErrInvalidDocument)
+		assert.ErrorIs(t, withinErr, mgo.ErrInvalidDocument)
+		assert.ErrorIs(t, nilAreaErr, mgo.ErrInvalidDocument)
+		assert.ErrorIs(t, geoNearErr, mgo.ErrInvalidDocument)
+	})
+}
+
+func TestGeoNear(t *testing.T) {
+	// Arrange
+	var pipeline mongo.Pipeline
+	restore := mgo.SetDatastore(&mgo.MockDatastore{
+		OnPipeFind: func(_ context.Context, _ string, p mongo.Pipeline) (*mongo.Cursor, error) {
+			pipeline = p
+			return mongo.NewCursorFromDocuments([]any{
+				bson.D{{Key: "name", Value: "near"}, {Key: "_geo_distance", Value: 12.5}},
+				bson.D{{Key: "name", Value: "far"}, {Key: "_geo_distance", Value: 480.0}},
+			}, nil, nil)
+		},
+	})
+	defer restore()
+	point := types.NewLocationPoint(121.5, 25.0)
+
+	// Act
+	results, err := mgo.GeoNear(context.Background(), &geoPlace{}, "location", point, 5,
+		mgo.WithMaxDistance(500), mgo.WithGeoFilter(bson.D{{Key: "name", Value: bson.D{{Key: "$exists", Value: true}}}}))
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "near", results[0].Doc.Name)
+	assert.InDelta(t, 12.5, results[0].Distance, 1e-9)
+	assert.InDelta(t, 480.0, results[1].Distance, 1e-9)
+	assert.Equal(t, mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.D{
+			{Key: "near", Value: point},
+			{Key: "key", Value: "location"},
+			{Key: "distanceField", Value: "_geo_distance"},
+			{Key: "spherical", Value: true},
+			{Key: "query", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$exists", Value: true}}}}},
+			{Key: "maxDistance", Value: 500.0},
+		}}},
+		{{Key: "$limit", Value: int64(5)}},
+	}, pipeline)
+}
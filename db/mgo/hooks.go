@@ -0,0 +1,123 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Models can implement the following interfaces to run logic around their persistence.
+// A before hook that returns an error aborts the operation; an after hook runs once the
+// operation succeeded, so its error is returned although the write was made.
+// Hook errors wrap ErrHookFailed. ToStatus reports them with the status of another error of the
+// package they wrap, such as ErrInvalidDocument, or StatusMongoDBHookFailed otherwise.
+//
+// The hooks are called on the doc argument of the helpers, and AfterFind on every decoded
+// document. Bulk operations, imports, pipelines and change streams do not call them.
+
+// BeforeSaver is implemented by models that run logic before Save inserts them or
+// ReplaceOne writes them, e.g. to normalize their fields. It runs before validation.
+type BeforeSaver interface {
+	BeforeSave(ctx context.Context) error
+}
+
+// AfterSaver is implemented by models that run logic after Save inserted them or
+// ReplaceOne wrote them.
+type AfterSaver interface {
+	AfterSave(ctx context.Context) error
+}
+
+// BeforeUpdater is implemented by models that run logic before UpdateOne, UpdateById or
+// UpdateMany modify the documents of their collection.
+type BeforeUpdater interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdater is implemented by models that run logic after UpdateOne, UpdateById or
+// UpdateMany modified at least one document.
+type AfterUpdater interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+// BeforeDeleter is implemented by models that run logic before DeleteOne, DeleteById or
+// DeleteMany delete the documents of their collection, e.g. to forbid it.
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterDeleter is implemented by models that run logic after DeleteOne, DeleteById or
+// DeleteMany deleted at least one document.
+type AfterDeleter interface {
+	AfterDelete(ctx context.Context) error
+}
+
+// AfterFinder is implemented by models that run logic on the documents read by FindOne,
+// FindById, Find, FindIter, Paginate and PaginateCursor, e.g. to compute derived fields.
+type AfterFinder interface {
+	AfterFind(ctx context.Context) error
+}
+
+func beforeSave(ctx context.Context, doc any) error {
+	if h, ok := doc.(BeforeSaver); ok {
+		return hookError("BeforeSave", h.BeforeSave(ctx))
+	}
+	return nil
+}
+
+func afterSave(ctx context.Context, doc any) error {
+	if h, ok := doc.(AfterSaver); ok {
+		return hookError("AfterSave", h.AfterSave(ctx))
+	}
+	return nil
+}
+
+func beforeUpdate(ctx context.Context, doc any) error {
+	if h, ok := doc.(BeforeUpdater); ok {
+		return hookError("BeforeUpdate", h.BeforeUpdate(ctx))
+	}
+	return nil
+}
+
+func afterUpdate(ctx context.Context, doc any) error {
+	if h, ok := doc.(AfterUpdater); ok {
+		return hookError("AfterUpdate", h.AfterUpdate(ctx))
+	}
+	return nil
+}
+
+func beforeDelete(ctx context.Context, doc any) error {
+	if h, ok := doc.(BeforeDeleter); ok {
+		return hookError("BeforeDelete", h.BeforeDelete(ctx))
+	}
+	return nil
+}
+
+func afterDelete(ctx context.Context, doc any) error {
+	if h, ok := doc.(AfterDeleter); ok {
+		return hookError("AfterDelete", h.AfterDelete(ctx))
+	}
+	return nil
+}
+
+func afterFind(ctx context.Context, doc any) error {
+	if h, ok := doc.(AfterFinder); ok {
+		return hookError("AfterFind", h.AfterFind(ctx))
+	}
+	return nil
+}
+
+// afterFindAll calls AfterFind on each of docs, stopping at the first error.
+func afterFindAll[T any](ctx context.Context, docs []T) error {
+	for _, doc := range docs {
+		if err := afterFind(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hookError(hook string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s: %w", ErrHookFailed, hook, err)
+}
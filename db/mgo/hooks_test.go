@@ -0,0 +1,232 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"google.golang.org/grpc/codes"
+)
+
+// hookedUser records the hooks called on it and fails the hook named by failOn.
+// AfterFind fails on the documents named "broken".
+type hookedUser struct {
+	calls   *[]string
+	failOn  string
+	Name    string        `bson:"name"`
+	Display string        `bson:"-"`
+	ID      bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*hookedUser) C() string                   { return "hooked_users" }
+func (*hookedUser) Indexes() []mongo.IndexModel { return nil }
+func (*hookedUser) Validate() error             { return nil }
+func (u *hookedUser) GetId() any                { return u.ID }
+func (u *hookedUser) SetId(id any)              { u.ID, _ = id.(bson.ObjectID) }
+
+func (u *hookedUser) hook(name string) error {
+	if u.calls != nil {
+		*u.calls = append(*u.calls, name)
+	}
+	if u.failOn == name {
+		return fmt.Errorf("%s refused", name)
+	}
+	return nil
+}
+
+func (u *hookedUser) BeforeSave(context.Context) error {
+	u.Name = strings.TrimSpace(u.Name)
+	return u.hook("BeforeSave")
+}
+func (u *hookedUser) AfterSave(context.Context) error    { return u.hook("AfterSave") }
+func (u *hookedUser) BeforeUpdate(context.Context) error { return u.hook("BeforeUpdate") }
+func (u *hookedUser) AfterUpdate(context.Context) error  { return u.hook("AfterUpdate") }
+func (u *hookedUser) BeforeDelete(context.Context) error { return u.hook("BeforeDelete") }
+func (u *hookedUser) AfterDelete(context.Context) error  { return u.hook("AfterDelete") }
+
+func (u *hookedUser) AfterFind(context.Context) error {
+	if u.Name == "broken" {
+		return errors.New("AfterFind refused")
+	}
+	u.Display = strings.ToUpper(u.Name)
+	return u.hook("AfterFind")
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Helpers call the hooks of the model", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		var calls []string
+		user := &hookedUser{Name: " peter ", calls: &calls}
+
+		// Act
+		_, err := mgo.Save(ctx, user)
+		require.NoError(t, err)
+		_, err = mgo.UpdateById(ctx, user, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "alice"}}}})
+		require.NoError(t, err)
+		found := &hookedUser{ID: user.ID}
+		require.NoError(t, mgo.FindById(ctx, found))
+		all, err := mgo.Find(ctx, &hookedUser{}, bson.D{}, 0)
+		require.NoError(t, err)
+		_, err = mgo.DeleteById(ctx, user)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, []string{
+			"BeforeSave", "AfterSave", "BeforeUpdate", "AfterUpdate", "BeforeDelete", "AfterDelete",
+		}, calls)
+		assert.Equal(t, "ALICE", found.Display)
+		require.Len(t, all, 1)
+		assert.Equal(t, "ALICE", all[0].Display)
+		stored, err := mgo.Find(ctx, &hookedUser{}, bson.D{{Key: "name", Value: "peter"}}, 0)
+		require.NoError(t, err)
+		assert.Empty(t, stored, "the document was deleted")
+	})
+
+	t.Run("BeforeSave normalizes the document", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+
+		// Act
+		user, err := mgo.Save(ctx, &hookedUser{Name: " peter "})
+
+		// Assert
+		require.NoError(t, err)
+		found := &hookedUser{}
+		require.NoError(t, mgo.FindOne(ctx, found, bson.D{{Key: "name", Value: "peter"}}))
+		assert.Equal(t, user.ID, found.ID)
+	})
+
+	tests := []struct {
+		write func(user *hookedUser) error
+		name  string
+		// written is the number of documents left once the write was attempted.
+		written int64
+	}{
+		{
+			name: "BeforeSave",
+			write: func(user *hookedUser) error {
+				_, err := mgo.Save(ctx, user)
+				return err
+			},
+		},
+		{
+			name: "AfterSave",
+			write: func(user *hookedUser) error {
+				_, err := mgo.Save(ctx, user)
+				return err
+			},
+			written: 1,
+		},
+		{
+			name: "BeforeDelete",
+			write: func(user *hookedUser) error {
+				_, err := mgo.DeleteById(ctx, user)
+				return err
+			},
+			written: 1,
+		},
+		{
+			name: "AfterDelete",
+			write: func(user *hookedUser) error {
+				_, err := mgo.DeleteMany(ctx, user, bson.D{})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" errors are returned", func(t *testing.T) {
+			// Arrange
+			defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+			user := &hookedUser{Name: "peter"}
+			if !strings.HasSuffix(tt.name, "Save") {
+				_, err := mgo.Save(ctx, user)
+				require.NoError(t, err)
+			}
+			user.failOn = tt.name
+
+			// Act
+			err := tt.write(user)
+
+			// Assert
+			require.ErrorIs(t, err, mgo.ErrHookFailed)
+			assert.ErrorContains(t, err, tt.name+" refused")
+			assert.Equal(t, codes.FailedPrecondition, mgo.ToStatus(err).Code())
+			count, err := mgo.CountDocument(ctx, user.C(), bson.D{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.written, count)
+		})
+	}
+
+	t.Run("BeforeUpdate errors abort the update", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		user, err := mgo.Save(ctx, &hookedUser{Name: "peter"})
+		require.NoError(t, err)
+		user.failOn = "BeforeUpdate"
+
+		// Act
+		affected, err := mgo.UpdateMany(ctx, user, bson.D{}, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "x"}}}})
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrHookFailed)
+		assert.Zero(t, affected)
+		found := &hookedUser{ID: user.ID}
+		require.NoError(t, mgo.FindById(ctx, found))
+		assert.Equal(t, "peter", found.Name)
+	})
+
+	t.Run("AfterFind errors fail the reads", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		broken, err := mgo.Save(ctx, &hookedUser{Name: "broken"})
+		require.NoError(t, err)
+
+		// Act
+		var iterErrs []error
+		for _, err := range mgo.FindIter(ctx, &hookedUser{}, bson.D{}) {
+			iterErrs = append(iterErrs, err)
+		}
+		_, findErr := mgo.Find(ctx, &hookedUser{}, bson.D{}, 0)
+		findByIdErr := mgo.FindById(ctx, &hookedUser{ID: broken.ID})
+		_, pageErr := mgo.Paginate(ctx, &hookedUser{}, bson.D{}, 1, 10)
+
+		// Assert
+		require.Len(t, iterErrs, 1)
+		for _, err := range append(iterErrs, findErr, findByIdErr, pageErr) {
+			assert.ErrorIs(t, err, mgo.ErrHookFailed)
+		}
+	})
+
+	t.Run("Hook errors keep the status of the errors they wrap", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		user := &invalidOnSave{}
+
+		// Act
+		_, err := mgo.Save(ctx, user)
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrHookFailed)
+		assert.Equal(t, codes.InvalidArgument, mgo.ToStatus(err).Code())
+	})
+}
+
+// invalidOnSave rejects every save as an invalid document.
+type invalidOnSave struct {
+	hookedUser `bson:",inline"`
+}
+
+func (*invalidOnSave) BeforeSave(context.Context) error {
+	return fmt.Errorf("%w: %w", mgo.ErrInvalidDocument, errors.New("name is required"))
+}
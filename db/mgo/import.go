@@ -1,38 +1,573 @@
 package mgo
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	defaultImportBatchSize = 1000
+	// maxImportLineSize bounds an NDJSON line; the Extended JSON of a document of the 16 MiB
+	// BSON limit can be several times larger.
+	maxImportLineSize = 64 << 20
+)
+
+// DataFormat is the serialization used by Import and Export.
+type DataFormat string
+
+const (
+	// FormatNDJSON is one Extended JSON document per line.
+	FormatNDJSON DataFormat = "ndjson"
+	// FormatJSONArray is a single JSON array of Extended JSON documents.
+	FormatJSONArray DataFormat = "json"
+	// FormatCSV is comma-separated values with a header row naming the fields.
+	FormatCSV DataFormat = "csv"
+)
+
+// Import inserts the documents of an Extended JSON array or NDJSON stream into the collection.
+// It is a shorthand for ImportStream that returns the row errors joined into one error.
 func Import(
 	ctx context.Context, collectionName string, reader io.Reader,
 ) error {
-	if dataStore == nil {
+	return ImportOn(ctx, defaultDB, collectionName, reader)
+}
+
+// ImportOn is like Import but inserts into db.
+func ImportOn(
+	ctx context.Context, db *DB, collectionName string, reader io.Reader,
+) error {
+	store := db.datastore()
+	if store == nil {
 		return ErrNotConnected
 	}
-	return dataStore.Import(ctx, collectionName, reader)
+	return store.Import(ctx, collectionName, reader)
 }
 
-func (m *mongoStore) Import(
-	ctx context.Context, collectionName string, reader io.Reader,
-) error {
-	collection := m.getCollection(collectionName)
-	data, err := io.ReadAll(reader)
+// CSVType is the type a CSV column is imported as, see WithCSVColumnTypes.
+type CSVType string
+
+const (
+	// CSVString imports the cells as strings, which is the default.
+	CSVString CSVType = "string"
+	// CSVInt imports the cells as 64-bit integers.
+	CSVInt CSVType = "int"
+	// CSVFloat imports the cells as doubles.
+	CSVFloat CSVType = "float"
+	// CSVBool imports the cells as booleans, accepting the values of strconv.ParseBool.
+	CSVBool CSVType = "bool"
+	// CSVInfer imports "true" and "false" as booleans and decimal numbers as integers or doubles,
+	// and the other cells as strings. Numbers with a leading zero, such as "007", stay strings.
+	CSVInfer CSVType = "infer"
+)
+
+type importConfig struct {
+	// prepare validates a row and returns the document to write.
+	prepare    func(raw bson.Raw) (bson.Raw, error)
+	csvTypes   map[string]CSVType
+	format     DataFormat
+	upsertKeys []string
+	batchSize  int
+}
+
+// ImportOption configures ImportStream and ImportModel.
+type ImportOption func(*importConfig)
+
+// WithImportFormat sets the input format. By default a JSON array or NDJSON is detected from
+// the first character; CSV must be requested explicitly.
+func WithImportFormat(format DataFormat) ImportOption {
+	return func(c *importConfig) {
+		c.format = format
+	}
+}
+
+// WithImportBatchSize sets the number of rows written per bulk request; the default is 1000.
+func WithImportBatchSize(size int) ImportOption {
+	return func(c *importConfig) {
+		if size > 0 {
+			c.batchSize = size
+		}
+	}
+}
+
+// WithUpsertKeys replaces the document whose keys equal those of each row, inserting it if none
+// matches, instead of inserting every row. Rows lacking one of the keys are reported as errors.
+func WithUpsertKeys(keys ...string) ImportOption {
+	return func(c *importConfig) {
+		c.upsertKeys = keys
+	}
+}
+
+// WithCSVColumnTypes sets the types of CSV columns by header name. The cells of the other
+// columns are imported as strings. Cells that do not parse as the type of their column are
+// reported as row errors.
+func WithCSVColumnTypes(types map[string]CSVType) ImportOption {
+	return func(c *importConfig) {
+		c.csvTypes = types
+	}
+}
+
+// ImportRowError reports a row that could not be imported.
+type ImportRowError struct {
+	Err error
+	// Row is the 1-based position of the row in the input, not counting the CSV header.
+	Row int
+}
+
+func (e *ImportRowError) Error() string { return fmt.Sprintf("row %d: %v", e.Row, e.Err) }
+func (e *ImportRowError) Unwrap() error { return e.Err }
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	// Errors lists the rows that were skipped or rejected by the server.
+	Errors []*ImportRowError
+	// Read is the number of rows read from the input.
+	Read int64
+	// Inserted counts new documents, including those created by upserts.
+	Inserted int64
+	// Replaced counts existing documents matched by an upsert.
+	Replaced int64
+}
+
+// Err joins the row errors, or returns nil if every row was imported.
+func (r *ImportReport) Err() error {
+	errs := make([]error, 0, len(r.Errors))
+	for _, e := range r.Errors {
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+// ImportStream reads documents from reader and writes them to the collection in unordered
+// batches, so memory use is bounded by the batch size and a bad row does not abort the import.
+// Rows that cannot be decoded or are rejected by the server are listed in the report.
+// The returned error is only set when the input cannot be read any further, in which case
+// the report covers the rows imported until then.
+//
+// CSV cells are imported as strings unless WithCSVColumnTypes sets the type of their column;
+// empty cells are omitted. NDJSON is read line by line, so a malformed line is reported as a
+// row error and the import goes on.
+func ImportStream(
+	ctx context.Context, collectionName string, reader io.Reader, opts ...ImportOption,
+) (*ImportReport, error) {
+	return ImportStreamOn(ctx, defaultDB, collectionName, reader, opts...)
+}
+
+// ImportStreamOn is like ImportStream but writes to db.
+func ImportStreamOn(
+	ctx context.Context, db *DB, collectionName string, reader io.Reader, opts ...ImportOption,
+) (*ImportReport, error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	return importStream(ctx, store, collectionName, reader, newImportConfig(opts))
+}
+
+// ImportModel is like ImportStream but writes to doc.C() and decodes every row into T first,
+// rejecting rows that fail to decode or whose Validate method returns an error. The encrypted
+// fields of T are encrypted, see SetKeyProvider.
+func ImportModel[T DocInter](
+	ctx context.Context, doc T, reader io.Reader, opts ...ImportOption,
+) (*ImportReport, error) {
+	return ImportModelOn(ctx, defaultDB, doc, reader, opts...)
+}
+
+// ImportModelOn is like ImportModel but writes to db.
+func ImportModelOn[T DocInter](
+	ctx context.Context, db *DB, doc T, reader io.Reader, opts ...ImportOption,
+) (*ImportReport, error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	cfg := newImportConfig(opts)
+	fields := encryptedFieldsOf(reflect.TypeFor[T]())
+	cfg.prepare = func(raw bson.Raw) (bson.Raw, error) {
+		t, err := decodeRaw[T](ctx, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+		}
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+		}
+		if fields == nil {
+			return raw, nil
+		}
+		return transformDocument(fields, raw, newFieldCipher(ctx).seal)
+	}
+	return importStream(ctx, store, doc.C(), reader, cfg)
+}
+
+func newImportConfig(opts []ImportOption) *importConfig {
+	cfg := &importConfig{batchSize: defaultImportBatchSize}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// importRow is a decoded row waiting to be written.
+type importRow struct {
+	doc bson.Raw
+	row int
+}
+
+func importStream(
+	ctx context.Context, store Datastore, collectionName string, reader io.Reader, cfg *importConfig,
+) (*ImportReport, error) {
+	_, span := store.startTraceSpan(ctx, collectionName, "import", nil)
+	defer span.End()
+
+	report := &ImportReport{}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("db.import.read", report.Read),
+			attribute.Int("db.import.errors", len(report.Errors)),
+		)
+	}()
+	rows, err := newRowReader(reader, cfg)
 	if err != nil {
-		return err
+		return report, spanErrorHandler(fmt.Errorf("%w: %w", ErrInvalidDocument, err), span)
+	}
+	batch := make([]importRow, 0, cfg.batchSize)
+	for {
+		row, doc, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if rowErr := (*ImportRowError)(nil); errors.As(err, &rowErr) {
+			report.Read++
+			report.Errors = append(report.Errors, rowErr)
+			continue
+		}
+		if err != nil {
+			return report, spanErrorHandler(fmt.Errorf("%w: %w", ErrInvalidDocument, err), span)
+		}
+		report.Read++
+		if cfg.prepare != nil {
+			if doc, err = cfg.prepare(doc); err != nil {
+				report.Errors = append(report.Errors, &ImportRowError{Row: row, Err: err})
+				continue
+			}
+		}
+		batch = append(batch, importRow{doc: doc, row: row})
+		if len(batch) == cfg.batchSize {
+			if err := writeImportBatch(ctx, span, store, collectionName, batch, cfg, report); err != nil {
+				return report, spanErrorHandler(err, span)
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := writeImportBatch(ctx, span, store, collectionName, batch, cfg, report); err != nil {
+			return report, spanErrorHandler(err, span)
+		}
 	}
-	var rawData []bson.M
-	if err := bson.UnmarshalExtJSON(data, false, &rawData); err != nil {
-		return fmt.Errorf("BSON UnmarshalExtJSON failed: %w", err)
+	return report, spanErrorHandler(nil, span)
+}
+
+// writeImportBatch writes batch with one unordered bulk request and records rejected rows in report.
+func writeImportBatch(
+	ctx context.Context, span trace.Span, store Datastore, collectionName string, batch []importRow,
+	cfg *importConfig, report *ImportReport,
+) error {
+	models := make([]mongo.WriteModel, 0, len(batch))
+	rows := make([]int, 0, len(batch))
+	for _, r := range batch {
+		if len(cfg.upsertKeys) == 0 {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(r.doc))
+			rows = append(rows, r.row)
+			continue
+		}
+		filter := make(bson.D, 0, len(cfg.upsertKeys))
+		var missing string
+		for _, key := range cfg.upsertKeys {
+			value, err := r.doc.LookupErr(key)
+			if err != nil {
+				missing = key
+				break
+			}
+			filter = append(filter, bson.E{Key: key, Value: value})
+		}
+		if missing != "" {
+			report.Errors = append(report.Errors, &ImportRowError{
+				Row: r.row, Err: fmt.Errorf("%w: missing upsert key %q", ErrInvalidDocument, missing),
+			})
+			continue
+		}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(r.doc).SetUpsert(true))
+		rows = append(rows, r.row)
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	result, err := retryValue(ctx, span, false, func() (*mongo.BulkWriteResult, error) {
+		return store.BulkWrite(ctx, collectionName, models, options.BulkWrite().SetOrdered(false))
+	})
+	if result != nil {
+		report.Inserted += result.InsertedCount + result.UpsertedCount
+		report.Replaced += result.MatchedCount
 	}
-	if len(rawData) == 0 {
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, we := range bulkErr.WriteErrors {
+			report.Errors = append(report.Errors, &ImportRowError{
+				Row: rows[we.Index], Err: fmt.Errorf("%w: %s", ErrWriteFailed, we.Message),
+			})
+		}
 		return nil
 	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	return nil
+}
+
+// rowReader yields the rows of an import input. next returns io.EOF after the last row,
+// an *ImportRowError for a row that cannot be decoded, and any other error when the
+// input cannot be read any further.
+type rowReader interface {
+	next() (int, bson.Raw, error)
+}
 
-	_, err = collection.InsertMany(ctx, rawData)
-	return err
+func newRowReader(r io.Reader, cfg *importConfig) (rowReader, error) {
+	br := bufio.NewReader(r)
+	format := cfg.format
+	if format == "" {
+		first, err := peekNonSpace(br)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		format = FormatNDJSON
+		if first == '[' {
+			format = FormatJSONArray
+		}
+	}
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(nil, maxImportLineSize)
+		return &ndjsonRowReader{scanner: scanner}, nil
+	case FormatJSONArray:
+		dec := json.NewDecoder(br)
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return &jsonRowReader{dec: dec}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return nil, fmt.Errorf("expected a JSON array, got %v", tok)
+		}
+		return &jsonRowReader{dec: dec}, nil
+	case FormatCSV:
+		return newCSVRowReader(br, cfg.csvTypes)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// jsonRowReader reads the elements of a JSON array. A syntax error ends the import, since
+// the next element cannot be found.
+type jsonRowReader struct {
+	dec *json.Decoder
+	row int
+}
+
+func (r *jsonRowReader) next() (int, bson.Raw, error) {
+	if !r.dec.More() {
+		return r.row, nil, io.EOF
+	}
+	var msg json.RawMessage
+	if err := r.dec.Decode(&msg); err != nil {
+		return r.row, nil, err
+	}
+	r.row++
+	raw, err := decodeExtJSONRow(msg)
+	if err != nil {
+		return r.row, nil, &ImportRowError{Row: r.row, Err: err}
+	}
+	return r.row, raw, nil
+}
+
+// ndjsonRowReader reads one document per line, skipping blank lines.
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func (r *ndjsonRowReader) next() (int, bson.Raw, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.row++
+		raw, err := decodeExtJSONRow(line)
+		if err != nil {
+			return r.row, nil, &ImportRowError{Row: r.row, Err: err}
+		}
+		return r.row, raw, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return r.row, nil, err
+	}
+	return r.row, nil, io.EOF
+}
+
+func decodeExtJSONRow(data []byte) (bson.Raw, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	return raw, nil
+}
+
+type csvRowReader struct {
+	reader *csv.Reader
+	types  map[string]CSVType
+	header []string
+	row    int
+}
+
+func newCSVRowReader(r io.Reader, types map[string]CSVType) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return &csvRowReader{reader: reader}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csvRowReader{reader: reader, types: types, header: append([]string(nil), header...)}, nil
+}
+
+func (r *csvRowReader) next() (int, bson.Raw, error) {
+	if r.header == nil {
+		return r.row, nil, io.EOF
+	}
+	record, err := r.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return r.row, nil, io.EOF
+	}
+	r.row++
+	if parseErr := (*csv.ParseError)(nil); errors.As(err, &parseErr) {
+		return r.row, nil, &ImportRowError{Row: r.row, Err: fmt.Errorf("%w: %w", ErrInvalidDocument, err)}
+	}
+	if err != nil {
+		return r.row, nil, err
+	}
+	doc := make(bson.D, 0, len(record))
+	for i, cell := range record {
+		if cell == "" {
+			continue
+		}
+		value, err := parseCSVCell(cell, r.types[r.header[i]])
+		if err != nil {
+			return r.row, nil, &ImportRowError{
+				Row: r.row, Err: fmt.Errorf("%w: column %q: %w", ErrInvalidDocument, r.header[i], err),
+			}
+		}
+		doc = append(doc, bson.E{Key: r.header[i], Value: value})
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return r.row, nil, &ImportRowError{Row: r.row, Err: fmt.Errorf("%w: %w", ErrInvalidDocument, err)}
+	}
+	return r.row, raw, nil
+}
+
+func parseCSVCell(cell string, typ CSVType) (any, error) {
+	switch typ {
+	case "", CSVString:
+		return cell, nil
+	case CSVInt:
+		return strconv.ParseInt(cell, 10, 64)
+	case CSVFloat:
+		return strconv.ParseFloat(cell, 64)
+	case CSVBool:
+		return strconv.ParseBool(cell)
+	case CSVInfer:
+		return inferCSVCell(cell), nil
+	default:
+		return nil, fmt.Errorf("unsupported CSV type %q", typ)
+	}
+}
+
+// inferCSVCell returns cell as a boolean or a number if it is written as one, and as a
+// string otherwise. Codes such as "007" or "+886" keep their text.
+func inferCSVCell(cell string) any {
+	switch cell {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if !isDecimal(cell) {
+		return cell
+	}
+	if n, err := strconv.ParseInt(cell, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(cell, 64); err == nil {
+		return f
+	}
+	return cell
+}
+
+// isDecimal reports whether s is written as a decimal number without a leading zero,
+// leaving out the special values and hexadecimal forms accepted by strconv.ParseFloat.
+func isDecimal(s string) bool {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return false
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return false
+	}
+	return strings.Trim(digits, "0123456789.eE+-") == ""
+}
+
+func (m *mongoStore) Import(
+	ctx context.Context, collectionName string, reader io.Reader,
+) error {
+	report, err := importStream(ctx, m, collectionName, reader, newImportConfig(nil))
+	if err != nil {
+		return err
+	}
+	return report.Err()
 }
@@ -0,0 +1,297 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// importUser is a model whose Validate rejects rows without a name.
+type importUser struct {
+	Name string        `bson:"name"`
+	Age  int           `bson:"age"`
+	ID   bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*importUser) C() string                   { return "import_users" }
+func (*importUser) Indexes() []mongo.IndexModel { return nil }
+func (u *importUser) GetId() any                { return u.ID }
+func (u *importUser) SetId(id any)              { u.ID, _ = id.(bson.ObjectID) }
+func (u *importUser) Validate() error {
+	if u.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// recordBulkWrites returns a datastore that records the documents of every bulk write.
+func recordBulkWrites(batches *[][]mongo.WriteModel) *mgo.MockDatastore {
+	return &mgo.MockDatastore{
+		OnBulkWrite: func(
+			_ context.Context, _ string, models []mongo.WriteModel, _ ...options.Lister[options.BulkWriteOptions],
+		) (*mongo.BulkWriteResult, error) {
+			*batches = append(*batches, models)
+			return &mongo.BulkWriteResult{InsertedCount: int64(len(models))}, nil
+		},
+	}
+}
+
+func insertedDoc(t *testing.T, model mongo.WriteModel) bson.M {
+	t.Helper()
+	insert, ok := model.(*mongo.InsertOneModel)
+	require.True(t, ok)
+	var doc bson.M
+	require.NoError(t, bson.Unmarshal(insert.Document.(bson.Raw), &doc))
+	return doc
+}
+
+func TestImportStream(t *testing.T) {
+	t.Run("NDJSON in batches", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		input := `{"name":"a"}` + "\n" + `{"name":"b"}` + "\n\n" + `{"name":"c"}` + "\n"
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input),
+			mgo.WithImportBatchSize(2))
+
+		// Assert
+		require.NoError(t, err)
+		require.NoError(t, report.Err())
+		assert.Equal(t, int64(3), report.Read)
+		assert.Equal(t, int64(3), report.Inserted)
+		require.Len(t, batches, 2)
+		assert.Len(t, batches[0], 2)
+		assert.Equal(t, "c", insertedDoc(t, batches[1][0])["name"])
+	})
+
+	t.Run("JSON array is detected", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		input := ` [{"_id":{"$oid":"60f6a9b4a4b7c2a3e4f5a6b7"},"name":"a"},{"name":"b"}]`
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), report.Read)
+		require.Len(t, batches, 1)
+		id, _ := bson.ObjectIDFromHex("60f6a9b4a4b7c2a3e4f5a6b7")
+		assert.Equal(t, id, insertedDoc(t, batches[0][0])["_id"])
+	})
+
+	t.Run("CSV columns have the types they are given", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		input := "name,age,score,active,zip\nPeter,30,1.5,true,007\nAmy,,,,\nBob,x,,,\n"
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input),
+			mgo.WithImportFormat(mgo.FormatCSV), mgo.WithCSVColumnTypes(map[string]mgo.CSVType{
+				"age": mgo.CSVInt, "score": mgo.CSVFloat, "active": mgo.CSVBool,
+			}))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), report.Read)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 3, report.Errors[0].Row)
+		assert.ErrorIs(t, report.Errors[0], mgo.ErrInvalidDocument)
+		assert.ErrorContains(t, report.Errors[0], `column "age"`)
+		require.Len(t, batches, 1)
+		assert.Equal(t, bson.M{"name": "Peter", "age": int64(30), "score": 1.5, "active": true, "zip": "007"},
+			insertedDoc(t, batches[0][0]))
+		assert.Equal(t, bson.M{"name": "Amy"}, insertedDoc(t, batches[0][1]))
+	})
+
+	t.Run("CSV type inference", func(t *testing.T) {
+		tests := []struct {
+			want any
+			cell string
+		}{
+			{cell: "true", want: true},
+			{cell: "false", want: false},
+			{cell: "TRUE", want: "TRUE"},
+			{cell: "t", want: "t"},
+			{cell: "1", want: int64(1)},
+			{cell: "0", want: int64(0)},
+			{cell: "-42", want: int64(-42)},
+			{cell: "0.5", want: 0.5},
+			{cell: "1e3", want: 1000.0},
+			{cell: "007", want: "007"},
+			{cell: "-01", want: "-01"},
+			{cell: "+886912345678", want: "+886912345678"},
+			{cell: "NaN", want: "NaN"},
+			{cell: "Inf", want: "Inf"},
+			{cell: "0x1p4", want: "0x1p4"},
+			{cell: "1.2.3", want: "1.2.3"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.cell, func(t *testing.T) {
+				// Arrange
+				var batches [][]mongo.WriteModel
+				restore := mgo.SetDatastore(recordBulkWrites(&batches))
+				defer restore()
+
+				// Act
+				report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader("value\n"+tt.cell+"\n"),
+					mgo.WithImportFormat(mgo.FormatCSV), mgo.WithCSVColumnTypes(map[string]mgo.CSVType{"value": mgo.CSVInfer}))
+
+				// Assert
+				require.NoError(t, err)
+				require.NoError(t, report.Err())
+				require.Len(t, batches, 1)
+				assert.Equal(t, bson.M{"value": tt.want}, insertedDoc(t, batches[0][0]))
+			})
+		}
+	})
+
+	t.Run("CSV cells are strings by default", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+
+		// Act
+		_, err := mgo.ImportStream(context.Background(), "users", strings.NewReader("age,active\n30,true\n"),
+			mgo.WithImportFormat(mgo.FormatCSV))
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, batches, 1)
+		assert.Equal(t, bson.M{"age": "30", "active": "true"}, insertedDoc(t, batches[0][0]))
+	})
+
+	t.Run("Upsert by key", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		input := `{"email":"a@b.c","name":"a"}` + "\n" + `{"name":"b"}`
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input),
+			mgo.WithUpsertKeys("email"))
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 2, report.Errors[0].Row)
+		require.Len(t, batches, 1)
+		replace, ok := batches[0][0].(*mongo.ReplaceOneModel)
+		require.True(t, ok)
+		assert.True(t, *replace.Upsert)
+		assert.Equal(t, "a@b.c", replace.Filter.(bson.D)[0].Value.(bson.RawValue).StringValue())
+	})
+
+	t.Run("Server errors are mapped to rows", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnBulkWrite: func(
+				context.Context, string, []mongo.WriteModel, ...options.Lister[options.BulkWriteOptions],
+			) (*mongo.BulkWriteResult, error) {
+				return &mongo.BulkWriteResult{InsertedCount: 1}, mongo.BulkWriteException{
+					WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Message: "dup key"}}},
+				}
+			},
+		})
+		defer restore()
+		input := `{"name":"a"}` + "\n" + `{"name":"b"}`
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), report.Inserted)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 2, report.Errors[0].Row)
+		assert.ErrorIs(t, report.Err(), mgo.ErrWriteFailed)
+	})
+
+	t.Run("Malformed NDJSON lines are skipped", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+		input := `{"name":"a"}` + "\n" + `{"name":` + "\n" + `{"name":"c"}` + "\n" + `not json`
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users", strings.NewReader(input))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), report.Read)
+		assert.Equal(t, int64(2), report.Inserted)
+		require.Len(t, report.Errors, 2)
+		assert.Equal(t, 2, report.Errors[0].Row)
+		assert.Equal(t, 4, report.Errors[1].Row)
+		assert.ErrorIs(t, report.Err(), mgo.ErrInvalidDocument)
+		require.Len(t, batches, 1)
+		assert.Equal(t, "c", insertedDoc(t, batches[0][1])["name"])
+	})
+
+	t.Run("Malformed JSON array stops the import", func(t *testing.T) {
+		// Arrange
+		var batches [][]mongo.WriteModel
+		restore := mgo.SetDatastore(recordBulkWrites(&batches))
+		defer restore()
+
+		// Act
+		report, err := mgo.ImportStream(context.Background(), "users",
+			strings.NewReader(`[{"name":"a"},{"name":`), mgo.WithImportBatchSize(1))
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrInvalidDocument)
+		assert.Equal(t, int64(1), report.Read)
+		assert.Len(t, batches, 1)
+	})
+}
+
+func TestImportModel(t *testing.T) {
+	// Arrange
+	var collection string
+	var batches [][]mongo.WriteModel
+	restore := mgo.SetDatastore(&mgo.MockDatastore{
+		OnBulkWrite: func(
+			_ context.Context, c string, models []mongo.WriteModel, _ ...options.Lister[options.BulkWriteOptions],
+		) (*mongo.BulkWriteResult, error) {
+			collection = c
+			batches = append(batches, models)
+			return &mongo.BulkWriteResult{InsertedCount: int64(len(models))}, nil
+		},
+	})
+	defer restore()
+	input := `{"name":"a","age":1}` + "\n" + `{"age":2}` + "\n" + `{"name":"c","age":"x"}`
+
+	// Act
+	report, err := mgo.ImportModel(context.Background(), &importUser{}, strings.NewReader(input))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "import_users", collection)
+	assert.Equal(t, int64(3), report.Read)
+	assert.Equal(t, int64(1), report.Inserted)
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, 2, report.Errors[0].Row)
+	assert.Equal(t, 3, report.Errors[1].Row)
+	assert.ErrorIs(t, report.Errors[0], mgo.ErrInvalidDocument)
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0], 1)
+}
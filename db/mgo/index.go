@@ -25,8 +25,11 @@ var indexes = []Index{}
 // collectDef is a concrete implementation of the Index interface.
 // It is used internally to store registered index information.
 type collectDef struct {
+	// schemaModel is the struct the $jsonSchema validator is derived from; see WithJSONSchema.
+	schemaModel    any
 	collectionName string
 	indexes        []mongo.IndexModel
+	softDelete     bool
 }
 
 // C returns the collection name.
@@ -39,13 +42,43 @@ func (c *collectDef) Indexes() []mongo.IndexModel {
 	return c.indexes
 }
 
+// CollectDefOption configures a collection definition created by NewCollectDef.
+type CollectDefOption func(*collectDef)
+
+// WithSoftDelete marks the collection as soft-deleted: once registered with RegisterIndex,
+// CountDocument and Export ignore its documents whose deleted_at is set, like Find does for
+// SoftDeleter models.
+func WithSoftDelete() CollectDefOption {
+	return func(c *collectDef) {
+		c.softDelete = true
+	}
+}
+
 // NewCollectDef creates a new collection definition that satisfies the Index interface.
 // This is a helper function to simplify the creation of index definitions.
-func NewCollectDef(name string, f func() []mongo.IndexModel) Index {
-	return &collectDef{
+func NewCollectDef(name string, f func() []mongo.IndexModel, opts ...CollectDefOption) Index {
+	c := &collectDef{
 		collectionName: name,
 		indexes:        f(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// isSoftDeleteCollection reports whether the collection name is registered with WithSoftDelete
+// or as a SoftDeleter model.
+func isSoftDeleteCollection(name string) bool {
+	for _, index := range indexes {
+		if index.C() != name {
+			continue
+		}
+		if c, ok := index.(*collectDef); (ok && c.softDelete) || isSoftDeleter(index) {
+			return true
+		}
+	}
+	return false
 }
 
 // RegisterIndex adds a new Index definition to the global registry.
@@ -59,11 +92,33 @@ func RegisterIndex(index Index) {
 // The underlying MongoDB driver's CreateMany command is idempotent: it will only
 // create indexes that do not already exist and will not change existing ones.
 // This is a safe and effective way to keep code-defined schemas and the database in sync.
+//
+// With SetTenancy, the indexes are also created for each tenant listed by TenancyOptions.Tenants,
+// and only for them when a tenant is required.
 func SyncIndexes(ctx context.Context) error {
-	if dataStore == nil {
+	return SyncIndexesOn(ctx, defaultDB)
+}
+
+// SyncIndexesOn is like SyncIndexes but creates the registered indexes in db.
+func SyncIndexesOn(ctx context.Context, db *DB) error {
+	store := db.datastore()
+	if store == nil {
 		return ErrNotConnected
 	}
+	stores, err := tenantsToSync(ctx, store)
+	if err != nil {
+		return errors.Join(ErrCreateIndexFailed, err)
+	}
+	for _, store := range stores {
+		if err := syncIndexes(ctx, store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// syncIndexes creates the registered indexes in the collections of store.
+func syncIndexes(ctx context.Context, store Datastore) error {
 	// Iterate over all programmatically registered index definitions.
 	for _, index := range indexes {
 		// Skip if there are no indexes to create for this model.
@@ -72,7 +127,11 @@ func SyncIndexes(ctx context.Context) error {
 		}
 
 		// Get the index view for the collection.
-		indexView := dataStore.getCollection(index.C()).Indexes()
+		collection := store.getCollection(index.C())
+		if collection == nil {
+			return errors.Join(ErrCreateIndexFailed, errNoServer("SyncIndexes"))
+		}
+		indexView := collection.Indexes()
 
 		// Create the defined indexes. This command is idempotent.
 		_, err := indexView.CreateMany(ctx, index.Indexes())
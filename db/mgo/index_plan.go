@@ -0,0 +1,395 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	idIndexName = "_id_"
+	// namespaceNotFound is the server error code for a missing collection.
+	namespaceNotFound = 26
+)
+
+// IndexDriftKind classifies a difference between the registered and the existing indexes.
+type IndexDriftKind string
+
+const (
+	// IndexMissing is a registered index that does not exist in the database.
+	IndexMissing IndexDriftKind = "missing"
+	// IndexExtra is an index that exists in the database but is not registered.
+	IndexExtra IndexDriftKind = "extra"
+	// IndexConflict is an index whose name is registered but whose keys or options differ.
+	IndexConflict IndexDriftKind = "conflict"
+)
+
+// IndexDrift describes one index whose definition differs from the database.
+type IndexDrift struct {
+	// Model is the registered definition; it is empty for IndexExtra.
+	Model      mongo.IndexModel
+	Collection string
+	// Tenant is the tenant whose collection drifted, and empty for the shared one.
+	Tenant string
+	Name   string
+	Kind   IndexDriftKind
+	// Reason explains an IndexConflict, e.g. "unique: registered true, existing false".
+	Reason string
+}
+
+// IndexPlan lists the differences between the registered Index definitions and the database.
+type IndexPlan struct {
+	Drifts []IndexDrift
+}
+
+// HasDrift reports whether the database differs from the registered definitions.
+func (p *IndexPlan) HasDrift() bool {
+	return len(p.Drifts) > 0
+}
+
+// String formats the plan one drift per line, suitable for CI logs.
+func (p *IndexPlan) String() string {
+	if !p.HasDrift() {
+		return "indexes are in sync"
+	}
+	var b strings.Builder
+	for _, d := range p.Drifts {
+		fmt.Fprintf(&b, "%s %s.%s", d.Kind, d.Collection, d.Name)
+		if d.Tenant != "" {
+			fmt.Fprintf(&b, " [tenant %s]", d.Tenant)
+		}
+		if d.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", d.Reason)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// PlanIndexes compares the registered Index definitions with the indexes that exist in each
+// of their collections, without changing anything. Only collections with a registered
+// definition are inspected, and the _id index is never reported. With SetTenancy, the
+// collections of each known tenant are inspected too, as SyncIndexes does.
+func PlanIndexes(ctx context.Context) (*IndexPlan, error) {
+	return PlanIndexesOn(ctx, defaultDB)
+}
+
+// PlanIndexesOn is like PlanIndexes but inspects db.
+func PlanIndexesOn(ctx context.Context, db *DB) (*IndexPlan, error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	_, span := store.startTraceSpan(ctx, "", "planIndexes", nil)
+	defer span.End()
+
+	var collections []string
+	desired := make(map[string][]mongo.IndexModel)
+	for _, index := range indexes {
+		if _, ok := desired[index.C()]; !ok {
+			collections = append(collections, index.C())
+		}
+		desired[index.C()] = append(desired[index.C()], index.Indexes()...)
+	}
+	tenants, err := syncTenants(ctx)
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	plan := &IndexPlan{}
+	for _, tenant := range tenants {
+		routed, err := storeOfTenant(ctx, store, tenant)
+		if err != nil {
+			return nil, spanErrorHandler(err, span)
+		}
+		for _, collection := range collections {
+			existing, err := listIndexSpecs(ctx, routed, collection)
+			if err != nil {
+				return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+			}
+			drifts, err := diffIndexes(collection, desired[collection], existing)
+			if err != nil {
+				return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrCreateIndexFailed, err), span)
+			}
+			for i := range drifts {
+				drifts[i].Tenant = tenant
+			}
+			plan.Drifts = append(plan.Drifts, drifts...)
+		}
+	}
+	return plan, spanErrorHandler(nil, span)
+}
+
+func listIndexSpecs(ctx context.Context, store Datastore, collection string) ([]bson.Raw, error) {
+	c := store.getCollection(collection)
+	if c == nil {
+		return nil, errNoServer("PlanIndexes")
+	}
+	cursor, err := c.Indexes().List(ctx)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var specs []bson.Raw
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// diffIndexes compares the desired index models of collection with the specifications returned by listIndexes.
+func diffIndexes(collection string, desired []mongo.IndexModel, existing []bson.Raw) ([]IndexDrift, error) {
+	byName := make(map[string]bson.Raw, len(existing))
+	for _, spec := range existing {
+		name, _ := spec.Lookup("name").StringValueOK()
+		byName[name] = spec
+	}
+	var drifts []IndexDrift
+	wanted := make(map[string]bool, len(desired))
+	for _, model := range desired {
+		opts, err := indexOptions(model)
+		if err != nil {
+			return nil, err
+		}
+		name, err := indexName(model, opts)
+		if err != nil {
+			return nil, err
+		}
+		wanted[name] = true
+		spec, ok := byName[name]
+		if !ok {
+			drifts = append(drifts, IndexDrift{Model: model, Collection: collection, Name: name, Kind: IndexMissing})
+			continue
+		}
+		reason, err := compareIndex(model, opts, spec)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			drifts = append(drifts, IndexDrift{
+				Model: model, Collection: collection, Name: name, Kind: IndexConflict, Reason: reason,
+			})
+		}
+	}
+	for _, spec := range existing {
+		name, _ := spec.Lookup("name").StringValueOK()
+		if name == idIndexName || wanted[name] {
+			continue
+		}
+		drifts = append(drifts, IndexDrift{Collection: collection, Name: name, Kind: IndexExtra})
+	}
+	return drifts, nil
+}
+
+func indexOptions(model mongo.IndexModel) (*options.IndexOptions, error) {
+	opts := &options.IndexOptions{}
+	if model.Options == nil {
+		return opts, nil
+	}
+	for _, set := range model.Options.List() {
+		if err := set(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// indexName returns the explicit name of model or the name the server generates for its keys,
+// e.g. "email_1_created_at_-1".
+func indexName(model mongo.IndexModel, opts *options.IndexOptions) (string, error) {
+	if opts.Name != nil {
+		return *opts.Name, nil
+	}
+	keys, err := bson.Marshal(model.Keys)
+	if err != nil {
+		return "", err
+	}
+	elems, err := bson.Raw(keys).Elements()
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(elems))
+	for _, e := range elems {
+		parts = append(parts, e.Key()+"_"+indexKeyValue(e.Value()))
+	}
+	return strings.Join(parts, "_"), nil
+}
+
+// indexKeyValue formats the direction or type of an index key, so that 1, int64(1) and 1.0 compare equal.
+func indexKeyValue(v bson.RawValue) string {
+	if s, ok := v.StringValueOK(); ok {
+		return s
+	}
+	if n, ok := v.AsInt64OK(); ok {
+		return strconv.FormatInt(n, 10)
+	}
+	return v.String()
+}
+
+// compareIndex returns why the existing spec differs from model, or "" if they match.
+func compareIndex(model mongo.IndexModel, opts *options.IndexOptions, spec bson.Raw) (string, error) {
+	keys, err := bson.Marshal(model.Keys)
+	if err != nil {
+		return "", err
+	}
+	existingKeys, _ := spec.Lookup("key").DocumentOK()
+	// Text indexes are listed with internal _fts/_ftsx keys instead of the indexed fields.
+	if _, isText := existingKeys.Lookup("_fts").StringValueOK(); !isText {
+		if want, have := formatIndexKeys(keys), formatIndexKeys(existingKeys); want != have {
+			return fmt.Sprintf("keys: registered %s, existing %s", want, have), nil
+		}
+	}
+	var reasons []string
+	compareBool := func(field string, want *bool) {
+		have, _ := spec.Lookup(field).BooleanOK()
+		if w := want != nil && *want; w != have {
+			reasons = append(reasons, fmt.Sprintf("%s: registered %t, existing %t", field, w, have))
+		}
+	}
+	compareBool("unique", opts.Unique)
+	compareBool("sparse", opts.Sparse)
+	compareBool("hidden", opts.Hidden)
+
+	want := "none"
+	if opts.ExpireAfterSeconds != nil {
+		want = strconv.Itoa(int(*opts.ExpireAfterSeconds))
+	}
+	have := "none"
+	if ttl, ok := spec.Lookup("expireAfterSeconds").AsInt64OK(); ok {
+		have = strconv.FormatInt(ttl, 10)
+	}
+	if want != have {
+		reasons = append(reasons, fmt.Sprintf("expireAfterSeconds: registered %s, existing %s", want, have))
+	}
+
+	want, err = formatExtJSON(opts.PartialFilterExpression)
+	if err != nil {
+		return "", err
+	}
+	have = "none"
+	if partial, ok := spec.Lookup("partialFilterExpression").DocumentOK(); ok {
+		have = partial.String()
+	}
+	if want != have {
+		reasons = append(reasons, fmt.Sprintf("partialFilterExpression: registered %s, existing %s", want, have))
+	}
+	return strings.Join(reasons, "; "), nil
+}
+
+func formatIndexKeys(keys bson.Raw) string {
+	elems, _ := keys.Elements()
+	parts := make([]string, 0, len(elems))
+	for _, e := range elems {
+		parts = append(parts, e.Key()+":"+indexKeyValue(e.Value()))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatExtJSON formats v like bson.Raw.String does, or returns "none" for nil.
+func formatExtJSON(v any) (string, error) {
+	if v == nil {
+		return "none", nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return bson.Raw(raw).String(), nil
+}
+
+type applyIndexConfig struct {
+	dropExtra        bool
+	rebuildConflicts bool
+}
+
+// ApplyIndexOption configures ApplyIndexPlan.
+type ApplyIndexOption func(*applyIndexConfig)
+
+// WithDropExtraIndexes makes ApplyIndexPlan drop the indexes that are not registered.
+func WithDropExtraIndexes() ApplyIndexOption {
+	return func(c *applyIndexConfig) {
+		c.dropExtra = true
+	}
+}
+
+// WithRebuildConflictingIndexes makes ApplyIndexPlan drop conflicting indexes and create them
+// again from their registered definition. Rebuilding a large index can take a long time.
+func WithRebuildConflictingIndexes() ApplyIndexOption {
+	return func(c *applyIndexConfig) {
+		c.rebuildConflicts = true
+	}
+}
+
+// ApplyIndexPlan reconciles the database with plan, typically one returned by PlanIndexes.
+// Missing indexes are always created. Extra indexes are only dropped with WithDropExtraIndexes
+// and are logged otherwise. Conflicting indexes are only rebuilt with WithRebuildConflictingIndexes;
+// otherwise they are reported in the returned error. Every drift is attempted, and all
+// failures are joined into the returned error. Drifts of a tenant are applied to its collections.
+func ApplyIndexPlan(ctx context.Context, plan *IndexPlan, opts ...ApplyIndexOption) error {
+	return ApplyIndexPlanOn(ctx, defaultDB, plan, opts...)
+}
+
+// ApplyIndexPlanOn is like ApplyIndexPlan but changes the indexes of db.
+func ApplyIndexPlanOn(ctx context.Context, db *DB, plan *IndexPlan, opts ...ApplyIndexOption) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	cfg := &applyIndexConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	_, span := store.startTraceSpan(ctx, "", "applyIndexPlan", nil)
+	defer span.End()
+
+	var errs []error
+	for _, d := range plan.Drifts {
+		routed, err := storeOfTenant(ctx, store, d.Tenant)
+		if err != nil {
+			return spanErrorHandler(errors.Join(ErrCreateIndexFailed, err), span)
+		}
+		collection := routed.getCollection(d.Collection)
+		if collection == nil {
+			return spanErrorHandler(errors.Join(ErrCreateIndexFailed, errNoServer("ApplyIndexPlan")), span)
+		}
+		view := collection.Indexes()
+		switch d.Kind {
+		case IndexMissing:
+			_, err = view.CreateOne(ctx, d.Model)
+		case IndexExtra:
+			if !cfg.dropExtra {
+				log.Warn("unregistered index kept", log.String("collection", d.Collection), log.String("index", d.Name))
+				continue
+			}
+			err = view.DropOne(ctx, d.Name)
+		case IndexConflict:
+			if !cfg.rebuildConflicts {
+				err = fmt.Errorf("conflicting index kept: %s", d.Reason)
+				break
+			}
+			if err = view.DropOne(ctx, d.Name); err == nil {
+				_, err = view.CreateOne(ctx, d.Model)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s.%s: %w", d.Kind, d.Collection, d.Name, err))
+			continue
+		}
+		log.Info("index reconciled",
+			log.String("collection", d.Collection), log.String("index", d.Name), log.String("kind", string(d.Kind)))
+	}
+	if len(errs) > 0 {
+		return spanErrorHandler(fmt.Errorf("%w: %w", ErrCreateIndexFailed, errors.Join(errs...)), span)
+	}
+	return spanErrorHandler(nil, span)
+}
@@ -0,0 +1,79 @@
+package mgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func mustRaw(t *testing.T, doc bson.D) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestDiffIndexes(t *testing.T) {
+	desired := []mongo.IndexModel{
+		{Keys: bson.D{bson.E{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{bson.E{Key: "name", Value: 1}, bson.E{Key: "age", Value: -1}}},
+		{
+			Keys:    bson.D{bson.E{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("ttl").SetExpireAfterSeconds(60),
+		},
+		{Keys: bson.D{bson.E{Key: "bio", Value: "text"}}},
+	}
+	existing := []bson.Raw{
+		mustRaw(t, bson.D{
+			bson.E{Key: "v", Value: int32(2)}, bson.E{Key: "key", Value: bson.D{bson.E{Key: "_id", Value: int32(1)}}},
+			bson.E{Key: "name", Value: "_id_"},
+		}),
+		// Same name as the unique email index, but not unique.
+		mustRaw(t, bson.D{
+			bson.E{Key: "key", Value: bson.D{bson.E{Key: "email", Value: int32(1)}}}, bson.E{Key: "name", Value: "email_1"},
+		}),
+		// Keys stored as doubles still match.
+		mustRaw(t, bson.D{
+			bson.E{Key: "key", Value: bson.D{bson.E{Key: "name", Value: 1.0}, bson.E{Key: "age", Value: -1.0}}},
+			bson.E{Key: "name", Value: "name_1_age_-1"},
+		}),
+		mustRaw(t, bson.D{
+			bson.E{Key: "key", Value: bson.D{bson.E{Key: "_fts", Value: "text"}, bson.E{Key: "_ftsx", Value: int32(1)}}},
+			bson.E{Key: "name", Value: "bio_text"},
+		}),
+		mustRaw(t, bson.D{
+			bson.E{Key: "key", Value: bson.D{bson.E{Key: "legacy", Value: int32(1)}}}, bson.E{Key: "name", Value: "legacy_1"},
+		}),
+	}
+
+	drifts, err := diffIndexes("users", desired, existing)
+
+	require.NoError(t, err)
+	require.Len(t, drifts, 3)
+	assert.Equal(t, IndexConflict, drifts[0].Kind)
+	assert.Equal(t, "email_1", drifts[0].Name)
+	assert.Equal(t, "unique: registered true, existing false", drifts[0].Reason)
+	assert.Equal(t, IndexMissing, drifts[1].Kind)
+	assert.Equal(t, "ttl", drifts[1].Name)
+	assert.Equal(t, IndexExtra, drifts[2].Kind)
+	assert.Equal(t, "legacy_1", drifts[2].Name)
+
+	plan := &IndexPlan{Drifts: drifts}
+	assert.True(t, plan.HasDrift())
+	assert.Contains(t, plan.String(), "extra users.legacy_1\n")
+}
+
+func TestIndexName(t *testing.T) {
+	model := mongo.IndexModel{Keys: bson.D{bson.E{Key: "loc", Value: "2dsphere"}, bson.E{Key: "at", Value: int64(-1)}}}
+	opts, err := indexOptions(model)
+	require.NoError(t, err)
+
+	name, err := indexName(model, opts)
+
+	require.NoError(t, err)
+	assert.Equal(t, "loc_2dsphere_at_-1", name)
+}
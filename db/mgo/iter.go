@@ -0,0 +1,135 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FindIter streams the documents matching filter instead of loading them into a slice.
+// Unlike Find, no default limit is applied. The query is only sent when iteration starts,
+// and the cursor and its tracing span stay open until the loop ends or breaks.
+// A non-nil error is yielded at most once and ends the iteration.
+//
+// Example:
+//
+//	for user, err := range mgo.FindIter(ctx, &User{}, bson.D{}) {
+//		if err != nil {
+//			return err
+//		}
+//		process(user)
+//	}
+func FindIter[T DocInter](
+	ctx context.Context, doc T, filter any,
+	opts ...options.Lister[options.FindOptions],
+) iter.Seq2[T, error] {
+	return FindIterOn(ctx, defaultDB, doc, filter, opts...)
+}
+
+// FindIterOn is like FindIter but reads from db.
+func FindIterOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any,
+	opts ...options.Lister[options.FindOptions],
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		store := db.datastore()
+		if store == nil {
+			yield(zero, ErrNotConnected)
+			return
+		}
+		filter, err := sealFilter(ctx, doc, filter)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		filter = excludeDeleted(ctx, isSoftDeleter(doc), filter)
+		collectionName := doc.C()
+		_, span := store.startTraceSpan(ctx, collectionName, "findIter", filter)
+		defer span.End()
+		cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+			return store.Find(ctx, collectionName, filter, opts...)
+		})
+		if err != nil {
+			yield(zero, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span))
+			return
+		}
+		iterateCursor(ctx, cursor, span, afterFind, yield)
+	}
+}
+
+// PipeIter streams the results of an aggregation pipeline, decoding each document into T.
+// It behaves like FindIter: the pipeline runs when iteration starts and
+// the cursor and tracing span are released when the loop ends.
+func PipeIter[T any](
+	ctx context.Context, collectionName string, pipeline mongo.Pipeline,
+) iter.Seq2[T, error] {
+	return PipeIterOn[T](ctx, defaultDB, collectionName, pipeline)
+}
+
+// PipeIterOn is like PipeIter but runs the pipeline on db.
+func PipeIterOn[T any](
+	ctx context.Context, db *DB, collectionName string, pipeline mongo.Pipeline,
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		store := db.datastore()
+		if store == nil {
+			yield(zero, ErrNotConnected)
+			return
+		}
+		_, span := store.startTraceSpan(ctx, collectionName, "pipeIter", pipeline)
+		defer span.End()
+		cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+			return store.PipeFind(ctx, collectionName, pipeline)
+		})
+		if err != nil {
+			yield(zero, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span))
+			return
+		}
+		iterateCursor(ctx, cursor, span, nil, yield)
+	}
+}
+
+// iterateCursor yields the documents of cursor, calling hook, if any, on each of them.
+func iterateCursor[T any](
+	ctx context.Context, cursor *mongo.Cursor, span trace.Span,
+	hook func(context.Context, any) error, yield func(T, error) bool,
+) {
+	defer func() {
+		_ = cursor.Close(context.WithoutCancel(ctx))
+	}()
+	var zero T
+	var count int64
+	defer func() {
+		span.SetAttributes(attribute.Int64("db.returned_documents", count))
+	}()
+	for cursor.Next(ctx) {
+		t, err := decodeCursor[T](ctx, cursor)
+		if err != nil {
+			yield(zero, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span))
+			return
+		}
+		if hook != nil {
+			if err := hook(ctx, t); err != nil {
+				yield(zero, spanErrorHandler(err, span))
+				return
+			}
+		}
+		count++
+		if !yield(t, nil) {
+			_ = spanErrorHandler(nil, span)
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		yield(zero, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span))
+		return
+	}
+	_ = spanErrorHandler(nil, span)
+}
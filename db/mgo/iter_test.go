@@ -0,0 +1,106 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestFindIter(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		// Arrange
+		mockDB := &mgo.MockDatastore{
+			OnFind: mgo.NewOnFindMock(
+				testUser{ID: bson.NewObjectID(), Name: "Peter"},
+				testUser{ID: bson.NewObjectID(), Name: "Alice"},
+				testUser{ID: bson.NewObjectID(), Name: "Bob"},
+			),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		var names []string
+		for user, err := range mgo.FindIter(context.Background(), &testUser{}, bson.D{}) {
+			require.NoError(t, err)
+			names = append(names, user.Name)
+		}
+
+		// Assert
+		assert.Equal(t, []string{"Peter", "Alice", "Bob"}, names)
+	})
+
+	t.Run("Break early", func(t *testing.T) {
+		// Arrange
+		mockDB := &mgo.MockDatastore{
+			OnFind: mgo.NewOnFindMock(
+				testUser{Name: "Peter"},
+				testUser{Name: "Alice"},
+			),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		count := 0
+		for _, err := range mgo.FindIter(context.Background(), &testUser{}, nil) {
+			require.NoError(t, err)
+			count++
+			break
+		}
+
+		// Assert
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Error from Datastore", func(t *testing.T) {
+		// Arrange
+		expectedErr := errors.New("datastore find failed")
+		mockDB := &mgo.MockDatastore{
+			OnFind: mgo.NewErrOnFind(expectedErr),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		var errs []error
+		for user, err := range mgo.FindIter(context.Background(), &testUser{}, nil) {
+			assert.Nil(t, user)
+			errs = append(errs, err)
+		}
+
+		// Assert
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs[0], expectedErr)
+		assert.ErrorIs(t, errs[0], mgo.ErrReadFailed)
+	})
+}
+
+func TestPipeIter(t *testing.T) {
+	// Arrange
+	mockDB := &mgo.MockDatastore{
+		OnPipeFind: mgo.NewOnPipeFindMock(
+			bson.D{bson.E{Key: "name", Value: "Peter"}},
+			bson.D{bson.E{Key: "name", Value: "Alice"}},
+		),
+	}
+	restore := mgo.SetDatastore(mockDB)
+	defer restore()
+
+	// Act
+	var names []string
+	for row, err := range mgo.PipeIter[bson.M](context.Background(), testCollectionName, mongo.Pipeline{}) {
+		require.NoError(t, err)
+		names = append(names, row["name"].(string))
+	}
+
+	// Assert
+	assert.Equal(t, []string{"Peter", "Alice"}, names)
+}
@@ -0,0 +1,945 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const duplicateKeyCode = 11000
+
+// MemoryDatastore is a Datastore that keeps collections in process memory, so that service tests
+// run without a MongoDB server:
+//
+//	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+//	defer restore()
+//
+// It supports the common subset of the query language: equality, $eq, $ne, $in, $nin, $gt, $gte,
+// $lt, $lte, $exists, $not, $regex, $and, $or and $nor on dotted paths; the update operators
+// $set, $setOnInsert, $unset, $inc and $push; sort, skip, limit and top-level projections;
+// and the $match, $sort, $skip, $limit and $project pipeline stages. Other operators fail with
+// errors.ErrUnsupported. The unique indexes of the definitions registered with RegisterIndex are
+// enforced and violations are reported as duplicate key errors, like the server does.
+//
+// WithTransaction restores the previous state when fn fails, but does not isolate concurrent
+// writers. Watch, IsHealth and the index and collection management helpers are not supported
+// and fail with errors.ErrUnsupported.
+// With SetTenancy, each tenant gets its own collections whatever the strategy.
+type MemoryDatastore struct {
+	collections map[string][]bson.Raw
+	// tenants holds the stores of the tenants, see SetTenancy.
+	tenants map[string]*MemoryDatastore
+	// tenant is set on the stores of the tenants.
+	tenant string
+	mu     sync.RWMutex
+}
+
+// NewMemoryDatastore returns an empty MemoryDatastore.
+func NewMemoryDatastore() *MemoryDatastore {
+	return &MemoryDatastore{collections: make(map[string][]bson.Raw)}
+}
+
+// listOptions applies the setters of opts to a new options struct.
+func listOptions[T any](opts []options.Lister[T]) (*T, error) {
+	result := new(T)
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		for _, set := range opt.List() {
+			if err := set(result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// memoryDoc is a decoded document and its position in the collection.
+type memoryDoc struct {
+	doc   bson.D
+	index int
+}
+
+// match returns the documents of the collection matching filter in insertion order.
+// The caller must hold the lock.
+func (m *MemoryDatastore) match(collection string, filter any) ([]memoryDoc, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var matched []memoryDoc
+	for i, raw := range m.collections[collection] {
+		doc, err := toDocument(raw)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := matchDocument(doc, query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, memoryDoc{doc: doc, index: i})
+		}
+	}
+	return matched, nil
+}
+
+func (m *MemoryDatastore) query(
+	collection string, filter any, sort any, skip, limit *int64, projection any,
+) ([]any, error) {
+	m.mu.RLock()
+	matched, err := m.match(collection, filter)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]bson.D, len(matched))
+	for i, d := range matched {
+		docs[i] = d.doc
+	}
+	if err := sortDocuments(docs, sort); err != nil {
+		return nil, err
+	}
+	if skip != nil {
+		docs = docs[min(int(*skip), len(docs)):]
+	}
+	if limit != nil && *limit > 0 {
+		docs = docs[:min(int(*limit), len(docs))]
+	}
+	proj, err := toDocument(projection)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, len(docs))
+	for i, doc := range docs {
+		result[i] = projectDocument(doc, proj)
+	}
+	return result, nil
+}
+
+// sortDocuments sorts docs stably by a sort specification such as bson.D{{"age", -1}}.
+func sortDocuments(docs []bson.D, sort any) error {
+	if sort == nil {
+		return nil
+	}
+	spec, err := toDocument(sort)
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(docs, func(a, b bson.D) int {
+		for _, s := range spec {
+			path := strings.Split(s.Key, ".")
+			c := compareValues(firstValue(lookupPath(a, path)), firstValue(lookupPath(b, path)))
+			if f, _ := toFloat(s.Value); f < 0 {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return nil
+}
+
+func firstValue(values []any) any {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func (m *MemoryDatastore) Save(ctx context.Context, doc DocInter) (DocInter, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return doc, err
+	}
+	if v := reflect.ValueOf(doc); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, errors.New("document cannot be nil"))
+	}
+	if err := doc.Validate(); err != nil {
+		return doc, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, err := m.insert(doc.C(), doc)
+	if err != nil {
+		return doc, fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	doc.SetId(id)
+	return doc, nil
+}
+
+// insert adds document to the collection, generating an ObjectID when it has no _id.
+// The caller must hold the write lock.
+func (m *MemoryDatastore) insert(collection string, document any) (any, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	if !hasKey(doc, "_id") {
+		doc = append(doc, bson.E{Key: "_id", Value: bson.NewObjectID()})
+	}
+	doc = idFirst(doc)
+	id := doc[0].Value
+	if err := m.store(collection, doc, -1); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// idFirst moves the _id of doc to the front, where the server stores it.
+func idFirst(doc bson.D) bson.D {
+	i := slices.IndexFunc(doc, func(e bson.E) bool { return e.Key == "_id" })
+	if i <= 0 {
+		return doc
+	}
+	id := doc[i]
+	return append(bson.D{id}, slices.Delete(doc, i, i+1)...)
+}
+
+// store writes doc at position index of the collection, or appends it when index is -1,
+// after checking the unique indexes. The caller must hold the write lock.
+func (m *MemoryDatastore) store(collection string, doc bson.D, index int) error {
+	if err := m.checkUnique(collection, doc, index); err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		m.collections[collection] = append(m.collections[collection], raw)
+	} else {
+		m.collections[collection][index] = raw
+	}
+	return nil
+}
+
+// uniqueIndex is a unique index registered for a collection.
+type uniqueIndex struct {
+	partial bson.D
+	name    string
+	keys    []string
+	sparse  bool
+}
+
+func uniqueIndexes(collection string) ([]uniqueIndex, error) {
+	result := []uniqueIndex{{name: "_id_", keys: []string{"_id"}}}
+	for _, index := range indexes {
+		if index.C() != collection {
+			continue
+		}
+		for _, model := range index.Indexes() {
+			opts, err := indexOptions(model)
+			if err != nil {
+				return nil, err
+			}
+			if opts.Unique == nil || !*opts.Unique {
+				continue
+			}
+			name, err := indexName(model, opts)
+			if err != nil {
+				return nil, err
+			}
+			keys, err := toDocument(model.Keys)
+			if err != nil {
+				return nil, err
+			}
+			partial, err := toDocument(opts.PartialFilterExpression)
+			if err != nil {
+				return nil, err
+			}
+			u := uniqueIndex{name: name, partial: partial, sparse: opts.Sparse != nil && *opts.Sparse}
+			for _, k := range keys {
+				u.keys = append(u.keys, k.Key)
+			}
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// indexKey returns the key of doc in the index, or nil when the document is not indexed.
+func (u uniqueIndex) indexKey(doc bson.D) (bson.A, error) {
+	if len(u.partial) > 0 {
+		ok, err := matchDocument(doc, u.partial)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+	key := make(bson.A, len(u.keys))
+	present := false
+	for i, k := range u.keys {
+		values := lookupPath(doc, strings.Split(k, "."))
+		present = present || len(values) > 0
+		key[i] = firstValue(values)
+	}
+	if u.sparse && !present {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// checkUnique returns a duplicate key error when doc collides with another document of
+// the collection than the one at position self.
+func (m *MemoryDatastore) checkUnique(collection string, doc bson.D, self int) error {
+	unique, err := uniqueIndexes(collection)
+	if err != nil {
+		return err
+	}
+	for _, u := range unique {
+		key, err := u.indexKey(doc)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+		for i, raw := range m.collections[collection] {
+			if i == self {
+				continue
+			}
+			other, err := toDocument(raw)
+			if err != nil {
+				return err
+			}
+			otherKey, err := u.indexKey(other)
+			if err != nil {
+				return err
+			}
+			if otherKey != nil && compareValues(key, otherKey) == 0 {
+				dup := make(bson.D, len(u.keys))
+				for i, k := range u.keys {
+					dup[i] = bson.E{Key: k, Value: key[i]}
+				}
+				dupKey, _ := formatExtJSON(dup)
+				return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+					Code: duplicateKeyCode,
+					Message: fmt.Sprintf(
+						"E11000 duplicate key error collection: %s index: %s dup key: %s",
+						collection, u.name, dupKey,
+					),
+				}}}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatastore) CountDocument(ctx context.Context, collectionName string, filter any) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matched, err := m.match(collectionName, filter)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return int64(len(matched)), nil
+}
+
+func (m *MemoryDatastore) Find(
+	ctx context.Context, collection string, filter any,
+	opts ...options.Lister[options.FindOptions],
+) (*mongo.Cursor, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, err := listOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	docs, err := m.query(collection, filter, o.Sort, o.Skip, o.Limit, o.Projection)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (m *MemoryDatastore) FindOne(
+	ctx context.Context, collection string, filter any,
+	opts ...options.Lister[options.FindOneOptions],
+) *mongo.SingleResult {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	o, err := listOptions(opts)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	limit := int64(1)
+	docs, err := m.query(collection, filter, o.Sort, o.Skip, &limit, o.Projection)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+// updateResult counts the effect of an update or replacement.
+type updateResult struct {
+	upsertedID any
+	matched    int64
+	modified   int64
+}
+
+// update applies update, or the replacement when replace is set, to the first or all matching
+// documents, inserting a document when none matches and upsert is set.
+// The caller must hold the write lock.
+func (m *MemoryDatastore) update(
+	collection string, filter any, update any, many, upsert, replace bool,
+) (updateResult, error) {
+	var result updateResult
+	change, err := toDocument(update)
+	if err != nil {
+		return result, err
+	}
+	if !replace && (len(change) == 0 || !strings.HasPrefix(change[0].Key, "$")) {
+		return result, errors.New("update document must contain only atomic operators")
+	}
+	matched, err := m.match(collection, filter)
+	if err != nil {
+		return result, err
+	}
+	if len(matched) == 0 {
+		if !upsert {
+			return result, nil
+		}
+		doc, err := upsertDocument(filter, change, replace)
+		if err != nil {
+			return result, err
+		}
+		result.upsertedID, err = m.insert(collection, doc)
+		return result, err
+	}
+	if !many {
+		matched = matched[:1]
+	}
+	for _, d := range matched {
+		var next bson.D
+		if replace {
+			next, err = replacementDocument(d.doc, change)
+		} else {
+			next, err = applyUpdate(d.doc, change, false)
+		}
+		if err != nil {
+			return result, err
+		}
+		next = idFirst(next)
+		if next[0].Key != "_id" || compareValues(next[0].Value, d.doc[0].Value) != 0 {
+			return result, errors.New("the (immutable) field '_id' was found to have been altered")
+		}
+		result.matched++
+		if compareValues(next, d.doc) == 0 {
+			continue
+		}
+		if err := m.store(collection, next, d.index); err != nil {
+			return result, err
+		}
+		result.modified++
+	}
+	return result, nil
+}
+
+// upsertDocument builds the document inserted by an upsert from the equality conditions
+// of filter and the update or replacement.
+func upsertDocument(filter any, change bson.D, replace bool) (bson.D, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var id any
+	doc := bson.D{}
+	for _, e := range query {
+		if strings.HasPrefix(e.Key, "$") {
+			continue
+		}
+		if ops, ok := e.Value.(bson.D); ok && len(ops) > 0 && strings.HasPrefix(ops[0].Key, "$") {
+			continue
+		}
+		if e.Key == "_id" {
+			id = e.Value
+		}
+		if !replace {
+			if doc, err = setPath(doc, strings.Split(e.Key, "."), e.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if replace {
+		doc = copyDocument(change)
+	} else if doc, err = applyUpdate(doc, change, true); err != nil {
+		return nil, err
+	}
+	if id != nil && !hasKey(doc, "_id") {
+		doc = append(bson.D{bson.E{Key: "_id", Value: id}}, doc...)
+	}
+	return doc, nil
+}
+
+// replacementDocument returns replacement with the _id of the document it replaces.
+func replacementDocument(current, replacement bson.D) (bson.D, error) {
+	if hasKey(replacement, "_id") {
+		return replacement, nil
+	}
+	return append(bson.D{current[0]}, replacement...), nil
+}
+
+func (m *MemoryDatastore) UpdateOne(
+	ctx context.Context, collection string, filter bson.D, update bson.D,
+	opts ...options.Lister[options.UpdateOneOptions],
+) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	o, err := listOptions(opts)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, err := m.update(collection, filter, update, false, o.Upsert != nil && *o.Upsert, false)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	return result.modified, nil
+}
+
+func (m *MemoryDatastore) UpdateMany(
+	ctx context.Context, collection string, filter bson.D, update bson.D,
+) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, err := m.update(collection, filter, update, true, false, false)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	return result.modified, nil
+}
+
+func (m *MemoryDatastore) ReplaceOne(
+	ctx context.Context, collection string, filter any, replacement any,
+	opts ...options.Lister[options.ReplaceOptions],
+) (*mongo.UpdateResult, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, err := listOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, err := m.update(collection, filter, replacement, false, o.Upsert != nil && *o.Upsert, true)
+	if err != nil {
+		return nil, err
+	}
+	return result.toUpdateResult(), nil
+}
+
+func (r updateResult) toUpdateResult() *mongo.UpdateResult {
+	result := &mongo.UpdateResult{
+		MatchedCount:  r.matched,
+		ModifiedCount: r.modified,
+		UpsertedID:    r.upsertedID,
+		Acknowledged:  true,
+	}
+	if r.upsertedID != nil {
+		result.UpsertedCount = 1
+	}
+	return result
+}
+
+// delete removes the first or all documents matching filter. The caller must hold the write lock.
+func (m *MemoryDatastore) delete(collection string, filter any, many bool) (int64, error) {
+	matched, err := m.match(collection, filter)
+	if err != nil {
+		return 0, err
+	}
+	if !many && len(matched) > 1 {
+		matched = matched[:1]
+	}
+	docs := m.collections[collection]
+	for i := len(matched) - 1; i >= 0; i-- {
+		docs = slices.Delete(docs, matched[i].index, matched[i].index+1)
+	}
+	m.collections[collection] = docs
+	return int64(len(matched)), nil
+}
+
+func (m *MemoryDatastore) DeleteOne(ctx context.Context, collection string, filter bson.D) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted, err := m.delete(collection, filter, false)
+	if err != nil {
+		return 0, errors.Join(ErrWriteFailed, err)
+	}
+	return deleted, nil
+}
+
+func (m *MemoryDatastore) DeleteMany(ctx context.Context, collection string, filter bson.D) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted, err := m.delete(collection, filter, true)
+	if err != nil {
+		return 0, errors.Join(ErrWriteFailed, err)
+	}
+	return deleted, nil
+}
+
+func (m *MemoryDatastore) BulkWrite(
+	ctx context.Context, collection string, models []mongo.WriteModel,
+	opts ...options.Lister[options.BulkWriteOptions],
+) (*mongo.BulkWriteResult, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, err := listOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	ordered := o.Ordered == nil || *o.Ordered
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := &mongo.BulkWriteResult{UpsertedIDs: map[int64]any{}, Acknowledged: true}
+	var writeErrors []mongo.BulkWriteError
+	for i, model := range models {
+		if err := m.bulkWriteOne(collection, model, int64(i), result); err != nil {
+			writeError := mongo.WriteError{Index: i, Message: err.Error()}
+			var we mongo.WriteException
+			if errors.As(err, &we) && len(we.WriteErrors) > 0 {
+				writeError.Code = we.WriteErrors[0].Code
+			}
+			writeErrors = append(writeErrors, mongo.BulkWriteError{WriteError: writeError, Request: model})
+			if ordered {
+				break
+			}
+		}
+	}
+	if len(writeErrors) > 0 {
+		return result, mongo.BulkWriteException{WriteErrors: writeErrors}
+	}
+	return result, nil
+}
+
+func (m *MemoryDatastore) bulkWriteOne(
+	collection string, model mongo.WriteModel, index int64, result *mongo.BulkWriteResult,
+) error {
+	var r updateResult
+	var err error
+	switch w := model.(type) {
+	case *mongo.InsertOneModel:
+		if _, err = m.insert(collection, w.Document); err == nil {
+			result.InsertedCount++
+		}
+		return err
+	case *mongo.UpdateOneModel:
+		r, err = m.update(collection, w.Filter, w.Update, false, w.Upsert != nil && *w.Upsert, false)
+	case *mongo.UpdateManyModel:
+		r, err = m.update(collection, w.Filter, w.Update, true, w.Upsert != nil && *w.Upsert, false)
+	case *mongo.ReplaceOneModel:
+		r, err = m.update(collection, w.Filter, w.Replacement, false, w.Upsert != nil && *w.Upsert, true)
+	case *mongo.DeleteOneModel:
+		deleted, err := m.delete(collection, w.Filter, false)
+		result.DeletedCount += deleted
+		return err
+	case *mongo.DeleteManyModel:
+		deleted, err := m.delete(collection, w.Filter, true)
+		result.DeletedCount += deleted
+		return err
+	default:
+		return fmt.Errorf("%w: write model %T", errors.ErrUnsupported, model)
+	}
+	result.MatchedCount += r.matched
+	result.ModifiedCount += r.modified
+	if r.upsertedID != nil {
+		result.UpsertedCount++
+		result.UpsertedIDs[index] = r.upsertedID
+	}
+	return err
+}
+
+func (m *MemoryDatastore) PipeFind(
+	ctx context.Context, collection string, pipeline mongo.Pipeline,
+) (*mongo.Cursor, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := m.aggregate(collection, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (m *MemoryDatastore) PipeFindOne(
+	ctx context.Context, collection string, pipeline mongo.Pipeline,
+) *mongo.SingleResult {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	docs, err := m.aggregate(collection, pipeline)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+// aggregate runs a pipeline made of $match, $sort, $skip, $limit, $project, $count and $facet stages.
+func (m *MemoryDatastore) aggregate(collection string, pipeline mongo.Pipeline) ([]any, error) {
+	docs, err := m.query(collection, nil, nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, errors.New("a pipeline stage must have exactly one field")
+		}
+		docs, err = applyStage(docs, stage[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func applyStage(docs []any, stage bson.E) ([]any, error) {
+	switch stage.Key {
+	case "$match":
+		filter, err := toDocument(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		var matched []any
+		for _, doc := range docs {
+			ok, err := matchDocument(doc.(bson.D), filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = append(matched, doc)
+			}
+		}
+		return matched, nil
+	case "$sort":
+		sorted := make([]bson.D, len(docs))
+		for i, doc := range docs {
+			sorted[i] = doc.(bson.D)
+		}
+		if err := sortDocuments(sorted, stage.Value); err != nil {
+			return nil, err
+		}
+		for i, doc := range sorted {
+			docs[i] = doc
+		}
+		return docs, nil
+	case "$skip", "$limit":
+		n, ok := toFloat(stage.Value)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("%s needs a non-negative number", stage.Key)
+		}
+		if stage.Key == "$skip" {
+			return docs[min(int(n), len(docs)):], nil
+		}
+		return docs[:min(int(n), len(docs))], nil
+	case "$project":
+		projection, err := toDocument(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range docs {
+			docs[i] = projectDocument(doc.(bson.D), projection)
+		}
+		return docs, nil
+	case "$count":
+		field, ok := stage.Value.(string)
+		if !ok || field == "" {
+			return nil, errors.New("$count needs a field name")
+		}
+		if len(docs) == 0 {
+			return nil, nil
+		}
+		return []any{bson.D{bson.E{Key: field, Value: int32(len(docs))}}}, nil
+	case "$facet":
+		return facetStage(docs, stage.Value)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedOperator, stage.Key)
+	}
+}
+
+// facetStage runs each sub-pipeline of a $facet stage on its own copy of docs.
+func facetStage(docs []any, value any) ([]any, error) {
+	facets, err := toDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make(bson.D, 0, len(facets))
+	for _, facet := range facets {
+		stages, ok := facet.Value.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("$facet %s needs an array of stages", facet.Key)
+		}
+		out := slices.Clone(docs)
+		for _, s := range stages {
+			stage, err := toDocument(s)
+			if err != nil {
+				return nil, err
+			}
+			if len(stage) != 1 {
+				return nil, errors.New("a pipeline stage must have exactly one field")
+			}
+			if out, err = applyStage(out, stage[0]); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, bson.E{Key: facet.Key, Value: bson.A(out)})
+	}
+	return []any{result}, nil
+}
+
+func (m *MemoryDatastore) Distinct(
+	ctx context.Context, collectionName string, field string, filter any,
+	_ ...options.Lister[options.DistinctOptions],
+) ([]bson.RawValue, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	matched, err := m.match(collectionName, filter)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	var values []any
+	for _, d := range matched {
+		for _, v := range expandArrays(lookupPath(d.doc, strings.Split(field, "."))) {
+			if _, isArray := v.(bson.A); isArray {
+				continue
+			}
+			if !slices.ContainsFunc(values, func(seen any) bool { return compareValues(seen, v) == 0 }) {
+				values = append(values, v)
+			}
+		}
+	}
+	result := make([]bson.RawValue, 0, len(values))
+	for _, v := range values {
+		t, data, err := bson.MarshalValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		result = append(result, bson.RawValue{Type: t, Value: data})
+	}
+	return result, nil
+}
+
+func (m *MemoryDatastore) Import(ctx context.Context, collectionName string, reader io.Reader) error {
+	report, err := importStream(ctx, m, collectionName, reader, newImportConfig(nil))
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// WithTransaction runs fn and restores the collections to their previous state when it fails.
+func (m *MemoryDatastore) WithTransaction(
+	ctx context.Context, fn func(txCtx context.Context) error,
+	_ ...options.Lister[options.TransactionOptions],
+) error {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	snapshot := make(map[string][]bson.Raw, len(m.collections))
+	for name, docs := range m.collections {
+		snapshot[name] = slices.Clone(docs)
+	}
+	m.mu.RUnlock()
+	if err := fn(ctx); err != nil {
+		m.mu.Lock()
+		m.collections = snapshot
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (*MemoryDatastore) Watch(
+	context.Context, string, mongo.Pipeline, ...options.Lister[options.ChangeStreamOptions],
+) (ChangeStream, error) {
+	return nil, fmt.Errorf("%w: change streams need a MongoDB server", errors.ErrUnsupported)
+}
+
+func (m *MemoryDatastore) NewBulkOperation(cname string) BulkOperator {
+	return newBulkOperation(m, cname)
+}
+
+func (*MemoryDatastore) getCollection(string) *mongo.Collection {
+	return nil
+}
+
+func (*MemoryDatastore) getDatabase() *mongo.Database {
+	return nil
+}
+
+func (*MemoryDatastore) getClient() *mongo.Client {
+	return nil
+}
+
+func (*MemoryDatastore) close(context.Context) error {
+	return nil
+}
+
+func (m *MemoryDatastore) cleanDb(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collections = make(map[string][]bson.Raw)
+	m.tenants = nil
+	return nil
+}
+
+func (*MemoryDatastore) startTraceSpan(
+	ctx context.Context, collectionName string, operation string, statement any,
+) (context.Context, trace.Span) {
+	span := meterSpan(trace.SpanFromContext(ctx), collectionName, operation)
+	return ctx, watchSlowQuery(span, collectionName, operation, statement, nil)
+}
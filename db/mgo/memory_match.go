@@ -0,0 +1,605 @@
+package mgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// errUnsupportedOperator is returned by the memory datastore for query and update
+// operators outside the supported subset.
+var errUnsupportedOperator = fmt.Errorf("%w: operator not supported by the memory datastore", errors.ErrUnsupported)
+
+// toDocument normalizes v, e.g. a bson.M, a struct or a bson.Raw, into a bson.D whose values
+// have the types the driver decodes them into, so that they can be compared.
+func toDocument(v any) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	var raw bson.Raw
+	switch d := v.(type) {
+	case bson.Raw:
+		raw = d
+	case []byte:
+		raw = d
+	default:
+		data, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// lookupPath returns the values reached by the dotted path in doc. Arrays of documents are
+// traversed, so "items.sku" reaches the sku of every item. A nil result means the field is missing.
+func lookupPath(value any, path []string) []any {
+	if len(path) == 0 {
+		return []any{value}
+	}
+	switch v := value.(type) {
+	case bson.D:
+		for _, e := range v {
+			if e.Key == path[0] {
+				return lookupPath(e.Value, path[1:])
+			}
+		}
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(v) {
+				return lookupPath(v[i], path[1:])
+			}
+			return nil
+		}
+		var values []any
+		for _, item := range v {
+			if _, ok := item.(bson.D); ok {
+				values = append(values, lookupPath(item, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// matchDocument reports whether doc matches the query filter.
+func matchDocument(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		var ok bool
+		var err error
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, e.Key, e.Value)
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, fmt.Errorf("%w: %s", errUnsupportedOperator, e.Key)
+			}
+			ok, err = matchField(lookupPath(doc, strings.Split(e.Key, ".")), e.Value)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.D, op string, value any) (bool, error) {
+	clauses, ok := value.(bson.A)
+	if !ok || len(clauses) == 0 {
+		return false, fmt.Errorf("%s must be a nonempty array", op)
+	}
+	for _, clause := range clauses {
+		sub, ok := clause.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("%s entries must be documents", op)
+		}
+		matched, err := matchDocument(doc, sub)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+// matchField reports whether the values of a field satisfy cond, either a value it must equal
+// or a document of operators such as {$gt: 1}.
+func matchField(values []any, cond any) (bool, error) {
+	ops, ok := cond.(bson.D)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		return matchEqual(values, cond), nil
+	}
+	for _, op := range ops {
+		ok, err := matchOperator(values, op.Key, op.Value, ops)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchEqual reports whether one of values, or an element of an array value, equals want.
+// A nil want also matches a missing field.
+func matchEqual(values []any, want any) bool {
+	if want == nil && len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if compareValues(v, want) == 0 {
+			return true
+		}
+		if arr, ok := v.(bson.A); ok {
+			for _, item := range arr {
+				if compareValues(item, want) == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchOperator(values []any, op string, arg any, ops bson.D) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEqual(values, arg), nil
+	case "$ne":
+		return !matchEqual(values, arg), nil
+	case "$in", "$nin":
+		list, ok := arg.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", op)
+		}
+		found := false
+		for _, want := range list {
+			if matchEqual(values, want) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in"), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchRange(values, op, arg), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(arg), nil
+	case "$not":
+		ok, err := matchField(values, arg)
+		return !ok, err
+	case "$regex":
+		return matchRegex(values, arg, ops)
+	case "$options":
+		// Consumed by $regex.
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: %s", errUnsupportedOperator, op)
+	}
+}
+
+func matchRange(values []any, op string, arg any) bool {
+	for _, v := range expandArrays(values) {
+		if typeRank(v) != typeRank(arg) {
+			continue
+		}
+		c := compareValues(v, arg)
+		switch {
+		case op == "$gt" && c > 0, op == "$gte" && c >= 0, op == "$lt" && c < 0, op == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegex(values []any, arg any, ops bson.D) (bool, error) {
+	var pattern, flags string
+	switch p := arg.(type) {
+	case string:
+		pattern = p
+	case bson.Regex:
+		pattern, flags = p.Pattern, p.Options
+	default:
+		return false, errors.New("$regex needs a string")
+	}
+	for _, op := range ops {
+		if op.Key == "$options" {
+			flags, _ = op.Value.(string)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + strings.ReplaceAll(flags, "x", "") + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range expandArrays(values) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// expandArrays returns values followed by the elements of the array values.
+func expandArrays(values []any) []any {
+	expanded := values
+	for _, v := range values {
+		if arr, ok := v.(bson.A); ok {
+			expanded = append(expanded[:len(expanded):len(expanded)], arr...)
+		}
+	}
+	return expanded
+}
+
+func truthy(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	default:
+		f, ok := toFloat(v)
+		return !ok || f != 0
+	}
+}
+
+// typeRank orders BSON types like the server does when sorting values of different types.
+func typeRank(v any) int {
+	switch v.(type) {
+	case bson.MinKey:
+		return 0
+	case nil, bson.Undefined, bson.Null:
+		return 1
+	case int32, int64, float64, bson.Decimal128:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case bson.Binary:
+		return 6
+	case bson.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case bson.DateTime:
+		return 9
+	case bson.Timestamp:
+		return 10
+	case bson.Regex:
+		return 11
+	case bson.MaxKey:
+		return 13
+	default:
+		return 12
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bson.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareValues orders two normalized values, comparing numbers of different types by value.
+func compareValues(a, b any) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return cmpOrdered(ra, rb)
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return cmpOrdered(x, y)
+		}
+	case int32:
+		if y, ok := b.(int32); ok {
+			return cmpOrdered(x, y)
+		}
+	case string:
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	case bson.ObjectID:
+		y, _ := b.(bson.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y, _ := b.(bool)
+		return cmpOrdered(boolRank(x), boolRank(y))
+	case bson.DateTime:
+		y, _ := b.(bson.DateTime)
+		return cmpOrdered(x, y)
+	case bson.D:
+		y, _ := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpOrdered(len(x), len(y))
+	case bson.A:
+		y, _ := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpOrdered(len(x), len(y))
+	case nil, bson.Undefined, bson.Null, bson.MinKey, bson.MaxKey:
+		return 0
+	}
+	if fa, ok := toFloat(a); ok {
+		fb, _ := toFloat(b)
+		return cmpOrdered(fa, fb)
+	}
+	// Remaining types are only compared for equality.
+	ra, errA := bson.Marshal(bson.D{bson.E{Key: "v", Value: a}})
+	rb, errB := bson.Marshal(bson.D{bson.E{Key: "v", Value: b}})
+	if errA != nil || errB != nil {
+		return 0
+	}
+	return bytes.Compare(ra, rb)
+}
+
+func cmpOrdered[T int | int32 | int64 | float64 | bson.DateTime](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// applyUpdate applies the update operators to a copy of doc. $setOnInsert is only applied
+// when inserting, i.e. for upserts.
+func applyUpdate(doc bson.D, update bson.D, inserting bool) (bson.D, error) {
+	result := copyDocument(doc)
+	for _, op := range update {
+		if op.Key == "$setOnInsert" && !inserting {
+			continue
+		}
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a document", op.Key)
+		}
+		for _, f := range fields {
+			path := strings.Split(f.Key, ".")
+			var err error
+			switch op.Key {
+			case "$set", "$setOnInsert":
+				result, err = setPath(result, path, f.Value)
+			case "$unset":
+				result = unsetPath(result, path)
+			case "$inc":
+				result, err = incPath(result, path, f.Value)
+			case "$push":
+				result, err = pushPath(result, path, f.Value)
+			default:
+				return nil, fmt.Errorf("%w: %s", errUnsupportedOperator, op.Key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", op.Key, f.Key, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// copyDocument deep-copies the nested documents and arrays of doc.
+func copyDocument(doc bson.D) bson.D {
+	result := make(bson.D, len(doc))
+	for i, e := range doc {
+		result[i] = bson.E{Key: e.Key, Value: copyValue(e.Value)}
+	}
+	return result
+}
+
+func copyValue(v any) any {
+	switch x := v.(type) {
+	case bson.D:
+		return copyDocument(x)
+	case bson.A:
+		arr := make(bson.A, len(x))
+		for i, item := range x {
+			arr[i] = copyValue(item)
+		}
+		return arr
+	default:
+		return v
+	}
+}
+
+// setPath sets the field at path, creating intermediate documents as needed.
+func setPath(doc bson.D, path []string, value any) (bson.D, error) {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+			return doc, nil
+		}
+		child, err := setValuePath(e.Value, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = child
+		return doc, nil
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value}), nil
+	}
+	child, err := setPath(bson.D{}, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return append(doc, bson.E{Key: path[0], Value: child}), nil
+}
+
+func setValuePath(parent any, path []string, value any) (any, error) {
+	switch p := parent.(type) {
+	case bson.D:
+		return setPath(p, path, value)
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(p) {
+			return nil, fmt.Errorf("cannot create field %q in an array", path[0])
+		}
+		if len(path) == 1 {
+			p[i] = value
+			return p, nil
+		}
+		child, err := setValuePath(p[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = child
+		return p, nil
+	case nil:
+		return setPath(bson.D{}, path, value)
+	default:
+		return nil, fmt.Errorf("cannot create field %q in a %T", path[0], parent)
+	}
+}
+
+// unsetPath removes the field at path; missing fields are ignored.
+func unsetPath(doc bson.D, path []string) bson.D {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return append(doc[:i], doc[i+1:]...)
+		}
+		if child, ok := e.Value.(bson.D); ok {
+			doc[i].Value = unsetPath(child, path[1:])
+		}
+		return doc
+	}
+	return doc
+}
+
+func incPath(doc bson.D, path []string, delta any) (bson.D, error) {
+	if _, ok := toFloat(delta); !ok {
+		return nil, errors.New("cannot increment by a non-numeric value")
+	}
+	var current any = int32(0)
+	if values := lookupPath(doc, path); len(values) > 0 {
+		current = values[0]
+	}
+	sum, err := addNumbers(current, delta)
+	if err != nil {
+		return nil, err
+	}
+	return setPath(doc, path, sum)
+}
+
+// addNumbers adds two numbers, widening the result like the server: int32 overflows into int64
+// and any float makes the result a float.
+func addNumbers(a, b any) (any, error) {
+	fa, okA := toFloat(a)
+	fb, _ := toFloat(b)
+	if !okA {
+		return nil, fmt.Errorf("cannot increment a %T", a)
+	}
+	_, floatA := a.(float64)
+	_, floatB := b.(float64)
+	if floatA || floatB {
+		return fa + fb, nil
+	}
+	ia, ib := int64(fa), int64(fb)
+	sum := ia + ib
+	_, longA := a.(int64)
+	_, longB := b.(int64)
+	if !longA && !longB && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum), nil
+	}
+	return sum, nil
+}
+
+func pushPath(doc bson.D, path []string, value any) (bson.D, error) {
+	items := bson.A{value}
+	if each, ok := value.(bson.D); ok && len(each) > 0 && each[0].Key == "$each" {
+		arr, ok := each[0].Value.(bson.A)
+		if !ok {
+			return nil, errors.New("$each needs an array")
+		}
+		items = arr
+	}
+	var current bson.A
+	if values := lookupPath(doc, path); len(values) > 0 {
+		arr, ok := values[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("cannot push to a %T", values[0])
+		}
+		current = arr
+	}
+	return setPath(doc, path, append(current, items...))
+}
+
+// projectDocument applies an inclusion or exclusion projection of top-level fields.
+func projectDocument(doc bson.D, projection bson.D) bson.D {
+	if len(projection) == 0 {
+		return doc
+	}
+	include := map[string]bool{}
+	inclusive := false
+	for _, p := range projection {
+		include[strings.Split(p.Key, ".")[0]] = truthy(p.Value)
+		if p.Key != "_id" && truthy(p.Value) {
+			inclusive = true
+		}
+	}
+	result := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		keep, listed := include[e.Key]
+		switch {
+		case e.Key == "_id" && !listed:
+			keep = true
+		case !listed:
+			keep = !inclusive
+		}
+		if keep {
+			result = append(result, e)
+		}
+	}
+	return result
+}
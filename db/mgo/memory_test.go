@@ -0,0 +1,279 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// memoryAccount is a model with a unique email index, registered in init.
+type memoryAccount struct {
+	Email string        `bson:"email"`
+	Tags  []string      `bson:"tags,omitempty"`
+	Age   int           `bson:"age"`
+	ID    bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*memoryAccount) C() string { return "memory_accounts" }
+func (*memoryAccount) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)}}
+}
+func (*memoryAccount) Validate() error { return nil }
+func (a *memoryAccount) GetId() any    { return a.ID }
+func (a *memoryAccount) SetId(id any)  { a.ID, _ = id.(bson.ObjectID) }
+
+func init() {
+	mgo.RegisterIndex(&memoryAccount{})
+}
+
+func seedAccounts(t *testing.T, ages ...int) {
+	t.Helper()
+	for i, age := range ages {
+		_, err := mgo.Save(context.Background(), &memoryAccount{Email: string(rune('a'+i)) + "@x.io", Age: age})
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryDatastoreFind(t *testing.T) {
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	seedAccounts(t, 30, 20, 40, 25)
+	ctx := context.Background()
+
+	tests := []struct {
+		filter bson.D
+		name   string
+		want   []int
+	}{
+		{name: "Equality", filter: bson.D{{Key: "age", Value: 20}}, want: []int{20}},
+		{name: "Range", filter: bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 20}, {Key: "$lt", Value: 40}}}},
+			want: []int{25, 30}},
+		{name: "In", filter: bson.D{{Key: "age", Value: bson.D{{Key: "$in", Value: bson.A{40, 25}}}}},
+			want: []int{25, 40}},
+		{name: "Or", filter: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "age", Value: 20}}, bson.D{{Key: "email", Value: "c@x.io"}},
+		}}}, want: []int{20, 40}},
+		{name: "Missing field equals nil", filter: bson.D{{Key: "deleted_at", Value: nil}}, want: []int{20, 25, 30, 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			accounts, err := mgo.Find(ctx, &memoryAccount{}, tt.filter, 0,
+				options.Find().SetSort(bson.D{{Key: "age", Value: 1}}))
+
+			// Assert
+			require.NoError(t, err)
+			ages := make([]int, len(accounts))
+			for i, a := range accounts {
+				ages[i] = a.Age
+			}
+			assert.Equal(t, tt.want, ages)
+		})
+	}
+
+	t.Run("Sort, skip and limit", func(t *testing.T) {
+		// Act
+		accounts, err := mgo.Find(ctx, &memoryAccount{}, bson.D{}, 2,
+			options.Find().SetSort(bson.D{{Key: "age", Value: -1}}).SetSkip(1))
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, accounts, 2)
+		assert.Equal(t, 30, accounts[0].Age)
+		assert.Equal(t, 25, accounts[1].Age)
+	})
+
+	t.Run("FindOne without match", func(t *testing.T) {
+		// Act
+		err := mgo.FindOne(ctx, &memoryAccount{}, bson.D{{Key: "age", Value: 99}})
+
+		// Assert
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("Unsupported operator", func(t *testing.T) {
+		// Act
+		_, err := mgo.Find(ctx, &memoryAccount{}, bson.D{{Key: "age", Value: bson.D{{Key: "$mod", Value: bson.A{2, 0}}}}}, 0)
+
+		// Assert
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestMemoryDatastoreUpdate(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+	account, err := mgo.Save(ctx, &memoryAccount{Email: "peter@x.io", Age: 30, Tags: []string{"a"}})
+	require.NoError(t, err)
+
+	// Act
+	modified, err := mgo.UpdateById(ctx, account, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}},
+		{Key: "$push", Value: bson.D{{Key: "tags", Value: "b"}}},
+		{Key: "$set", Value: bson.D{{Key: "email", Value: "p@x.io"}}},
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), modified)
+	got := &memoryAccount{ID: account.ID}
+	require.NoError(t, mgo.FindById(ctx, got))
+	assert.Equal(t, &memoryAccount{ID: account.ID, Email: "p@x.io", Age: 31, Tags: []string{"a", "b"}}, got)
+
+	t.Run("Unset and UpdateMany", func(t *testing.T) {
+		// Arrange
+		seedAccounts(t, 10, 11)
+
+		// Act
+		modified, err := mgo.UpdateMany(ctx, &memoryAccount{},
+			bson.D{{Key: "age", Value: bson.D{{Key: "$lt", Value: 20}}}},
+			bson.D{{Key: "$unset", Value: bson.D{{Key: "age", Value: ""}}}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), modified)
+		count, err := mgo.CountDocument(ctx, "memory_accounts",
+			bson.D{{Key: "age", Value: bson.D{{Key: "$exists", Value: false}}}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+}
+
+func TestMemoryDatastoreUniqueIndex(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+	_, err := mgo.Save(ctx, &memoryAccount{Email: "peter@x.io"})
+	require.NoError(t, err)
+	other, err := mgo.Save(ctx, &memoryAccount{Email: "amy@x.io"})
+	require.NoError(t, err)
+
+	// Act
+	_, saveErr := mgo.Save(ctx, &memoryAccount{Email: "peter@x.io"})
+	_, updateErr := mgo.UpdateById(ctx, other,
+		bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: "peter@x.io"}}}})
+
+	// Assert
+	require.ErrorIs(t, saveErr, mgo.ErrWriteFailed)
+	assert.True(t, mongo.IsDuplicateKeyError(saveErr))
+	assert.True(t, mongo.IsDuplicateKeyError(updateErr))
+}
+
+func TestMemoryDatastoreModelSupport(t *testing.T) {
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+
+	t.Run("Soft delete hides documents", func(t *testing.T) {
+		// Arrange
+		user, err := mgo.Save(ctx, &softUser{Name: "Peter"})
+		require.NoError(t, err)
+
+		// Act
+		deleted, err := mgo.DeleteById(ctx, user)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		users, err := mgo.Find(ctx, &softUser{}, bson.D{}, 0)
+		require.NoError(t, err)
+		assert.Empty(t, users)
+		users, err = mgo.Find(mgo.IncludeDeleted(ctx), &softUser{}, bson.D{}, 0)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.True(t, users[0].IsDeleted())
+	})
+
+	t.Run("Stale replacement conflicts", func(t *testing.T) {
+		// Arrange
+		user, err := mgo.Save(ctx, &versionedUser{Name: "Peter"})
+		require.NoError(t, err)
+		stale := *user
+		user.Name = "Amy"
+		_, err = mgo.ReplaceOne(ctx, user, bson.D{{Key: "_id", Value: user.ID}})
+		require.NoError(t, err)
+
+		// Act
+		stale.Name = "Bob"
+		_, err = mgo.ReplaceOne(ctx, &stale, bson.D{{Key: "_id", Value: stale.ID}})
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrVersionConflict)
+		assert.Equal(t, int64(2), user.Version)
+	})
+}
+
+func TestMemoryDatastoreTransaction(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+	seedAccounts(t, 30)
+
+	// Act
+	err := mgo.WithTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := mgo.Save(txCtx, &memoryAccount{Email: "z@x.io"}); err != nil {
+			return err
+		}
+		if _, err := mgo.DeleteMany(txCtx, &memoryAccount{}, bson.D{}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+
+	// Assert
+	require.Error(t, err)
+	count, err := mgo.CountDocument(ctx, "memory_accounts", bson.D{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMemoryDatastoreServerHelpers(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+	mgo.RegisterIndex(mgo.NewCollectDef("memory_schemas", func() []mongo.IndexModel { return nil },
+		mgo.WithJSONSchema(&memoryAccount{})))
+	plan := &mgo.IndexPlan{Drifts: []mgo.IndexDrift{{
+		Collection: "memory_accounts", Name: "email_1", Kind: mgo.IndexMissing,
+		Model: mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}},
+	}}}
+
+	tests := []struct {
+		call func() error
+		name string
+	}{
+		{name: "SyncIndexes", call: func() error { return mgo.SyncIndexes(ctx) }},
+		{name: "PlanIndexes", call: func() error { _, err := mgo.PlanIndexes(ctx); return err }},
+		{name: "ApplyIndexPlan", call: func() error { return mgo.ApplyIndexPlan(ctx, plan) }},
+		{name: "SyncCollections", call: func() error { return mgo.SyncCollections(ctx) }},
+		{name: "IsHealth", call: func() error { return mgo.IsHealth(ctx) }},
+		{name: "IsCollectionExist", call: func() error { _, err := mgo.IsCollectionExist(ctx, "users"); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := tt.call()
+
+			// Assert
+			require.ErrorIs(t, err, errors.ErrUnsupported)
+			assert.ErrorContains(t, err, tt.name)
+		})
+	}
+
+	t.Run("GetDatabase", func(t *testing.T) {
+		assert.Nil(t, mgo.GetDatabase())
+	})
+}
@@ -0,0 +1,254 @@
+package mgo
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mongoMetrics holds the Prometheus collectors registered by RegisterMetrics.
+type mongoMetrics struct {
+	duration   *prometheus.HistogramVec
+	errors     *prometheus.CounterVec
+	checkedOut *prometheus.GaugeVec
+	idle       *prometheus.GaugeVec
+	waitQueue  *prometheus.GaugeVec
+}
+
+var metrics atomic.Pointer[mongoMetrics]
+
+// RegisterMetrics registers the Prometheus collectors of the package with reg, or with
+// prometheus.DefaultRegisterer when reg is nil, and starts recording:
+//
+//   - vulpes_mongo_operation_duration_seconds: histogram of the latency of the helpers,
+//     by collection and operation (the operation names used for the trace spans).
+//   - vulpes_mongo_operation_errors_total: counter of failed operations by collection,
+//     operation and type, the snake_case name of the Err* value wrapped by the error
+//     (e.g. "write_failed", "version_conflict"), or "other".
+//   - vulpes_mongo_pool_checked_out_connections, vulpes_mongo_pool_idle_connections and
+//     vulpes_mongo_pool_wait_queue_size: connection pool gauges by server address, fed by
+//     the driver's pool monitor.
+//
+// Call it once, typically next to InitConnection. The pool gauges cover every connection
+// opened by InitConnection and Connect, including those opened before RegisterMetrics.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &mongoMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "vulpes",
+			Subsystem: "mongo",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of MongoDB operations.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"collection", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "vulpes",
+			Subsystem: "mongo",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed MongoDB operations.",
+		}, []string{"collection", "operation", "type"}),
+		checkedOut: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "vulpes",
+			Subsystem: "mongo",
+			Name:      "pool_checked_out_connections",
+			Help:      "Number of connections currently checked out of the pool.",
+		}, []string{"address"}),
+		idle: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "vulpes",
+			Subsystem: "mongo",
+			Name:      "pool_idle_connections",
+			Help:      "Number of connections ready and waiting in the pool.",
+		}, []string{"address"}),
+		waitQueue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "vulpes",
+			Subsystem: "mongo",
+			Name:      "pool_wait_queue_size",
+			Help:      "Number of operations waiting for a connection.",
+		}, []string{"address"}),
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.errors, m.checkedOut, m.idle, m.waitQueue} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	metrics.Store(m)
+	pools.publish(m)
+	return nil
+}
+
+// meteredSpan records the latency of an operation when it ends.
+type meteredSpan struct {
+	trace.Span
+	metrics    *mongoMetrics
+	start      time.Time
+	collection string
+	operation  string
+}
+
+// meterSpan wraps span so that its operation is measured, when metrics are enabled.
+func meterSpan(span trace.Span, collection, operation string) trace.Span {
+	m := metrics.Load()
+	if m == nil {
+		return span
+	}
+	return &meteredSpan{Span: span, metrics: m, start: time.Now(), collection: collection, operation: operation}
+}
+
+func (s *meteredSpan) End(opts ...trace.SpanEndOption) {
+	s.metrics.duration.WithLabelValues(s.collection, s.operation).Observe(time.Since(s.start).Seconds())
+	s.Span.End(opts...)
+}
+
+// recordError counts err against the operation of span, if it is measured. The measured span
+// may be wrapped by others, such as the span of the slow query log.
+func recordError(err error, span trace.Span) {
+	for {
+		if s, ok := span.(*meteredSpan); ok {
+			s.metrics.errors.WithLabelValues(s.collection, s.operation, errorType(err)).Inc()
+			return
+		}
+		wrapper, ok := span.(interface{ Unwrap() trace.Span })
+		if !ok {
+			return
+		}
+		span = wrapper.Unwrap()
+	}
+}
+
+// errorTypes maps the errors of the package to their metric label, in the order ToStatus checks them.
+var errorTypes = []struct {
+	err  error
+	name string
+}{
+	{ErrInvalidDocument, "invalid_document"},
+	{ErrInvalidTenant, "invalid_tenant"},
+	{ErrNotConnected, "not_connected"},
+	{ErrConnectionFailed, "connection_failed"},
+	{ErrPingFailed, "ping_failed"},
+	{ErrCreateIndexFailed, "create_index_failed"},
+	{ErrListCollectionFailed, "list_collection_failed"},
+	{ErrEncryptionFailed, "encryption_failed"},
+	{ErrWriteFailed, "write_failed"},
+	{ErrReadFailed, "read_failed"},
+	{ErrTransactionFailed, "transaction_failed"},
+	{ErrMigrationLocked, "migration_locked"},
+	{ErrMigrationFailed, "migration_failed"},
+	{ErrInvalidCursor, "invalid_cursor"},
+	{ErrVersionConflict, "version_conflict"},
+	{ErrHookFailed, "hook_failed"},
+}
+
+func errorType(err error) string {
+	for _, t := range errorTypes {
+		if errors.Is(err, t.err) {
+			return t.name
+		}
+	}
+	return "other"
+}
+
+// poolKey identifies a connection; connection IDs are only unique per server.
+type poolKey struct {
+	address string
+	id      int64
+}
+
+// poolStats are the gauge values of one server.
+type poolStats struct {
+	checkedOut float64
+	idle       float64
+	waitQueue  float64
+}
+
+// poolTracker follows the state of every pooled connection from the driver's pool events,
+// so that the gauges are right even when metrics are enabled after connecting.
+type poolTracker struct {
+	// checkedOut tells, for each ready connection, whether it is checked out.
+	checkedOut map[poolKey]bool
+	stats      map[string]*poolStats
+	mu         sync.Mutex
+}
+
+var pools = &poolTracker{checkedOut: map[poolKey]bool{}, stats: map[string]*poolStats{}}
+
+// newPoolMonitor returns a pool monitor feeding the pool gauges, which forwards the events
+// to next, the monitor set through the client options, if any.
+func newPoolMonitor(next *event.PoolMonitor) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			pools.handle(e)
+			if next != nil && next.Event != nil {
+				next.Event(e)
+			}
+		},
+	}
+}
+
+func (p *poolTracker) handle(e *event.PoolEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats, ok := p.stats[e.Address]
+	if !ok {
+		stats = &poolStats{}
+		p.stats[e.Address] = stats
+	}
+	key := poolKey{address: e.Address, id: e.ConnectionID}
+	switch e.Type {
+	case event.ConnectionReady:
+		p.checkedOut[key] = false
+		stats.idle++
+	case event.ConnectionCheckOutStarted:
+		stats.waitQueue++
+	case event.ConnectionCheckOutFailed:
+		stats.waitQueue--
+	case event.ConnectionCheckedOut:
+		stats.waitQueue--
+		if out, ok := p.checkedOut[key]; ok && !out {
+			stats.idle--
+		}
+		p.checkedOut[key] = true
+		stats.checkedOut++
+	case event.ConnectionCheckedIn:
+		if p.checkedOut[key] {
+			p.checkedOut[key] = false
+			stats.checkedOut--
+			stats.idle++
+		}
+	case event.ConnectionClosed:
+		if out, ok := p.checkedOut[key]; ok {
+			if out {
+				stats.checkedOut--
+			} else {
+				stats.idle--
+			}
+			delete(p.checkedOut, key)
+		}
+	default:
+		return
+	}
+	if m := metrics.Load(); m != nil {
+		stats.set(m, e.Address)
+	}
+}
+
+// publish sets the gauges of m from the current state of the pools.
+func (p *poolTracker) publish(m *mongoMetrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for address, stats := range p.stats {
+		stats.set(m, address)
+	}
+}
+
+func (s *poolStats) set(m *mongoMetrics, address string) {
+	m.checkedOut.WithLabelValues(address).Set(s.checkedOut)
+	m.idle.WithLabelValues(address).Set(s.idle)
+	m.waitQueue.WithLabelValues(address).Set(s.waitQueue)
+}
@@ -0,0 +1,134 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// enableMetrics registers the collectors with a new registry and returns it with a function disabling them.
+func enableMetrics(t *testing.T) (*prometheus.Registry, func()) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, RegisterMetrics(reg))
+	return reg, func() { metrics.Store(nil) }
+}
+
+func TestOperationMetrics(t *testing.T) {
+	// Arrange
+	reg, disable := enableMetrics(t)
+	defer disable()
+	restore := SetDatastore(NewMemoryDatastore())
+	defer restore()
+	ctx := context.Background()
+
+	// Act
+	user, err := Save(ctx, &testUser{Name: "a"})
+	require.NoError(t, err)
+	_, err = Save(ctx, user)
+	require.Error(t, err)
+	// A missing document is not counted as an error.
+	err = FindOne(ctx, &testUser{}, bson.D{{Key: "name", Value: "missing"}})
+	require.Error(t, err)
+
+	// Assert
+	count, err := testutil.GatherAndCount(reg, "vulpes_mongo_operation_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP vulpes_mongo_operation_errors_total Number of failed MongoDB operations.
+# TYPE vulpes_mongo_operation_errors_total counter
+vulpes_mongo_operation_errors_total{collection="users",operation="save",type="write_failed"} 1
+`), "vulpes_mongo_operation_errors_total"))
+}
+
+func TestOperationMetricsWithSlowQueryLog(t *testing.T) {
+	// Arrange: the slow query log wraps the measured span of queries.
+	reg, disable := enableMetrics(t)
+	defer disable()
+	defer SetSlowQueryLog(SlowQueryOptions{Threshold: time.Hour})()
+	defer SetDatastore(NewMemoryDatastore())()
+	ctx := context.Background()
+	_, err := Save(ctx, &testUser{Name: "Peter"})
+	require.NoError(t, err)
+
+	// Act
+	_, err = Find(ctx, &testUser{}, bson.D{{Key: "$where", Value: "true"}}, 0)
+
+	// Assert
+	require.Error(t, err)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP vulpes_mongo_operation_errors_total Number of failed MongoDB operations.
+# TYPE vulpes_mongo_operation_errors_total counter
+vulpes_mongo_operation_errors_total{collection="users",operation="find",type="read_failed"} 1
+`), "vulpes_mongo_operation_errors_total"))
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("%w: %w", ErrWriteFailed, errors.New("duplicate key")), want: "write_failed"},
+		{err: errors.Join(ErrInvalidDocument, errors.New("name is required")), want: "invalid_document"},
+		{err: fmt.Errorf("%w: users at version 3", ErrVersionConflict), want: "version_conflict"},
+		{err: hookError("BeforeSave", errors.New("name is taken")), want: "hook_failed"},
+		{err: errors.New("boom"), want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			// Act & Assert
+			assert.Equal(t, tt.want, errorType(tt.err))
+		})
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	// Arrange
+	const address = "pool-test:27017"
+	var forwarded int
+	monitor := newPoolMonitor(&event.PoolMonitor{Event: func(*event.PoolEvent) { forwarded++ }})
+	emit := func(typ string, id int64) {
+		monitor.Event(&event.PoolEvent{Type: typ, Address: address, ConnectionID: id})
+	}
+	// Connection 1 is ready before metrics are enabled.
+	emit(event.ConnectionReady, 1)
+	reg, disable := enableMetrics(t)
+	defer disable()
+
+	// Act
+	emit(event.ConnectionCheckOutStarted, 0)
+	emit(event.ConnectionCheckedOut, 1)
+	emit(event.ConnectionCheckOutStarted, 0)
+	emit(event.ConnectionCheckOutStarted, 0)
+	emit(event.ConnectionCheckOutFailed, 0)
+	emit(event.ConnectionReady, 2)
+	emit(event.ConnectionCheckedOut, 2)
+	emit(event.ConnectionCheckedIn, 1)
+	emit(event.ConnectionClosed, 2)
+
+	// Assert
+	assert.Equal(t, 10, forwarded)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP vulpes_mongo_pool_checked_out_connections Number of connections currently checked out of the pool.
+# TYPE vulpes_mongo_pool_checked_out_connections gauge
+vulpes_mongo_pool_checked_out_connections{address="pool-test:27017"} 0
+# HELP vulpes_mongo_pool_idle_connections Number of connections ready and waiting in the pool.
+# TYPE vulpes_mongo_pool_idle_connections gauge
+vulpes_mongo_pool_idle_connections{address="pool-test:27017"} 1
+# HELP vulpes_mongo_pool_wait_queue_size Number of operations waiting for a connection.
+# TYPE vulpes_mongo_pool_wait_queue_size gauge
+vulpes_mongo_pool_wait_queue_size{address="pool-test:27017"} 0
+`), "vulpes_mongo_pool_checked_out_connections", "vulpes_mongo_pool_idle_connections",
+		"vulpes_mongo_pool_wait_queue_size"))
+}
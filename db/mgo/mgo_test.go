@@ -343,6 +343,24 @@ func TestSave(t *testing.T) {
 		require.ErrorIs(t, err, mgo.ErrInvalidDocument)
 		assert.Contains(t, err.Error(), "document cannot be nil")
 	})
+
+	t.Run("Nil models with timestamps, versions or hooks", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{OnSave: mgo.NewOnSaveMock()})
+		defer restore()
+		ctx := context.Background()
+
+		// Act
+		_, softErr := mgo.Save(ctx, (*softUser)(nil))
+		_, versionedErr := mgo.Save(ctx, (*versionedUser)(nil))
+		_, hookedErr := mgo.Save(ctx, (*hookedUser)(nil))
+
+		// Assert
+		for _, err := range []error{softErr, versionedErr, hookedErr} {
+			require.ErrorIs(t, err, mgo.ErrInvalidDocument)
+			assert.ErrorContains(t, err, "document cannot be nil")
+		}
+	})
 }
 
 // testAggregate is a simple struct used for testing PipeFind.
@@ -1,6 +1,18 @@
 package mgo
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
 
 type migrateStatus string
 
@@ -11,9 +23,304 @@ const (
 	MigrateStatusFailed  migrateStatus = "failed"
 )
 
+const (
+	// migrationCollection stores one MigrationInfo document per registered version.
+	migrationCollection = "_migrations"
+	// migrationLockCollection holds the lease that keeps concurrent replicas from migrating at the same time.
+	migrationLockCollection = "_migration_locks"
+	migrationLockID         = "migrate"
+)
+
+// migrationLockTTL is the lease of the migration lock, renewed every third of it while migrating.
+var migrationLockTTL = 10 * time.Minute
+
 type MigrationInfo struct {
 	Status  migrateStatus `bson:"status"`
 	LastRun time.Time     `bson:"last_run"`
+	Name    string        `bson:"name"`
 	Error   string        `bson:"error,omitempty"`
 	Version int           `bson:"version"`
 }
+
+// MigrateFunc applies or reverts a single schema migration step.
+type MigrateFunc func(ctx context.Context) error
+
+type migration struct {
+	up      MigrateFunc
+	down    MigrateFunc
+	name    string
+	version int
+}
+
+// migrations holds all registered migration steps, keyed by version.
+var migrations = map[int]migration{}
+
+// RegisterMigration adds a migration step to the global registry.
+// This is typically called from the init() function of the package owning the schema.
+// Versions must be positive and unique; down may be nil if the step cannot be reverted.
+// It panics on invalid input, as a broken registry is a programming error.
+func RegisterMigration(version int, name string, up, down MigrateFunc) {
+	if version <= 0 {
+		panic(fmt.Sprintf("RegisterMigration: version must be positive, got %d", version))
+	}
+	if up == nil {
+		panic(fmt.Sprintf("RegisterMigration: up function of version %d is nil", version))
+	}
+	if m, ok := migrations[version]; ok {
+		panic(fmt.Sprintf("RegisterMigration: version %d already registered as %q", version, m.name))
+	}
+	migrations[version] = migration{
+		up:      up,
+		down:    down,
+		name:    name,
+		version: version,
+	}
+}
+
+// Migrate applies every registered migration that has not succeeded yet, in ascending version order.
+// Each step is recorded in the "_migrations" collection using MigrationInfo.
+// A distributed lock guarantees that only one replica migrates at a time;
+// ErrMigrationLocked is returned if another replica holds it. The lock is renewed while the
+// steps run, and their context is cancelled if it is lost.
+// Migrate stops at the first failing step, records its error and returns ErrMigrationFailed.
+func Migrate(ctx context.Context) error {
+	return MigrateOn(ctx, defaultDB)
+}
+
+// MigrateOn is like Migrate but applies the migrations to db, which keeps its own migration records.
+func MigrateOn(ctx context.Context, db *DB) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	_, span := store.startTraceSpan(ctx, migrationCollection, "migrate", nil)
+	defer span.End()
+	return spanErrorHandler(withMigrationLock(ctx, store, func(ctx context.Context) error {
+		applied, err := loadMigrationInfos(ctx, store)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations(false) {
+			if applied[m.version].Status == MigrateStatusSuccess {
+				continue
+			}
+			if err := runMigration(ctx, store, m, m.up, MigrateStatusSuccess); err != nil {
+				return err
+			}
+			log.Info("mongodb migration applied", log.Int("version", m.version), log.String("name", m.name))
+		}
+		return nil
+	}), span)
+}
+
+// Rollback reverts every applied migration whose version is greater than target, in descending order.
+// Reverted steps are recorded with MigrateStatusPending so that a later Migrate applies them again.
+// Use a target of 0 to revert all migrations.
+func Rollback(ctx context.Context, target int) error {
+	return RollbackOn(ctx, defaultDB, target)
+}
+
+// RollbackOn is like Rollback but reverts the migrations of db.
+func RollbackOn(ctx context.Context, db *DB, target int) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	_, span := store.startTraceSpan(ctx, migrationCollection, "rollback", nil)
+	defer span.End()
+	return spanErrorHandler(withMigrationLock(ctx, store, func(ctx context.Context) error {
+		applied, err := loadMigrationInfos(ctx, store)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations(true) {
+			if m.version <= target || applied[m.version].Status != MigrateStatusSuccess {
+				continue
+			}
+			if m.down == nil {
+				return fmt.Errorf(
+					"%w: version %d (%s) has no down function", ErrMigrationFailed, m.version, m.name,
+				)
+			}
+			if err := runMigration(ctx, store, m, m.down, MigrateStatusPending); err != nil {
+				return err
+			}
+			log.Info("mongodb migration reverted", log.Int("version", m.version), log.String("name", m.name))
+		}
+		return nil
+	}), span)
+}
+
+// runMigration executes f for m and records its outcome, storing done as the status on success.
+func runMigration(ctx context.Context, store Datastore, m migration, f MigrateFunc, done migrateStatus) error {
+	info := MigrationInfo{
+		Status:  MigrateStatusRunning,
+		LastRun: time.Now(),
+		Name:    m.name,
+		Version: m.version,
+	}
+	if err := saveMigrationInfo(ctx, store, info); err != nil {
+		return err
+	}
+	if err := f(ctx); err != nil {
+		info.Status = MigrateStatusFailed
+		info.Error = err.Error()
+		if saveErr := saveMigrationInfo(ctx, store, info); saveErr != nil {
+			log.Error("failed to record migration failure", log.Int("version", m.version), log.Err(saveErr))
+		}
+		return fmt.Errorf("%w: version %d (%s): %w", ErrMigrationFailed, m.version, m.name, err)
+	}
+	info.Status = done
+	return saveMigrationInfo(ctx, store, info)
+}
+
+func sortedMigrations(desc bool) []migration {
+	result := make([]migration, 0, len(migrations))
+	for _, m := range migrations {
+		result = append(result, m)
+	}
+	slices.SortFunc(result, func(a, b migration) int {
+		if desc {
+			return b.version - a.version
+		}
+		return a.version - b.version
+	})
+	return result
+}
+
+func loadMigrationInfos(ctx context.Context, store Datastore) (map[int]MigrationInfo, error) {
+	cursor, err := store.Find(ctx, migrationCollection, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	defer cursor.Close(ctx)
+	infos, err := cursorToSlice[MigrationInfo](ctx, cursor, len(migrations))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	result := make(map[int]MigrationInfo, len(infos))
+	for _, info := range infos {
+		result[info.Version] = info
+	}
+	return result, nil
+}
+
+func saveMigrationInfo(ctx context.Context, store Datastore, info MigrationInfo) error {
+	set := bson.D{
+		bson.E{Key: "status", Value: info.Status},
+		bson.E{Key: "last_run", Value: info.LastRun},
+		bson.E{Key: "name", Value: info.Name},
+		bson.E{Key: "version", Value: info.Version},
+	}
+	var update bson.D
+	if info.Error == "" {
+		update = bson.D{
+			bson.E{Key: "$set", Value: set},
+			bson.E{Key: "$unset", Value: bson.D{bson.E{Key: "error", Value: ""}}},
+		}
+	} else {
+		update = bson.D{bson.E{Key: "$set", Value: append(set, bson.E{Key: "error", Value: info.Error})}}
+	}
+	_, err := store.UpdateOne(
+		ctx, migrationCollection,
+		bson.D{bson.E{Key: "version", Value: info.Version}}, update,
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// withMigrationLock runs f while holding the migration lease.
+// The lease is an upserted document whose _id is unique: the upsert only matches an
+// expired lease, so while another replica holds a valid one it fails with a duplicate key error.
+// The lease is renewed while f runs; if it is lost, the context of f is cancelled and
+// ErrMigrationFailed is returned, as another replica may be migrating by then.
+func withMigrationLock(ctx context.Context, store Datastore, f func(ctx context.Context) error) error {
+	owner := bson.NewObjectID().Hex()
+	now := time.Now()
+	_, err := store.UpdateOne(
+		ctx, migrationLockCollection,
+		bson.D{
+			bson.E{Key: "_id", Value: migrationLockID},
+			bson.E{Key: "expire_at", Value: bson.D{bson.E{Key: "$lt", Value: now}}},
+		},
+		bson.D{bson.E{Key: "$set", Value: bson.D{
+			bson.E{Key: "owner", Value: owner},
+			bson.E{Key: "expire_at", Value: now.Add(migrationLockTTL)},
+		}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrMigrationLocked
+		}
+		return err
+	}
+	defer func() {
+		// Release even if ctx was cancelled while migrating.
+		_, err := store.DeleteOne(context.WithoutCancel(ctx), migrationLockCollection, bson.D{
+			bson.E{Key: "_id", Value: migrationLockID},
+			bson.E{Key: "owner", Value: owner},
+		})
+		if err != nil {
+			log.Warn("failed to release migration lock", log.Err(err))
+		}
+	}()
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := make(chan struct{})
+	renewed := make(chan error, 1)
+	go func() {
+		renewed <- renewMigrationLock(lockCtx, store, owner, stop, cancel)
+	}()
+	err = f(lockCtx)
+	close(stop)
+	if lostErr := <-renewed; lostErr != nil {
+		return lostErr
+	}
+	return err
+}
+
+// renewMigrationLock extends the lease of owner every third of migrationLockTTL until stop is
+// closed. Renewals that fail are retried until the lease expires; once it is lost, lost is
+// called and the reason returned.
+func renewMigrationLock(
+	ctx context.Context, store Datastore, owner string, stop <-chan struct{}, lost func(),
+) error {
+	interval := max(migrationLockTTL/3, time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expiry := time.Now().Add(migrationLockTTL)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		// Keep renewing when ctx is cancelled, until f has returned.
+		renewCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interval)
+		start := time.Now()
+		n, err := store.UpdateOne(
+			renewCtx, migrationLockCollection,
+			bson.D{
+				bson.E{Key: "_id", Value: migrationLockID},
+				bson.E{Key: "owner", Value: owner},
+			},
+			bson.D{bson.E{Key: "$set", Value: bson.D{
+				bson.E{Key: "expire_at", Value: start.Add(migrationLockTTL)},
+			}}},
+		)
+		cancel()
+		switch {
+		case err == nil && n > 0:
+			expiry = start.Add(migrationLockTTL)
+			continue
+		case err == nil:
+			err = errors.New("the lease expired or was taken over")
+		case time.Now().Before(expiry):
+			log.Warn("failed to renew migration lock", log.Err(err))
+			continue
+		}
+		lost()
+		return fmt.Errorf("%w: migration lock lost: %w", ErrMigrationFailed, err)
+	}
+}
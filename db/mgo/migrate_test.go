@@ -0,0 +1,198 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// newMigrationMock returns a MockDatastore that reports existing as the recorded migrations
+// and appends every "status:version" pair written to the migration collection to recorded.
+func newMigrationMock(recorded *[]string, lockErr error, existing ...any) *MockDatastore {
+	return &MockDatastore{
+		OnFind: NewOnFindMock(existing...),
+		OnUpdateOne: func(_ context.Context, collection string, filter bson.D, update bson.D) (int64, error) {
+			if collection == migrationLockCollection {
+				return 0, lockErr
+			}
+			set, _ := update[0].Value.(bson.D)
+			*recorded = append(*recorded, fmt.Sprintf("%s:%d", set[0].Value, filter[0].Value))
+			return 1, nil
+		},
+		OnDeleteOne: func(context.Context, string, bson.D) (int64, error) {
+			return 1, nil
+		},
+	}
+}
+
+func resetMigrations(t *testing.T) {
+	t.Helper()
+	original := migrations
+	migrations = map[int]migration{}
+	t.Cleanup(func() { migrations = original })
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("Applies pending migrations in order", func(t *testing.T) {
+		resetMigrations(t)
+		var ran []int
+		var recorded []string
+		for _, v := range []int{3, 1, 2} {
+			RegisterMigration(v, "step", func(context.Context) error {
+				ran = append(ran, v)
+				return nil
+			}, nil)
+		}
+		restore := SetDatastore(newMigrationMock(
+			&recorded, nil,
+			MigrationInfo{Version: 1, Status: MigrateStatusSuccess},
+		))
+		defer restore()
+
+		err := Migrate(context.Background())
+
+		require.NoError(t, err)
+		assert.Equal(t, []int{2, 3}, ran)
+		assert.Equal(t, []string{"running:2", "success:2", "running:3", "success:3"}, recorded)
+	})
+
+	t.Run("Records failure and stops", func(t *testing.T) {
+		resetMigrations(t)
+		expectedErr := errors.New("boom")
+		secondRan := false
+		RegisterMigration(1, "broken", func(context.Context) error { return expectedErr }, nil)
+		RegisterMigration(2, "next", func(context.Context) error {
+			secondRan = true
+			return nil
+		}, nil)
+		var recorded []string
+		restore := SetDatastore(newMigrationMock(&recorded, nil))
+		defer restore()
+
+		err := Migrate(context.Background())
+
+		require.ErrorIs(t, err, ErrMigrationFailed)
+		require.ErrorIs(t, err, expectedErr)
+		assert.False(t, secondRan)
+		assert.Equal(t, []string{"running:1", "failed:1"}, recorded)
+	})
+
+	t.Run("Locked by another replica", func(t *testing.T) {
+		resetMigrations(t)
+		ran := false
+		RegisterMigration(1, "step", func(context.Context) error {
+			ran = true
+			return nil
+		}, nil)
+		lockErr := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+		var recorded []string
+		restore := SetDatastore(newMigrationMock(&recorded, lockErr))
+		defer restore()
+
+		err := Migrate(context.Background())
+
+		require.ErrorIs(t, err, ErrMigrationLocked)
+		assert.False(t, ran)
+		assert.Empty(t, recorded)
+	})
+}
+
+func TestMigrationLockRenewal(t *testing.T) {
+	shortLease := func(t *testing.T) {
+		t.Helper()
+		original := migrationLockTTL
+		migrationLockTTL = 30 * time.Millisecond
+		t.Cleanup(func() { migrationLockTTL = original })
+	}
+
+	t.Run("Renews the lease while migrating", func(t *testing.T) {
+		// Arrange
+		resetMigrations(t)
+		shortLease(t)
+		RegisterMigration(1, "slow", func(context.Context) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}, nil)
+		var recorded []string
+		var renewals atomic.Int32
+		store := newMigrationMock(&recorded, nil)
+		onUpdateOne := store.OnUpdateOne
+		store.OnUpdateOne = func(ctx context.Context, collection string, filter bson.D, update bson.D) (int64, error) {
+			if collection == migrationLockCollection && filter[1].Key == "owner" {
+				renewals.Add(1)
+				return 1, nil
+			}
+			return onUpdateOne(ctx, collection, filter, update)
+		}
+		defer SetDatastore(store)()
+
+		// Act
+		err := Migrate(context.Background())
+
+		// Assert
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, renewals.Load(), int32(2))
+		assert.Equal(t, []string{"running:1", "success:1"}, recorded)
+	})
+
+	t.Run("Stops when the lease is lost", func(t *testing.T) {
+		// Arrange: the mock matches no lease when renewing.
+		resetMigrations(t)
+		shortLease(t)
+		RegisterMigration(1, "slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, nil)
+		var recorded []string
+		defer SetDatastore(newMigrationMock(&recorded, nil))()
+
+		// Act
+		err := Migrate(context.Background())
+
+		// Assert
+		require.ErrorIs(t, err, ErrMigrationFailed)
+		assert.ErrorContains(t, err, "migration lock lost")
+	})
+}
+
+func TestRollback(t *testing.T) {
+	resetMigrations(t)
+	var reverted []int
+	var recorded []string
+	for _, v := range []int{1, 2, 3} {
+		RegisterMigration(v, "step", func(context.Context) error { return nil }, func(context.Context) error {
+			reverted = append(reverted, v)
+			return nil
+		})
+	}
+	restore := SetDatastore(newMigrationMock(
+		&recorded, nil,
+		MigrationInfo{Version: 1, Status: MigrateStatusSuccess},
+		MigrationInfo{Version: 2, Status: MigrateStatusSuccess},
+		MigrationInfo{Version: 3, Status: MigrateStatusSuccess},
+	))
+	defer restore()
+
+	err := Rollback(context.Background(), 1)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 2}, reverted)
+	assert.Equal(t, []string{"running:3", "pending:3", "running:2", "pending:2"}, recorded)
+}
+
+func TestRegisterMigrationPanics(t *testing.T) {
+	resetMigrations(t)
+	noop := func(context.Context) error { return nil }
+	assert.Panics(t, func() { RegisterMigration(0, "zero", noop, nil) })
+	assert.Panics(t, func() { RegisterMigration(1, "nil up", nil, nil) })
+	RegisterMigration(1, "first", noop, nil)
+	assert.Panics(t, func() { RegisterMigration(1, "duplicate", noop, nil) })
+}
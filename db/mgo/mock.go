@@ -46,6 +46,10 @@ type MockDatastore struct {
 	OnReplaceOne func(
 		ctx context.Context, collection string, filter any, replacement any, opts ...options.Lister[options.ReplaceOptions],
 	) (*mongo.UpdateResult, error)
+	OnBulkWrite func(
+		ctx context.Context, collection string, models []mongo.WriteModel,
+		opts ...options.Lister[options.BulkWriteOptions],
+	) (*mongo.BulkWriteResult, error)
 	OnDeleteOne        func(ctx context.Context, collection string, filter bson.D) (int64, error)
 	OnDeleteMany       func(ctx context.Context, collection string, filter bson.D) (int64, error)
 	OnPipeFind         func(ctx context.Context, collection string, pipeline mongo.Pipeline) (*mongo.Cursor, error)
@@ -62,15 +66,26 @@ type MockDatastore struct {
 		ctx context.Context, collectionName string, operation string, statement any,
 	) (context.Context, trace.Span)
 	OnImport func(ctx context.Context, collectionName string, reader io.Reader) error
+	OnWatch  func(
+		ctx context.Context, collection string, pipeline mongo.Pipeline, opts ...options.Lister[options.ChangeStreamOptions],
+	) (ChangeStream, error)
+	OnWithTransaction func(
+		ctx context.Context, fn func(txCtx context.Context) error, opts ...options.Lister[options.TransactionOptions],
+	) error
 }
 
 // MockBulkOperator is a mock implementation of the BulkOperator interface.
 type MockBulkOperator struct {
-	OnInsertOne func(doc DocInter) BulkOperator
-	OnUpdateOne func(filter any, update any) BulkOperator
-	OnUpsertOne func(filter any, update any) BulkOperator
-	OnExecute   func(ctx context.Context) (*mongo.BulkWriteResult, error)
-	OnDeleteOne func(filter any) BulkOperator
+	OnInsertOne  func(doc DocInter) BulkOperator
+	OnReplaceOne func(filter any, replacement DocInter) BulkOperator
+	OnUpdateOne  func(filter any, update any) BulkOperator
+	OnUpdateMany func(filter any, update any) BulkOperator
+	OnUpsertOne  func(filter any, update any) BulkOperator
+	OnExecute    func(ctx context.Context) (*mongo.BulkWriteResult, error)
+	OnDeleteOne  func(filter any) BulkOperator
+	OnDeleteMany func(filter any) BulkOperator
+	OnUnordered  func() BulkOperator
+	OnChunkSize  func(n int) BulkOperator
 }
 
 // Interface implementations for MockDatastore
@@ -146,10 +161,19 @@ func (m *MockDatastore) PipeFindOne(
 	return m.OnPipeFindOne(ctx, collection, pipeline)
 }
 
+// NewBulkOperation returns the result of OnNewBulkOperation or, when it is nil, a bulk operation
+// that is executed with OnBulkWrite.
 func (m *MockDatastore) NewBulkOperation(cname string) BulkOperator {
+	if m.OnNewBulkOperation == nil {
+		return newBulkOperation(m, cname)
+	}
 	return m.OnNewBulkOperation(cname)
 }
 
+func (m *MockDatastore) tenantStore(context.Context) (Datastore, error) {
+	return m, nil
+}
+
 func (m *MockDatastore) getCollection(name string) *mongo.Collection {
 	return m.OnGetCollection(name)
 }
@@ -179,20 +203,52 @@ func (m *MockDatastore) startTraceSpan(
 	return m.OnStartTraceSpan(ctx, collectionName, operation, statement)
 }
 
+func (m *MockDatastore) BulkWrite(
+	ctx context.Context, collection string, models []mongo.WriteModel, opts ...options.Lister[options.BulkWriteOptions],
+) (*mongo.BulkWriteResult, error) {
+	return m.OnBulkWrite(ctx, collection, models, opts...)
+}
+
 func (m *MockDatastore) Import(ctx context.Context, collectionName string, reader io.Reader) error {
 	return m.OnImport(ctx, collectionName, reader)
 }
 
+func (m *MockDatastore) Watch(
+	ctx context.Context, collection string, pipeline mongo.Pipeline, opts ...options.Lister[options.ChangeStreamOptions],
+) (ChangeStream, error) {
+	return m.OnWatch(ctx, collection, pipeline, opts...)
+}
+
+// WithTransaction calls OnWithTransaction when it is set. Otherwise it simply
+// runs fn with the given context, so transactional code can be unit-tested
+// without wiring a hook.
+func (m *MockDatastore) WithTransaction(
+	ctx context.Context, fn func(txCtx context.Context) error, opts ...options.Lister[options.TransactionOptions],
+) error {
+	if m.OnWithTransaction == nil {
+		return fn(ctx)
+	}
+	return m.OnWithTransaction(ctx, fn, opts...)
+}
+
 // Interface implementations for MockBulkOperator
 
 func (m *MockBulkOperator) InsertOne(doc DocInter) BulkOperator {
 	return m.OnInsertOne(doc)
 }
 
+func (m *MockBulkOperator) ReplaceOne(filter any, replacement DocInter) BulkOperator {
+	return m.OnReplaceOne(filter, replacement)
+}
+
 func (m *MockBulkOperator) UpdateOne(filter any, update any) BulkOperator {
 	return m.OnUpdateOne(filter, update)
 }
 
+func (m *MockBulkOperator) UpdateMany(filter any, update any) BulkOperator {
+	return m.OnUpdateMany(filter, update)
+}
+
 func (m *MockBulkOperator) UpdateById(id any, update any) BulkOperator {
 	return m.OnUpdateOne(bson.M{"_id": id}, update)
 }
@@ -209,6 +265,18 @@ func (m *MockBulkOperator) DeleteById(id any) BulkOperator {
 	return m.OnDeleteOne(bson.M{"_id": id})
 }
 
+func (m *MockBulkOperator) DeleteMany(filter any) BulkOperator {
+	return m.OnDeleteMany(filter)
+}
+
+func (m *MockBulkOperator) Unordered() BulkOperator {
+	return m.OnUnordered()
+}
+
+func (m *MockBulkOperator) ChunkSize(n int) BulkOperator {
+	return m.OnChunkSize(n)
+}
+
 func (m *MockBulkOperator) Execute(ctx context.Context) (*mongo.BulkWriteResult, error) {
 	return m.OnExecute(ctx)
 }
@@ -292,7 +360,14 @@ func NewOnBulkOperationMock(result *mongo.BulkWriteResult, err error) func(cname
 
 		// Make chainable methods return the mock operator itself
 		mockOp.OnInsertOne = func(DocInter) BulkOperator { return mockOp }
+		mockOp.OnReplaceOne = func(any, DocInter) BulkOperator { return mockOp }
 		mockOp.OnUpdateOne = func(any, any) BulkOperator { return mockOp }
+		mockOp.OnUpdateMany = func(any, any) BulkOperator { return mockOp }
+		mockOp.OnUpsertOne = func(any, any) BulkOperator { return mockOp }
+		mockOp.OnDeleteOne = func(any) BulkOperator { return mockOp }
+		mockOp.OnDeleteMany = func(any) BulkOperator { return mockOp }
+		mockOp.OnUnordered = func() BulkOperator { return mockOp }
+		mockOp.OnChunkSize = func(int) BulkOperator { return mockOp }
 
 		// Set the final return value for the Execute method
 		mockOp.OnExecute = func(context.Context) (*mongo.BulkWriteResult, error) {
@@ -301,3 +376,53 @@ func NewOnBulkOperationMock(result *mongo.BulkWriteResult, err error) func(cname
 		return mockOp
 	}
 }
+
+// NewOnWatchMock returns an OnWatch function whose change stream yields the given fake events
+// and then ends. Each event should be a change event document, e.g.
+// bson.D{{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}}, {Key: "operationType", Value: "insert"}, ...}.
+func NewOnWatchMock(events ...any) func(
+	ctx context.Context, collection string, pipeline mongo.Pipeline, opts ...options.Lister[options.ChangeStreamOptions],
+) (ChangeStream, error) {
+	return func(
+		_ context.Context, _ string, _ mongo.Pipeline, _ ...options.Lister[options.ChangeStreamOptions],
+	) (ChangeStream, error) {
+		cursor, err := mongo.NewCursorFromDocuments(events, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &mockChangeStream{cursor: cursor}, nil
+	}
+}
+
+// mockChangeStream adapts a cursor of fake events to the ChangeStream interface.
+type mockChangeStream struct {
+	cursor *mongo.Cursor
+}
+
+func (s *mockChangeStream) Next(ctx context.Context) bool {
+	return s.cursor.Next(ctx)
+}
+
+func (s *mockChangeStream) Decode(val any) error {
+	return s.cursor.Decode(val)
+}
+
+func (s *mockChangeStream) ResumeToken() bson.Raw {
+	token, err := s.cursor.Current.LookupErr("_id")
+	if err != nil {
+		return nil
+	}
+	doc, ok := token.DocumentOK()
+	if !ok {
+		return nil
+	}
+	return doc
+}
+
+func (s *mockChangeStream) Err() error {
+	return s.cursor.Err()
+}
+
+func (s *mockChangeStream) Close(ctx context.Context) error {
+	return s.cursor.Close(ctx)
+}
@@ -0,0 +1,353 @@
+package mgo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultPageSize   = 20
+	cursorSecretBytes = 32
+)
+
+var (
+	cursorSecret   []byte
+	cursorSecretMu sync.RWMutex
+)
+
+// SetCursorSecret sets the key used to sign the continuation tokens of PaginateCursor.
+// Without it a random key is generated per process, so tokens are neither valid
+// across replicas nor after a restart. Call it once at startup, before serving requests.
+// It panics when secret is shorter than 32 bytes.
+func SetCursorSecret(secret []byte) {
+	if len(secret) < cursorSecretBytes {
+		panic(fmt.Sprintf("SetCursorSecret: secret must be at least %d bytes, got %d", cursorSecretBytes, len(secret)))
+	}
+	cursorSecretMu.Lock()
+	defer cursorSecretMu.Unlock()
+	cursorSecret = append([]byte(nil), secret...)
+}
+
+func getCursorSecret() []byte {
+	cursorSecretMu.RLock()
+	secret := cursorSecret
+	cursorSecretMu.RUnlock()
+	if secret != nil {
+		return secret
+	}
+	cursorSecretMu.Lock()
+	defer cursorSecretMu.Unlock()
+	if cursorSecret == nil {
+		cursorSecret = make([]byte, cursorSecretBytes)
+		_, _ = rand.Read(cursorSecret)
+	}
+	return cursorSecret
+}
+
+// Page holds one page of an offset-based pagination.
+type Page[T any] struct {
+	Items []T
+	// Total is the number of documents matching the filter across all pages.
+	Total int64
+	// Page is the 1-based page number of Items.
+	Page       int64
+	PageSize   int64
+	TotalPages int64
+}
+
+func newPage[T any](items []T, total, page, pageSize int64) *Page[T] {
+	return &Page[T]{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+}
+
+// normalizePage defaults page to 1 and pageSize to defaultPageSize and returns the number of documents to skip.
+func normalizePage(page, pageSize int64) (int64, int64, int64) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	return page, pageSize, (page - 1) * pageSize
+}
+
+// Paginate returns the given 1-based page of the documents matching filter, together with
+// the total number of matching documents. Sorting can be supplied through opts,
+// e.g. options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).
+// A page below 1 is treated as 1 and a non-positive pageSize defaults to 20.
+func Paginate[T DocInter](
+	ctx context.Context, doc T, filter any, page, pageSize int64,
+	opts ...options.Lister[options.FindOptions],
+) (*Page[T], error) {
+	return PaginateOn(ctx, defaultDB, doc, filter, page, pageSize, opts...)
+}
+
+// PaginateOn is like Paginate but reads from db.
+func PaginateOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any, page, pageSize int64,
+	opts ...options.Lister[options.FindOptions],
+) (*Page[T], error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	filter, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return nil, err
+	}
+	filter = excludeDeleted(ctx, isSoftDeleter(doc), filter)
+	if filter == nil {
+		filter = bson.D{}
+	}
+	page, pageSize, skip := normalizePage(page, pageSize)
+	collectionName := doc.C()
+	_, span := store.startTraceSpan(ctx, collectionName, "paginate", filter)
+	defer span.End()
+
+	total, err := retryValue(ctx, span, true, func() (int64, error) {
+		return store.CountDocument(ctx, collectionName, filter)
+	})
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	finalArgs := make([]options.Lister[options.FindOptions], 0, len(opts)+1)
+	finalArgs = append(finalArgs, opts...)
+	finalArgs = append(finalArgs, options.Find().SetSkip(skip).SetLimit(pageSize))
+	cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.Find(ctx, collectionName, filter, finalArgs...)
+	})
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	items, err := cursorToSlice[T](ctx, cursor, int(pageSize))
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	if err := afterFindAll(ctx, items); err != nil {
+		return nil, spanErrorHandler(err, span)
+	}
+	span.SetAttributes(attribute.Int64("db.total_documents", total))
+	return newPage(items, total, page, pageSize), spanErrorHandler(nil, span)
+}
+
+// PaginatePipe runs the pipeline of aggr and returns the given 1-based page of its results.
+// Items and total count are computed in a single round trip with a $facet stage
+// appended to the pipeline.
+func PaginatePipe[T MgoAggregate](
+	ctx context.Context, aggr T, filter bson.M, page, pageSize int64,
+) (*Page[T], error) {
+	return PaginatePipeOn(ctx, defaultDB, aggr, filter, page, pageSize)
+}
+
+// PaginatePipeOn is like PaginatePipe but runs the pipeline on db.
+func PaginatePipeOn[T MgoAggregate](
+	ctx context.Context, db *DB, aggr T, filter bson.M, page, pageSize int64,
+) (*Page[T], error) {
+	return paginateByPipeline[T](ctx, db, aggr.C(), aggr.GetPipeline(filter), page, pageSize, "paginatePipe")
+}
+
+// CursorQuery describes one request of a keyset (cursor-based) pagination.
+type CursorQuery struct {
+	// Cursor is the NextCursor of the previous page; leave it empty for the first page.
+	Cursor string
+	// SortField is the field the pages are ordered by. Ties are broken by _id.
+	// Leave it empty to order by _id only.
+	SortField string
+	// Size is the maximum number of items per page; defaults to 20.
+	Size int64
+	// Descending reverses the order of SortField and _id.
+	Descending bool
+}
+
+// CursorPage holds one page of a keyset pagination.
+type CursorPage[T any] struct {
+	// NextCursor is the opaque token to request the following page. It is empty on the last page.
+	NextCursor string
+	Items      []T
+	HasMore    bool
+}
+
+// cursorToken is the signed payload of a continuation token.
+type cursorToken struct {
+	Value      bson.RawValue `bson:"v"`
+	ID         bson.RawValue `bson:"id"`
+	Field      string        `bson:"f"`
+	Descending bool          `bson:"d"`
+}
+
+// PaginateCursor returns the page following q.Cursor, ordered by q.SortField and _id.
+// Unlike offset pagination, keyset pagination stays fast on deep pages and is stable
+// when documents are inserted concurrently. The continuation token is signed with the
+// key set by SetCursorSecret; a tampered token, or one issued for a different sort,
+// is rejected with ErrInvalidCursor.
+func PaginateCursor[T DocInter](
+	ctx context.Context, doc T, filter any, q CursorQuery,
+) (*CursorPage[T], error) {
+	return PaginateCursorOn(ctx, defaultDB, doc, filter, q)
+}
+
+// PaginateCursorOn is like PaginateCursor but reads from db.
+func PaginateCursorOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any, q CursorQuery,
+) (*CursorPage[T], error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	filter, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return nil, err
+	}
+	filter = excludeDeleted(ctx, isSoftDeleter(doc), filter)
+	if q.Size < 1 {
+		q.Size = defaultPageSize
+	}
+	if q.SortField == "" {
+		q.SortField = "_id"
+	}
+	if q.Cursor != "" {
+		token, err := decodeCursorToken(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if token.Field != q.SortField || token.Descending != q.Descending {
+			return nil, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidCursor)
+		}
+		filter = andKeyset(filter, token)
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	dir := 1
+	if q.Descending {
+		dir = -1
+	}
+	sort := bson.D{bson.E{Key: q.SortField, Value: dir}}
+	if q.SortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: dir})
+	}
+	collectionName := doc.C()
+	_, span := store.startTraceSpan(ctx, collectionName, "paginateCursor", filter)
+	defer span.End()
+
+	cursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.Find(ctx, collectionName, filter, options.Find().SetSort(sort).SetLimit(q.Size+1))
+	})
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	items, err := cursorToSlice[T](ctx, cursor, int(q.Size)+1)
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	result := &CursorPage[T]{Items: items}
+	if int64(len(items)) > q.Size {
+		result.Items = items[:q.Size]
+		result.HasMore = true
+		result.NextCursor, err = encodeCursorToken(result.Items[q.Size-1], q.SortField, q.Descending)
+		if err != nil {
+			return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+		}
+	}
+	// The hooks run after the next cursor is taken from the documents as stored.
+	if err := afterFindAll(ctx, result.Items); err != nil {
+		return nil, spanErrorHandler(err, span)
+	}
+	return result, spanErrorHandler(nil, span)
+}
+
+// andKeyset restricts filter to the documents after the position recorded in token.
+func andKeyset(filter any, token *cursorToken) any {
+	op := "$gt"
+	if token.Descending {
+		op = "$lt"
+	}
+	keyset := bson.D{bson.E{Key: "_id", Value: bson.D{bson.E{Key: op, Value: token.ID}}}}
+	if token.Field != "_id" {
+		keyset = bson.D{bson.E{Key: "$or", Value: bson.A{
+			bson.D{bson.E{Key: token.Field, Value: bson.D{bson.E{Key: op, Value: token.Value}}}},
+			bson.D{
+				bson.E{Key: token.Field, Value: token.Value},
+				bson.E{Key: "_id", Value: bson.D{bson.E{Key: op, Value: token.ID}}},
+			},
+		}}}
+	}
+	if filter == nil {
+		return keyset
+	}
+	return bson.D{bson.E{Key: "$and", Value: bson.A{filter, keyset}}}
+}
+
+func encodeCursorToken(last any, field string, desc bool) (string, error) {
+	raw, err := bson.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+	id, err := bson.Raw(raw).LookupErr("_id")
+	if err != nil {
+		return "", fmt.Errorf("cursor pagination requires an _id: %w", err)
+	}
+	value := id
+	if field != "_id" {
+		value, err = bson.Raw(raw).LookupErr(strings.Split(field, ".")...)
+		if err != nil {
+			return "", fmt.Errorf("sort field %q not found: %w", field, err)
+		}
+	}
+	payload, err := bson.Marshal(cursorToken{Value: value, ID: id, Field: field, Descending: desc})
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, getCursorSecret())
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload)), nil
+}
+
+func decodeCursorToken(s string) (*cursorToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) <= sha256.Size {
+		return nil, ErrInvalidCursor
+	}
+	payload, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	mac := hmac.New(sha256.New, getCursorSecret())
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+	var token cursorToken
+	if err := bson.Unmarshal(payload, &token); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return &token, nil
+}
+
+// decodeRaw decodes a single document into a new T, preferring bson.Unmarshaler when T implements it.
+// The encrypted fields of T are decrypted first.
+func decodeRaw[T any](ctx context.Context, raw bson.Raw) (T, error) {
+	var t T
+	raw, err := openDocument(ctx, reflect.TypeFor[T](), raw)
+	if err != nil {
+		return t, err
+	}
+	if unmarshaler, ok := any(&t).(bson.Unmarshaler); ok {
+		return t, unmarshaler.UnmarshalBSON(raw)
+	}
+	return t, bson.Unmarshal(raw, &t)
+}
@@ -0,0 +1,172 @@
+package mgo_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPaginate(t *testing.T) {
+	// Arrange
+	var findOpts options.FindOptions
+	mockDB := &mgo.MockDatastore{
+		OnCountDocument: func(context.Context, string, any) (int64, error) {
+			return 45, nil
+		},
+		OnFind: func(
+			_ context.Context, _ string, _ any, opts ...options.Lister[options.FindOptions],
+		) (*mongo.Cursor, error) {
+			for _, opt := range opts {
+				for _, set := range opt.List() {
+					_ = set(&findOpts)
+				}
+			}
+			return mongo.NewCursorFromDocuments([]any{
+				testUser{Name: "Peter"}, testUser{Name: "Alice"},
+			}, nil, nil)
+		},
+	}
+	restore := mgo.SetDatastore(mockDB)
+	defer restore()
+
+	// Act
+	page, err := mgo.Paginate(context.Background(), &testUser{}, nil, 3, 20)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, int64(45), page.Total)
+	assert.Equal(t, int64(3), page.Page)
+	assert.Equal(t, int64(3), page.TotalPages)
+	assert.Equal(t, int64(40), *findOpts.Skip)
+	assert.Equal(t, int64(20), *findOpts.Limit)
+}
+
+func TestPaginatePipe(t *testing.T) {
+	// Arrange
+	mockDB := &mgo.MockDatastore{
+		OnPipeFindOne: func(_ context.Context, _ string, pipeline mongo.Pipeline) *mongo.SingleResult {
+			assert.Equal(t, "$facet", pipeline[len(pipeline)-1][0].Key)
+			return mongo.NewSingleResultFromDocument(bson.D{
+				bson.E{Key: "items", Value: bson.A{
+					bson.D{bson.E{Key: "name", Value: "Peter"}},
+				}},
+				bson.E{Key: "total", Value: bson.A{
+					bson.D{bson.E{Key: "count", Value: int64(11)}},
+				}},
+			}, nil, nil)
+		},
+	}
+	restore := mgo.SetDatastore(mockDB)
+	defer restore()
+
+	// Act
+	page, err := mgo.PaginatePipe(context.Background(), &testAggregate{CollectionName: "users"}, nil, 2, 10)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "Peter", page.Items[0].Name)
+	assert.Equal(t, int64(11), page.Total)
+	assert.Equal(t, int64(2), page.TotalPages)
+}
+
+func TestPaginateCursor(t *testing.T) {
+	users := []any{
+		testUser{ID: bson.NewObjectID(), Name: "Alice", Age: 20},
+		testUser{ID: bson.NewObjectID(), Name: "Bob", Age: 25},
+		testUser{ID: bson.NewObjectID(), Name: "Peter", Age: 30},
+	}
+	var lastFilter any
+	mockDB := &mgo.MockDatastore{
+		OnFind: func(
+			_ context.Context, _ string, filter any, _ ...options.Lister[options.FindOptions],
+		) (*mongo.Cursor, error) {
+			lastFilter = filter
+			return mongo.NewCursorFromDocuments(users, nil, nil)
+		},
+	}
+	restore := mgo.SetDatastore(mockDB)
+	defer restore()
+	query := mgo.CursorQuery{SortField: "age", Size: 2}
+
+	t.Run("First page returns a cursor", func(t *testing.T) {
+		page, err := mgo.PaginateCursor(context.Background(), &testUser{}, nil, query)
+
+		require.NoError(t, err)
+		assert.Len(t, page.Items, 2)
+		assert.True(t, page.HasMore)
+		assert.NotEmpty(t, page.NextCursor)
+		assert.Equal(t, bson.D{}, lastFilter)
+	})
+
+	t.Run("Next page filters after the last item", func(t *testing.T) {
+		first, err := mgo.PaginateCursor(context.Background(), &testUser{}, nil, query)
+		require.NoError(t, err)
+
+		next := query
+		next.Cursor = first.NextCursor
+		_, err = mgo.PaginateCursor(
+			context.Background(), &testUser{}, bson.D{bson.E{Key: "name", Value: bson.D{}}}, next,
+		)
+
+		require.NoError(t, err)
+		filter, ok := lastFilter.(bson.D)
+		require.True(t, ok)
+		assert.Equal(t, "$and", filter[0].Key)
+	})
+
+	t.Run("Rejects tampered cursor", func(t *testing.T) {
+		first, err := mgo.PaginateCursor(context.Background(), &testUser{}, nil, query)
+		require.NoError(t, err)
+
+		tampered := query
+		replacement := "A"
+		if first.NextCursor[0] == 'A' {
+			replacement = "B"
+		}
+		tampered.Cursor = replacement + first.NextCursor[1:]
+		_, err = mgo.PaginateCursor(context.Background(), &testUser{}, nil, tampered)
+
+		require.ErrorIs(t, err, mgo.ErrInvalidCursor)
+		assert.Equal(t, codes.InvalidArgument, mgo.ToStatus(err).Code())
+	})
+
+	t.Run("Rejects cursor issued for another sort", func(t *testing.T) {
+		first, err := mgo.PaginateCursor(context.Background(), &testUser{}, nil, query)
+		require.NoError(t, err)
+
+		other := query
+		other.Cursor = first.NextCursor
+		other.Descending = true
+		_, err = mgo.PaginateCursor(context.Background(), &testUser{}, nil, other)
+
+		require.ErrorIs(t, err, mgo.ErrInvalidCursor)
+	})
+
+	t.Run("Rejects cursor signed with another secret", func(t *testing.T) {
+		mgo.SetCursorSecret(bytes.Repeat([]byte{1}, 32))
+		first, err := mgo.PaginateCursor(context.Background(), &testUser{}, nil, query)
+		require.NoError(t, err)
+
+		mgo.SetCursorSecret(bytes.Repeat([]byte{2}, 32))
+		next := query
+		next.Cursor = first.NextCursor
+		_, err = mgo.PaginateCursor(context.Background(), &testUser{}, nil, next)
+
+		require.ErrorIs(t, err, mgo.ErrInvalidCursor)
+	})
+
+	t.Run("Short secret panics", func(t *testing.T) {
+		assert.Panics(t, func() { mgo.SetCursorSecret([]byte("too short")) })
+	})
+}
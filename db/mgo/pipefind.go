@@ -3,6 +3,7 @@ package mgo
 import (
 	"context"
 	"fmt"
+	"reflect"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -19,13 +20,27 @@ func PipeFindByPipeline[T any](
 	pipeline mongo.Pipeline,
 	limit uint16,
 ) ([]T, error) {
-	if dataStore == nil {
+	return PipeFindByPipelineOn[T](ctx, defaultDB, collectionName, pipeline, limit)
+}
+
+// PipeFindByPipelineOn is like PipeFindByPipeline but runs the pipeline on db.
+func PipeFindByPipelineOn[T any](
+	ctx context.Context,
+	db *DB,
+	collectionName string,
+	pipeline mongo.Pipeline,
+	limit uint16,
+) ([]T, error) {
+	store := db.datastore()
+	if store == nil {
 		return nil, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "pipeFindByPipeline", pipeline)
+	_, span := store.startTraceSpan(ctx, collectionName, "pipeFindByPipeline", pipeline)
 	defer span.End()
 
-	sortCursor, err := dataStore.PipeFind(ctx, collectionName, pipeline)
+	sortCursor, err := retryValue(ctx, span, true, func() (*mongo.Cursor, error) {
+		return store.PipeFind(ctx, collectionName, pipeline)
+	})
 	if err != nil {
 		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
@@ -42,29 +57,53 @@ func PipeFindByPipeline[T any](
 func PipeFind[T MgoAggregate](
 	ctx context.Context, aggr T, filter bson.M, limit uint16,
 ) ([]T, error) {
-	return PipeFindByPipeline[T](ctx, aggr.C(), aggr.GetPipeline(filter), limit)
+	return PipeFindOn(ctx, defaultDB, aggr, filter, limit)
+}
+
+// PipeFindOn is like PipeFind but runs the pipeline on db.
+func PipeFindOn[T MgoAggregate](
+	ctx context.Context, db *DB, aggr T, filter bson.M, limit uint16,
+) ([]T, error) {
+	return PipeFindByPipelineOn[T](ctx, db, aggr.C(), aggr.GetPipeline(filter), limit)
 }
 
 func PipeFindOne[T MgoAggregate](ctx context.Context, aggr T, filter bson.M) error {
-	if dataStore == nil {
+	return PipeFindOneOn(ctx, defaultDB, aggr, filter)
+}
+
+// PipeFindOneOn is like PipeFindOne but runs the pipeline on db.
+func PipeFindOneOn[T MgoAggregate](ctx context.Context, db *DB, aggr T, filter bson.M) error {
+	store := db.datastore()
+	if store == nil {
 		return ErrNotConnected
 	}
 	pipeline := aggr.GetPipeline(filter)
 	collectionName := aggr.C()
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "pipeFindOne", pipeline)
+	_, span := store.startTraceSpan(ctx, collectionName, "pipeFindOne", pipeline)
 	defer span.End()
-	result := dataStore.PipeFindOne(ctx, collectionName, pipeline)
-	raw, err := result.Raw()
+	raw, err := retryValue(ctx, span, true, func() (bson.Raw, error) {
+		return store.PipeFindOne(ctx, collectionName, pipeline).Raw()
+	})
 	if err != nil {
 		return spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
 	}
+	if raw, err = openDocument(ctx, reflect.TypeOf(aggr), raw); err != nil {
+		return spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
 	if unmarshaler, ok := any(&aggr).(bson.Unmarshaler); ok {
 		return spanErrorHandler(unmarshaler.UnmarshalBSON(raw), span)
 	}
-	return spanErrorHandler(result.Decode(&aggr), span)
+	return spanErrorHandler(bson.Unmarshal(raw, &aggr), span)
 }
 
 func (m *mongoStore) PipeFind(ctx context.Context, collection string, pipeline mongo.Pipeline) (*mongo.Cursor, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline, err = m.prefixPipeline(pipeline); err != nil {
+		return nil, err
+	}
 	c := m.getCollection(collection)
 	sortCursor, err := c.Aggregate(ctx, pipeline)
 	if err != nil {
@@ -74,6 +113,13 @@ func (m *mongoStore) PipeFind(ctx context.Context, collection string, pipeline m
 }
 
 func (m *mongoStore) PipeFindOne(ctx context.Context, collection string, pipeline mongo.Pipeline) *mongo.SingleResult {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if pipeline, err = m.prefixPipeline(pipeline); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
 	c := m.getCollection(collection)
 	sortCursor, err := c.Aggregate(ctx, pipeline)
 	if err != nil {
@@ -0,0 +1,239 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PipelineBuilder builds an aggregation pipeline stage by stage, e.g. in GetPipeline:
+//
+//	return mgo.NewPipeline().
+//		Match(q).
+//		Lookup("orders", "_id", "user_id", "orders").
+//		Unwind("orders", false).
+//		Group("$_id", bson.D{{Key: "spent", Value: bson.D{{Key: "$sum", Value: "$orders.amount"}}}}).
+//		Sort(bson.D{{Key: "spent", Value: -1}}).
+//		Limit(10).
+//		Build()
+type PipelineBuilder struct {
+	stages mongo.Pipeline
+}
+
+// NewPipeline returns an empty PipelineBuilder, or one starting with stages.
+func NewPipeline(stages ...bson.D) *PipelineBuilder {
+	return &PipelineBuilder{stages: append(mongo.Pipeline{}, stages...)}
+}
+
+// Stage appends a stage the builder has no method for, e.g. bson.D{{Key: "$sample", Value: ...}}.
+func (p *PipelineBuilder) Stage(stage bson.D) *PipelineBuilder {
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// Match appends a $match stage keeping the documents that match filter.
+func (p *PipelineBuilder) Match(filter any) *PipelineBuilder {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	return p.Stage(bson.D{bson.E{Key: "$match", Value: filter}})
+}
+
+// Lookup appends a $lookup stage joining the documents of from whose foreignField equals
+// localField into the array field as.
+func (p *PipelineBuilder) Lookup(from, localField, foreignField, as string) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$lookup", Value: bson.D{
+		bson.E{Key: "from", Value: from},
+		bson.E{Key: "localField", Value: localField},
+		bson.E{Key: "foreignField", Value: foreignField},
+		bson.E{Key: "as", Value: as},
+	}}})
+}
+
+// Unwind appends an $unwind stage emitting one document per element of the array at path,
+// with or without the leading "$". Documents whose array is missing or empty are dropped
+// unless preserveEmpty is set.
+func (p *PipelineBuilder) Unwind(path string, preserveEmpty bool) *PipelineBuilder {
+	if !strings.HasPrefix(path, "$") {
+		path = "$" + path
+	}
+	if !preserveEmpty {
+		return p.Stage(bson.D{bson.E{Key: "$unwind", Value: path}})
+	}
+	return p.Stage(bson.D{bson.E{Key: "$unwind", Value: bson.D{
+		bson.E{Key: "path", Value: path},
+		bson.E{Key: "preserveNullAndEmptyArrays", Value: true},
+	}}})
+}
+
+// Group appends a $group stage grouping by the expression id, e.g. "$city", with the
+// accumulated fields, e.g. bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}.
+func (p *PipelineBuilder) Group(id any, fields bson.D) *PipelineBuilder {
+	group := append(bson.D{bson.E{Key: "_id", Value: id}}, fields...)
+	return p.Stage(bson.D{bson.E{Key: "$group", Value: group}})
+}
+
+// Project appends a $project stage.
+func (p *PipelineBuilder) Project(projection any) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$project", Value: projection}})
+}
+
+// Sort appends a $sort stage.
+func (p *PipelineBuilder) Sort(sort bson.D) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$sort", Value: sort}})
+}
+
+// Skip appends a $skip stage.
+func (p *PipelineBuilder) Skip(n int64) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$skip", Value: n}})
+}
+
+// Limit appends a $limit stage.
+func (p *PipelineBuilder) Limit(n int64) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$limit", Value: n}})
+}
+
+// Count appends a $count stage producing a single document {field: <number of documents>}.
+func (p *PipelineBuilder) Count(field string) *PipelineBuilder {
+	return p.Stage(bson.D{bson.E{Key: "$count", Value: field}})
+}
+
+// Facet runs sub on the incoming documents and stores its results in the array field name of
+// a single output document. Consecutive calls add facets to the same $facet stage:
+//
+//	mgo.NewPipeline().Match(q).
+//		Facet("by_city", mgo.NewPipeline().Group("$city", nil)).
+//		Facet("total", mgo.NewPipeline().Count("count"))
+//
+// Use PipeFacet to decode the output document into a struct.
+func (p *PipelineBuilder) Facet(name string, sub *PipelineBuilder) *PipelineBuilder {
+	stages := make(bson.A, len(sub.stages))
+	for i, stage := range sub.stages {
+		stages[i] = stage
+	}
+	facet := bson.E{Key: name, Value: stages}
+	if n := len(p.stages); n > 0 && len(p.stages[n-1]) == 1 && p.stages[n-1][0].Key == "$facet" {
+		facets, _ := p.stages[n-1][0].Value.(bson.D)
+		p.stages[n-1] = bson.D{bson.E{Key: "$facet", Value: append(facets, facet)}}
+		return p
+	}
+	return p.Stage(bson.D{bson.E{Key: "$facet", Value: bson.D{facet}}})
+}
+
+// Build returns the pipeline, ready for PipeFindByPipeline or a GetPipeline method.
+func (p *PipelineBuilder) Build() mongo.Pipeline {
+	return p.stages
+}
+
+// String renders the pipeline as relaxed Extended JSON.
+func (p *PipelineBuilder) String() string {
+	return formatStatement(p.stages)
+}
+
+// PipeFacet runs a pipeline ending in a $facet stage and decodes its output document into R,
+// a struct with one slice field per facet:
+//
+//	type Stats struct {
+//		ByCity []CityCount `bson:"by_city"`
+//		Total  []struct {
+//			Count int64 `bson:"count"`
+//		} `bson:"total"`
+//	}
+//	stats, err := mgo.PipeFacet[Stats](ctx, "users", pipeline)
+func PipeFacet[R any](ctx context.Context, collectionName string, pipeline mongo.Pipeline) (R, error) {
+	return PipeFacetOn[R](ctx, defaultDB, collectionName, pipeline)
+}
+
+// PipeFacetOn is like PipeFacet but runs the pipeline on db.
+func PipeFacetOn[R any](ctx context.Context, db *DB, collectionName string, pipeline mongo.Pipeline) (R, error) {
+	var result R
+	store := db.datastore()
+	if store == nil {
+		return result, ErrNotConnected
+	}
+	_, span := store.startTraceSpan(ctx, collectionName, "pipeFacet", pipeline)
+	defer span.End()
+
+	raw, err := retryValue(ctx, span, true, func() (bson.Raw, error) {
+		return store.PipeFindOne(ctx, collectionName, pipeline).Raw()
+	})
+	if err != nil {
+		return result, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	if result, err = decodeRaw[R](ctx, raw); err != nil {
+		return result, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	return result, spanErrorHandler(nil, span)
+}
+
+// facetPage is the output document of the $facet stage appended by PaginateByPipeline.
+type facetPage struct {
+	Items []bson.Raw `bson:"items"`
+	Total []struct {
+		Count int64 `bson:"count"`
+	} `bson:"total"`
+}
+
+// PaginateByPipeline runs pipeline and returns the given 1-based page of its results, decoded
+// into T. Items and total count are computed in a single round trip with a $facet stage
+// appended to the pipeline. A page below 1 is treated as 1 and a non-positive pageSize
+// defaults to 20.
+func PaginateByPipeline[T any](
+	ctx context.Context, collectionName string, pipeline mongo.Pipeline, page, pageSize int64,
+) (*Page[T], error) {
+	return PaginateByPipelineOn[T](ctx, defaultDB, collectionName, pipeline, page, pageSize)
+}
+
+// PaginateByPipelineOn is like PaginateByPipeline but runs the pipeline on db.
+func PaginateByPipelineOn[T any](
+	ctx context.Context, db *DB, collectionName string, pipeline mongo.Pipeline, page, pageSize int64,
+) (*Page[T], error) {
+	return paginateByPipeline[T](ctx, db, collectionName, pipeline, page, pageSize, "paginateByPipeline")
+}
+
+func paginateByPipeline[T any](
+	ctx context.Context, db *DB, collectionName string, pipeline mongo.Pipeline, page, pageSize int64,
+	operation string,
+) (*Page[T], error) {
+	store := db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	page, pageSize, skip := normalizePage(page, pageSize)
+	facet := NewPipeline().
+		Facet("items", NewPipeline().Skip(skip).Limit(pageSize)).
+		Facet("total", NewPipeline().Count("count")).
+		Build()
+	pipeline = NewPipeline(pipeline...).Stage(facet[0]).Build()
+	_, span := store.startTraceSpan(ctx, collectionName, operation, pipeline)
+	defer span.End()
+
+	raw, err := retryValue(ctx, span, true, func() (bson.Raw, error) {
+		return store.PipeFindOne(ctx, collectionName, pipeline).Raw()
+	})
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	result, err := decodeRaw[facetPage](ctx, raw)
+	if err != nil {
+		return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+	}
+	items := make([]T, 0, len(result.Items))
+	for _, item := range result.Items {
+		t, err := decodeRaw[T](ctx, item)
+		if err != nil {
+			return nil, spanErrorHandler(fmt.Errorf("%w: %w", ErrReadFailed, err), span)
+		}
+		items = append(items, t)
+	}
+	var total int64
+	if len(result.Total) > 0 {
+		total = result.Total[0].Count
+	}
+	span.SetAttributes(attribute.Int64("db.total_documents", total))
+	return newPage(items, total, page, pageSize), spanErrorHandler(nil, span)
+}
@@ -0,0 +1,118 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestPipelineBuilder(t *testing.T) {
+	t.Run("Appends stages in order", func(t *testing.T) {
+		// Act
+		pipeline := mgo.NewPipeline().
+			Match(bson.D{{Key: "active", Value: true}}).
+			Lookup("orders", "_id", "user_id", "orders").
+			Unwind("orders", false).
+			Unwind("$tags", true).
+			Group("$city", bson.D{{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}).
+			Sort(bson.D{{Key: "count", Value: -1}}).
+			Limit(5).
+			Build()
+
+		// Assert
+		assert.Equal(t, mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "active", Value: true}}}},
+			{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"}, {Key: "localField", Value: "_id"},
+				{Key: "foreignField", Value: "user_id"}, {Key: "as", Value: "orders"},
+			}}},
+			{{Key: "$unwind", Value: "$orders"}},
+			{{Key: "$unwind", Value: bson.D{
+				{Key: "path", Value: "$tags"}, {Key: "preserveNullAndEmptyArrays", Value: true},
+			}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$city"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}}}},
+			{{Key: "$limit", Value: int64(5)}},
+		}, pipeline)
+	})
+
+	t.Run("Consecutive facets share a stage", func(t *testing.T) {
+		// Act
+		s := mgo.NewPipeline().
+			Match(nil).
+			Facet("items", mgo.NewPipeline().Skip(10).Limit(10)).
+			Facet("total", mgo.NewPipeline().Count("count")).
+			String()
+
+		// Assert
+		assert.Equal(t,
+			`[{"$match":{}},{"$facet":{"items":[{"$skip":10},{"$limit":10}],"total":[{"$count":"count"}]}}]`, s)
+	})
+}
+
+func TestPipelineHelpers(t *testing.T) {
+	// Arrange
+	restore := mgo.SetDatastore(mgo.NewMemoryDatastore())
+	defer restore()
+	seedAccounts(t, 30, 20, 40, 25)
+	ctx := context.Background()
+	collection := (&memoryAccount{}).C()
+	byAge := mgo.NewPipeline().Sort(bson.D{{Key: "age", Value: 1}})
+
+	t.Run("PipeFindByPipeline", func(t *testing.T) {
+		// Act
+		pipeline := mgo.NewPipeline().Match(bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 20}}}}).
+			Sort(bson.D{{Key: "age", Value: -1}}).
+			Build()
+		accounts, err := mgo.PipeFindByPipeline[memoryAccount](ctx, collection, pipeline, 0)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, accounts, 3)
+		assert.Equal(t, 40, accounts[0].Age)
+	})
+
+	t.Run("PipeFacet decodes each facet", func(t *testing.T) {
+		// Arrange
+		type stats struct {
+			Oldest []memoryAccount `bson:"oldest"`
+			Total  []struct {
+				Count int64 `bson:"count"`
+			} `bson:"total"`
+		}
+		pipeline := mgo.NewPipeline().
+			Facet("oldest", mgo.NewPipeline().Sort(bson.D{{Key: "age", Value: -1}}).Limit(1)).
+			Facet("total", mgo.NewPipeline().Count("count")).
+			Build()
+
+		// Act
+		result, err := mgo.PipeFacet[stats](ctx, collection, pipeline)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, result.Oldest, 1)
+		assert.Equal(t, 40, result.Oldest[0].Age)
+		require.Len(t, result.Total, 1)
+		assert.Equal(t, int64(4), result.Total[0].Count)
+	})
+
+	t.Run("PaginateByPipeline", func(t *testing.T) {
+		// Act
+		page, err := mgo.PaginateByPipeline[*memoryAccount](ctx, collection, byAge.Build(), 2, 3)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, 40, page.Items[0].Age)
+		assert.Equal(t, int64(4), page.Total)
+		assert.Equal(t, int64(2), page.TotalPages)
+	})
+}
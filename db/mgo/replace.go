@@ -3,32 +3,94 @@ package mgo
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+// ReplaceOne replaces the first document matching filter with doc.
+// For Versioner documents the filter also requires the stored version to equal doc's;
+// the version is incremented on success and ErrVersionConflict is returned if nothing matched.
 func ReplaceOne[T DocInter](
 	ctx context.Context, doc T, filter any,
 	opts ...options.Lister[options.ReplaceOptions],
 ) (int64, error) {
-	if dataStore == nil {
+	return ReplaceOneOn(ctx, defaultDB, doc, filter, opts...)
+}
+
+// ReplaceOneOn is like ReplaceOne but writes to db.
+func ReplaceOneOn[T DocInter](
+	ctx context.Context, db *DB, doc T, filter any,
+	opts ...options.Lister[options.ReplaceOptions],
+) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	_, span := dataStore.startTraceSpan(ctx, doc.C(), "replaceOne", filter)
+	if err := beforeSave(ctx, doc); err != nil {
+		return 0, err
+	}
+	filter, err := sealFilter(ctx, doc, filter)
+	if err != nil {
+		return 0, err
+	}
+	if stamped, ok := any(doc).(Timestamper); ok {
+		stamped.SetUpdatedAt(time.Now())
+	}
+	versioned, isVersioned := any(doc).(Versioner)
+	var current int64
+	if isVersioned {
+		current = versioned.GetVersion()
+		filter = andFilter(filter, versionCondition(current))
+		versioned.SetVersion(current + 1)
+	}
+	_, span := store.startTraceSpan(ctx, doc.C(), "replaceOne", filter)
 	defer span.End()
-	result, err := dataStore.ReplaceOne(ctx, doc.C(), filter, doc, opts...)
+	replacement, err := sealDocument(ctx, doc)
+	if err != nil {
+		if isVersioned {
+			versioned.SetVersion(current)
+		}
+		return 0, spanErrorHandler(err, span)
+	}
+	before := auditBefore(ctx, store, doc.C(), filter)
+	// A retried versioned replacement could report a conflict with its own first attempt.
+	result, err := retryValue(ctx, span, !isVersioned && pinsSingleID(filter), func() (*mongo.UpdateResult, error) {
+		return store.ReplaceOne(ctx, doc.C(), filter, replacement, opts...)
+	})
 	if err != nil {
+		if isVersioned {
+			versioned.SetVersion(current)
+		}
 		return 0, spanErrorHandler(fmt.Errorf("%w: %w", ErrWriteFailed, err), span)
 	}
+	if isVersioned && result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		versioned.SetVersion(current)
+		return 0, spanErrorHandler(
+			fmt.Errorf("%w: %s at version %d", ErrVersionConflict, doc.C(), current), span,
+		)
+	}
+	invalidateCache(ctx, doc)
 	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", result.MatchedCount))
-	return result.UpsertedCount, spanErrorHandler(nil, span)
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return 0, spanErrorHandler(nil, span)
+	}
+	recordAudit(ctx, auditWrite{
+		model: doc, id: result.UpsertedID, before: before, after: auditDocument(doc),
+		collection: doc.C(), operation: "replaceOne",
+	})
+	return result.UpsertedCount, spanErrorHandler(afterSave(ctx, doc), span)
 }
 
 func (m *mongoStore) ReplaceOne(
 	ctx context.Context, collection string, filter any, replacement any,
 	opts ...options.Lister[options.ReplaceOptions],
 ) (*mongo.UpdateResult, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
 	return m.getCollection(collection).ReplaceOne(ctx, filter, replacement, opts...)
 }
@@ -0,0 +1,165 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy controls how the helpers retry operations failing with a transient error:
+// a network failure, a timeout or a primary step-down. Attempts are separated by an
+// exponential backoff with full jitter, i.e. a random delay up to BaseDelay * 2^(attempt-1),
+// capped at MaxDelay.
+//
+// Idempotent operations are retried on any transient error: reads, DeleteMany, and the deletes,
+// replacements and updates whose filter pins a single _id, where updates are made only of
+// operators such as $set and $unset (UpdateMany included). Other writes, e.g. inserts by Save,
+// bulk writes, $inc or $push updates and single-document writes filtering on other fields, are
+// only retried when the driver reports that no write was performed, since retrying them after
+// a lost acknowledgement could apply them twice or to a second document.
+// Operations inside WithTransaction are never retried on their own; the transaction is.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Below 2 retries are disabled.
+	MaxAttempts int
+	// BaseDelay is the maximum delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called: 3 attempts, 100ms base delay, 2s cap.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+var retryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy replaces the retry policy of the helpers. RetryPolicy{} disables retries.
+// It returns a function restoring the previous policy.
+func SetRetryPolicy(p RetryPolicy) (restore func()) {
+	original := retryPolicy
+	retryPolicy = p
+	return func() {
+		retryPolicy = original
+	}
+}
+
+// delay returns the randomized delay before the given retry, starting at 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	ceiling := p.BaseDelay << min(retry-1, 30)
+	if ceiling <= 0 || (p.MaxDelay > 0 && ceiling > p.MaxDelay) {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// retry runs op until it succeeds or fails with an error that must not be retried under the
+// current policy. Each retry adds a "retry" event to span, and the number of retries of op is
+// recorded as db.retry.count.
+func retry(ctx context.Context, span trace.Span, idempotent bool, op func() error) error {
+	policy := retryPolicy
+	err := op()
+	retries := 0
+	for ; err != nil && retries+1 < policy.MaxAttempts && shouldRetry(ctx, err, idempotent); retries++ {
+		delay := policy.delay(retries + 1)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("db.retry.attempt", retries+2),
+			attribute.String("db.retry.delay", delay.String()),
+			attribute.String("error", err.Error()),
+		))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		err = op()
+	}
+	if retries > 0 {
+		span.SetAttributes(attribute.Int("db.retry.count", retries))
+	}
+	return err
+}
+
+// retryValue is retry for operations returning a value.
+func retryValue[R any](ctx context.Context, span trace.Span, idempotent bool, op func() (R, error)) (R, error) {
+	var result R
+	err := retry(ctx, span, idempotent, func() error {
+		var err error
+		result, err = op()
+		return err
+	})
+	return result, err
+}
+
+func shouldRetry(ctx context.Context, err error, idempotent bool) bool {
+	if ctx.Err() != nil || mongo.SessionFromContext(ctx) != nil || !isTransientError(err) {
+		return false
+	}
+	if idempotent {
+		return true
+	}
+	var le mongo.LabeledError
+	return errors.As(err, &le) && le.HasErrorLabel("NoWritesPerformed")
+}
+
+// idempotentUpdateOperators apply the same change when run twice.
+var idempotentUpdateOperators = map[string]bool{
+	"$set": true, "$unset": true, "$setOnInsert": true, "$min": true, "$max": true,
+	"$addToSet": true, "$pull": true, "$pullAll": true, "$currentDate": true, "$rename": true,
+}
+
+// pinsSingleID reports whether filter selects a single document by an equality on _id.
+func pinsSingleID(filter any) bool {
+	var id any
+	found := false
+	switch f := filter.(type) {
+	case bson.D:
+		for _, e := range f {
+			if e.Key == "_id" {
+				id, found = e.Value, true
+			}
+		}
+	case bson.M:
+		id, found = f["_id"]
+	}
+	if !found {
+		return false
+	}
+	var keys []string
+	switch v := id.(type) {
+	case bson.D:
+		for _, e := range v {
+			keys = append(keys, e.Key)
+		}
+	case bson.M:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	// A document _id is either a compound value or {$eq: value}; other operators such as
+	// $in or $gt can match several documents.
+	for _, k := range keys {
+		if strings.HasPrefix(k, "$") && (k != "$eq" || len(keys) > 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdempotentUpdate reports whether update only uses idempotent operators.
+func isIdempotentUpdate(update bson.D) bool {
+	for _, e := range update {
+		if !idempotentUpdateOperators[e.Key] {
+			return false
+		}
+	}
+	return true
+}
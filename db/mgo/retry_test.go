@@ -0,0 +1,200 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// attributeSpan records the attributes set on it.
+type attributeSpan struct {
+	attributes map[attribute.Key]attribute.Value
+	noop.Span
+}
+
+func (s *attributeSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attributes[a.Key] = a.Value
+	}
+}
+
+// failingStore returns a datastore whose deletes, updates and saves fail with errs in turn
+// before succeeding, and a pointer to the number of calls.
+func failingStore(span trace.Span, errs ...error) (*mgo.MockDatastore, *int) {
+	calls := 0
+	next := func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}
+	return &mgo.MockDatastore{
+		OnStartTraceSpan: func(ctx context.Context, _, _ string, _ any) (context.Context, trace.Span) {
+			return ctx, span
+		},
+		OnDeleteOne: func(context.Context, string, bson.D) (int64, error) {
+			if err := next(); err != nil {
+				return 0, err
+			}
+			return 1, nil
+		},
+		OnUpdateOne: func(context.Context, string, bson.D, bson.D) (int64, error) {
+			if err := next(); err != nil {
+				return 0, err
+			}
+			return 1, nil
+		},
+		OnSave: func(_ context.Context, doc mgo.DocInter) (mgo.DocInter, error) {
+			return doc, next()
+		},
+	}, &calls
+}
+
+func TestRetryPolicy(t *testing.T) {
+	defer mgo.SetRetryPolicy(mgo.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})()
+	ctx := context.Background()
+	stepDown := mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}
+	notPerformed := mongo.CommandError{Code: 189, Name: "PrimarySteppedDown", Labels: []string{"NoWritesPerformed"}}
+	set := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "x"}}}}
+	inc := bson.D{{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}}}
+	byID := bson.D{{Key: "_id", Value: bson.NewObjectID()}}
+	byName := bson.D{{Key: "name", Value: "x"}}
+
+	tests := []struct {
+		op        func() error
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name: "Idempotent write succeeds after transient errors", errs: []error{stepDown, stepDown}, wantCalls: 3,
+			op: func() error { _, err := mgo.DeleteOne(ctx, &testUser{}, byID); return err },
+		},
+		{
+			name: "Attempts are bounded", errs: []error{stepDown, stepDown, stepDown}, wantCalls: 3, wantErr: true,
+			op: func() error { _, err := mgo.DeleteOne(ctx, &testUser{}, byID); return err },
+		},
+		{
+			name: "Permanent errors are not retried", errs: []error{errors.New("bad filter")}, wantCalls: 1, wantErr: true,
+			op: func() error { _, err := mgo.DeleteOne(ctx, &testUser{}, byID); return err },
+		},
+		{
+			name: "Hard delete by id is retried", errs: []error{stepDown}, wantCalls: 2,
+			op: func() error { _, err := mgo.DeleteById(ctx, &testUser{}); return err },
+		},
+		{
+			name: "Delete with a non-unique filter is not retried", errs: []error{stepDown}, wantCalls: 1, wantErr: true,
+			op: func() error { _, err := mgo.DeleteOne(ctx, &testUser{}, byName); return err },
+		},
+		{
+			name: "Update with a non-unique filter is not retried", errs: []error{stepDown}, wantCalls: 1, wantErr: true,
+			op: func() error { _, err := mgo.UpdateOne(ctx, &testUser{}, byName, set); return err },
+		},
+		{
+			name: "Write with an _id operator is not retried", errs: []error{stepDown}, wantCalls: 1, wantErr: true,
+			op: func() error {
+				in := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{1, 2}}}}}
+				_, err := mgo.DeleteOne(ctx, &testUser{}, in)
+				return err
+			},
+		},
+		{
+			name: "Non-unique filter is retried when no write was performed", errs: []error{notPerformed}, wantCalls: 2,
+			op: func() error { _, err := mgo.DeleteOne(ctx, &testUser{}, byName); return err },
+		},
+		{
+			name: "Idempotent update is retried", errs: []error{stepDown}, wantCalls: 2,
+			op: func() error { _, err := mgo.UpdateOne(ctx, &testUser{}, byID, set); return err },
+		},
+		{
+			name: "Non-idempotent update is not retried", errs: []error{stepDown}, wantCalls: 1, wantErr: true,
+			op: func() error { _, err := mgo.UpdateOne(ctx, &testUser{}, byID, inc); return err },
+		},
+		{
+			name: "Insert is not retried", errs: []error{stepDown}, wantCalls: 1, wantErr: true,
+			op: func() error { _, err := mgo.Save(ctx, &testUser{Name: "x"}); return err },
+		},
+		{
+			name: "Insert is retried when no write was performed", errs: []error{notPerformed}, wantCalls: 2,
+			op: func() error { _, err := mgo.Save(ctx, &testUser{Name: "x"}); return err },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			store, calls := failingStore(noop.Span{}, tt.errs...)
+			restore := mgo.SetDatastore(store)
+			defer restore()
+
+			// Act
+			err := tt.op()
+
+			// Assert
+			assert.Equal(t, tt.wantCalls, *calls)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Retries are recorded on the span", func(t *testing.T) {
+		// Arrange
+		span := &attributeSpan{attributes: map[attribute.Key]attribute.Value{}}
+		store, _ := failingStore(span, stepDown, stepDown)
+		restore := mgo.SetDatastore(store)
+		defer restore()
+
+		// Act
+		_, err := mgo.DeleteOne(ctx, &testUser{}, byID)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), span.attributes["db.retry.count"].AsInt64())
+	})
+
+	t.Run("Cancelled context stops retrying", func(t *testing.T) {
+		// Arrange
+		store, calls := failingStore(noop.Span{}, stepDown, stepDown)
+		restore := mgo.SetDatastore(store)
+		defer restore()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		// Act
+		_, err := mgo.DeleteOne(cancelled, &testUser{}, byID)
+
+		// Assert
+		require.Error(t, err)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("Zero policy disables retries", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetRetryPolicy(mgo.RetryPolicy{})()
+		store, calls := failingStore(noop.Span{}, stepDown)
+		restore := mgo.SetDatastore(store)
+		defer restore()
+
+		// Act
+		_, err := mgo.DeleteOne(ctx, &testUser{}, byID)
+
+		// Assert
+		require.Error(t, err)
+		assert.Equal(t, 1, *calls)
+	})
+}
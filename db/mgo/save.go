@@ -5,34 +5,69 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.opentelemetry.io/otel/attribute"
 )
 
 func Save[T DocInter](ctx context.Context, doc T) (T, error) {
+	return SaveOn(ctx, defaultDB, doc)
+}
+
+// SaveOn is like Save but inserts doc into db.
+func SaveOn[T DocInter](ctx context.Context, db *DB, doc T) (T, error) {
 	var zero T
-	if dataStore == nil {
+	// Reject a nil model before its hooks and setters dereference it.
+	if v := reflect.ValueOf(doc); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return zero, fmt.Errorf("%w: %w", ErrInvalidDocument, errors.New("document cannot be nil"))
+	}
+	store := db.datastore()
+	if store == nil {
 		return zero, ErrNotConnected
 	}
+	if err := beforeSave(ctx, doc); err != nil {
+		return zero, err
+	}
+	if stamped, ok := any(doc).(Timestamper); ok {
+		now := time.Now()
+		stamped.SetCreatedAt(now)
+		stamped.SetUpdatedAt(now)
+	}
+	if versioned, ok := any(doc).(Versioner); ok && versioned.GetVersion() == 0 {
+		versioned.SetVersion(1)
+	}
 	collectionName := doc.C()
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "save", nil)
+	_, span := store.startTraceSpan(ctx, collectionName, "save", nil)
 	defer span.End()
-	newDoc, err := dataStore.Save(ctx, doc)
+	sealed, err := sealModel(ctx, doc)
+	if err != nil {
+		return zero, spanErrorHandler(err, span)
+	}
+	newDoc, err := retryValue(ctx, span, false, func() (DocInter, error) {
+		return store.Save(ctx, sealed)
+	})
 	if err != nil {
 		return zero, spanErrorHandler(fmt.Errorf("%w: %w", ErrWriteFailed, err), span)
 	}
-	result, ok := newDoc.(T)
+	result, ok := unsealModel(newDoc).(T)
 	if !ok {
 		return zero, spanErrorHandler(fmt.Errorf("%w: failed to cast to %T", ErrWriteFailed, doc), span)
 	}
 	if oid, ok := result.GetId().(bson.ObjectID); ok {
 		span.SetAttributes(attribute.String("db.inserted_id", oid.Hex()))
 	}
-	return result, spanErrorHandler(nil, span)
+	recordAudit(ctx, auditWrite{
+		model: doc, id: result.GetId(), after: auditDocument(doc), collection: collectionName, operation: "save",
+	})
+	return result, spanErrorHandler(afterSave(ctx, result), span)
 }
 
 func (m *mongoStore) Save(ctx context.Context, doc DocInter) (DocInter, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return doc, err
+	}
 	// 1. Restore the nil check for robustness.
 	if v := reflect.ValueOf(doc); v.Kind() == reflect.Ptr && v.IsNil() {
 		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, errors.New("document cannot be nil"))
@@ -0,0 +1,362 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	objectIDType = reflect.TypeFor[bson.ObjectID]()
+	rawType      = reflect.TypeFor[bson.Raw]()
+	dType        = reflect.TypeFor[bson.D]()
+)
+
+// WithJSONSchema attaches a $jsonSchema validator derived from model to the collection definition.
+// SyncCollections creates the collection with the validator or updates it with collMod,
+// so that the server also rejects invalid documents written by other tools.
+// See GenerateJSONSchema for how the schema is derived.
+func WithJSONSchema(model any) CollectDefOption {
+	return func(c *collectDef) {
+		c.schemaModel = model
+	}
+}
+
+// GenerateJSONSchema derives a MongoDB $jsonSchema from the bson and validate tags of model,
+// a struct or a pointer to one. Field types map to bsonType, pointers additionally allow null,
+// and fields tagged `bson:",inline"` are merged into their parent. Of the go-playground rules,
+// required, min, max, gte, lte, gt, lt, len and oneof are translated; rules after dive
+// constrain the items of an array. Other rules are only enforced by Validate. Fields tagged
+// `vulpes:"encrypt"` are only checked to be binData.
+func GenerateJSONSchema(model any) (bson.D, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: json schema requires a struct, got %T", ErrInvalidDocument, model)
+	}
+	return newSchemaBuilder().object(t), nil
+}
+
+type schemaBuilder struct {
+	visiting map[reflect.Type]bool
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{visiting: make(map[reflect.Type]bool)}
+}
+
+// object returns the schema of the struct type t.
+func (b *schemaBuilder) object(t reflect.Type) bson.D {
+	schema := bson.D{bson.E{Key: "bsonType", Value: "object"}}
+	// Stop at recursive types; their nested documents are only checked to be objects.
+	if b.visiting[t] {
+		return schema
+	}
+	b.visiting[t] = true
+	defer delete(b.visiting, t)
+
+	properties := bson.D{}
+	required := bson.A{}
+	b.fields(t, &properties, &required)
+	if len(required) > 0 {
+		schema = append(schema, bson.E{Key: "required", Value: required})
+	}
+	if len(properties) > 0 {
+		schema = append(schema, bson.E{Key: "properties", Value: properties})
+	}
+	return schema
+}
+
+func (b *schemaBuilder) fields(t reflect.Type, properties *bson.D, required *bson.A) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, flags, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(flags, "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.fields(ft, properties, required)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		schema, isRequired := b.field(f.Type, f.Tag.Get("validate"))
+		if encrypt, _ := parseEncryptTag(f.Tag.Get("vulpes")); encrypt {
+			schema = encryptedSchema(f.Type)
+		}
+		if isRequired && !strings.Contains(flags, "omitempty") {
+			*required = append(*required, name)
+		}
+		*properties = append(*properties, bson.E{Key: name, Value: schema})
+	}
+}
+
+// field returns the schema of a field of type t with the given validate tag,
+// and whether the tag marks the field as required.
+func (b *schemaBuilder) field(t reflect.Type, tag string) (bson.D, bool) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	rules := strings.Split(tag, ",")
+	var itemRules string
+	if i := slices.Index(rules, "dive"); i >= 0 {
+		itemRules = strings.Join(rules[i+1:], ",")
+		rules = rules[:i]
+	}
+	schema := b.typeSchema(t, itemRules)
+	// The driver stores nil slices and maps as null.
+	nullable = nullable || t.Kind() == reflect.Slice || t.Kind() == reflect.Map
+	if nullable && len(schema) > 0 {
+		switch bsonType := schema[0].Value.(type) {
+		case string:
+			schema[0].Value = bson.A{bsonType, "null"}
+		case bson.A:
+			schema[0].Value = append(bsonType, "null")
+		}
+	}
+	// Zero values pass every rule of an omitempty field, which the server cannot express.
+	if slices.Contains(rules, "omitempty") {
+		return schema, false
+	}
+	for _, rule := range rules {
+		schema = append(schema, ruleSchema(t, rule)...)
+	}
+	return schema, slices.Contains(rules, "required")
+}
+
+// encryptedSchema returns the schema of an encrypted field of type t, whose rules cannot be
+// checked by the server. Nil values are stored in clear.
+func encryptedSchema(t reflect.Type) bson.D {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return bson.D{bson.E{Key: "bsonType", Value: bson.A{"binData", "null"}}}
+	default:
+		return bson.D{bson.E{Key: "bsonType", Value: "binData"}}
+	}
+}
+
+// typeSchema returns the bsonType of t, and the schema of the items of arrays.
+func (b *schemaBuilder) typeSchema(t reflect.Type, itemRules string) bson.D {
+	switch {
+	case t == timeType:
+		return bson.D{bson.E{Key: "bsonType", Value: "date"}}
+	case t == objectIDType:
+		return bson.D{bson.E{Key: "bsonType", Value: "objectId"}}
+	case t == rawType || t == dType:
+		return bson.D{bson.E{Key: "bsonType", Value: "object"}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return bson.D{bson.E{Key: "bsonType", Value: "string"}}
+	case reflect.Bool:
+		return bson.D{bson.E{Key: "bsonType", Value: "bool"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// The driver stores Go integers as int or long depending on their size and value.
+		return bson.D{bson.E{Key: "bsonType", Value: bson.A{"int", "long"}}}
+	case reflect.Float32, reflect.Float64:
+		return bson.D{bson.E{Key: "bsonType", Value: "double"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bson.D{bson.E{Key: "bsonType", Value: "binData"}}
+		}
+		items, _ := b.field(t.Elem(), itemRules)
+		return bson.D{bson.E{Key: "bsonType", Value: "array"}, bson.E{Key: "items", Value: items}}
+	case reflect.Map:
+		return bson.D{bson.E{Key: "bsonType", Value: "object"}}
+	case reflect.Struct:
+		return b.object(t)
+	default:
+		// Interfaces and other kinds accept any value.
+		return bson.D{}
+	}
+}
+
+// ruleSchema translates one go-playground rule, e.g. "max=10", into $jsonSchema keywords for type t.
+func ruleSchema(t reflect.Type, rule string) bson.D {
+	name, param, _ := strings.Cut(rule, "=")
+	kind := schemaKind(t)
+	if name == "oneof" {
+		return oneOfSchema(kind, param)
+	}
+	if name == "required" {
+		if kind == "string" {
+			return bson.D{bson.E{Key: "minLength", Value: int64(1)}}
+		}
+		return nil
+	}
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return nil
+	}
+	switch kind {
+	case "number":
+		switch name {
+		case "min", "gte":
+			return bson.D{bson.E{Key: "minimum", Value: n}}
+		case "max", "lte":
+			return bson.D{bson.E{Key: "maximum", Value: n}}
+		case "gt":
+			return bson.D{bson.E{Key: "minimum", Value: n}, bson.E{Key: "exclusiveMinimum", Value: true}}
+		case "lt":
+			return bson.D{bson.E{Key: "maximum", Value: n}, bson.E{Key: "exclusiveMaximum", Value: true}}
+		case "len":
+			return bson.D{bson.E{Key: "enum", Value: bson.A{n}}}
+		}
+	case "string", "array":
+		minKey, maxKey := "minLength", "maxLength"
+		if kind == "array" {
+			minKey, maxKey = "minItems", "maxItems"
+		}
+		size := int64(n)
+		switch name {
+		case "min", "gte":
+			return bson.D{bson.E{Key: minKey, Value: size}}
+		case "max", "lte":
+			return bson.D{bson.E{Key: maxKey, Value: size}}
+		case "gt":
+			return bson.D{bson.E{Key: minKey, Value: size + 1}}
+		case "lt":
+			return bson.D{bson.E{Key: maxKey, Value: size - 1}}
+		case "len":
+			return bson.D{bson.E{Key: minKey, Value: size}, bson.E{Key: maxKey, Value: size}}
+		}
+	}
+	return nil
+}
+
+func oneOfSchema(kind, param string) bson.D {
+	values := bson.A{}
+	for _, v := range strings.Fields(param) {
+		switch kind {
+		case "string":
+			values = append(values, strings.Trim(v, "'"))
+		case "number":
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil
+			}
+			values = append(values, n)
+		default:
+			return nil
+		}
+	}
+	return bson.D{bson.E{Key: "enum", Value: values}}
+}
+
+// schemaKind groups t into the kinds that share $jsonSchema keywords.
+func schemaKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return ""
+	}
+}
+
+// SyncCollections applies the $jsonSchema validators of the collection definitions registered
+// with WithJSONSchema. Missing collections are created with their validator, and the
+// validator of existing ones is replaced with collMod. Validation is strict and invalid
+// writes are rejected. With SetTenancy, the collections of each known tenant are synced too.
+func SyncCollections(ctx context.Context) error {
+	return SyncCollectionsOn(ctx, defaultDB)
+}
+
+// SyncCollectionsOn is like SyncCollections but applies the validators to db.
+func SyncCollectionsOn(ctx context.Context, db *DB) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	_, span := store.startTraceSpan(ctx, "", "syncCollections", nil)
+	defer span.End()
+	stores, err := tenantsToSync(ctx, store)
+	if err != nil {
+		return spanErrorHandler(err, span)
+	}
+	for _, store := range stores {
+		for _, index := range indexes {
+			def, ok := index.(*collectDef)
+			if !ok || def.schemaModel == nil {
+				continue
+			}
+			if err := syncCollection(ctx, store, def); err != nil {
+				return spanErrorHandler(
+					fmt.Errorf("failed to sync collection '%s': %w", def.collectionName, err), span,
+				)
+			}
+		}
+	}
+	return spanErrorHandler(nil, span)
+}
+
+func syncCollection(ctx context.Context, store Datastore, def *collectDef) error {
+	schema, err := GenerateJSONSchema(def.schemaModel)
+	if err != nil {
+		return err
+	}
+	validator := bson.D{bson.E{Key: "$jsonSchema", Value: schema}}
+	database := store.getDatabase()
+	collection := store.getCollection(def.collectionName)
+	if database == nil || collection == nil {
+		return errNoServer("SyncCollections")
+	}
+	// The collection of a tenant may carry a prefix, see TenantCollectionPrefix.
+	name := collection.Name()
+	names, err := database.ListCollectionNames(ctx, bson.D{bson.E{Key: "name", Value: name}})
+	if err != nil {
+		return errors.Join(ErrListCollectionFailed, err)
+	}
+	if len(names) == 0 {
+		err = database.CreateCollection(ctx, name, options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel("strict").
+			SetValidationAction("error"))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrWriteFailed, err)
+		}
+		log.Info("mongodb collection created", log.String("collection", name))
+		return nil
+	}
+	err = database.RunCommand(ctx, bson.D{
+		bson.E{Key: "collMod", Value: name},
+		bson.E{Key: "validator", Value: validator},
+		bson.E{Key: "validationLevel", Value: "strict"},
+		bson.E{Key: "validationAction", Value: "error"},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteFailed, err)
+	}
+	return nil
+}
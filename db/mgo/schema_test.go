@@ -0,0 +1,115 @@
+package mgo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type schemaAddress struct {
+	City string `bson:"city" validate:"required"`
+}
+
+type schemaModel struct {
+	Address        *schemaAddress `bson:"address"`
+	Nickname       *string        `bson:"nickname,omitempty"`
+	Name           string         `bson:"name" validate:"required,max=50"`
+	Role           string         `bson:"role" validate:"oneof=admin user"`
+	Note           string         `bson:"note" validate:"omitempty,min=3"`
+	Skipped        string         `bson:"-"`
+	mgo.Timestamps `bson:",inline"`
+	Tags           []string      `bson:"tags" validate:"max=5,dive,min=2"`
+	Age            int           `bson:"age" validate:"gte=0,lte=130"`
+	ID             bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func lookupD(t *testing.T, d bson.D, key string) any {
+	t.Helper()
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	t.Fatalf("key %q not found in %v", key, d)
+	return nil
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	// Act
+	schema, err := mgo.GenerateJSONSchema(&schemaModel{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "object", lookupD(t, schema, "bsonType"))
+	assert.Equal(t, bson.A{"name"}, lookupD(t, schema, "required"))
+	properties, ok := lookupD(t, schema, "properties").(bson.D)
+	require.True(t, ok)
+
+	keys := make([]string, 0, len(properties))
+	for _, p := range properties {
+		keys = append(keys, p.Key)
+	}
+	assert.Equal(t, []string{
+		"address", "nickname", "name", "role", "note", "created_at", "updated_at", "tags", "age", "_id",
+	}, keys)
+
+	field := func(name string) bson.D {
+		d, ok := lookupD(t, properties, name).(bson.D)
+		require.True(t, ok)
+		return d
+	}
+	assert.Equal(t, bson.D{
+		bson.E{Key: "bsonType", Value: "string"},
+		bson.E{Key: "minLength", Value: int64(1)},
+		bson.E{Key: "maxLength", Value: int64(50)},
+	}, field("name"))
+	assert.Equal(t, bson.A{"admin", "user"}, lookupD(t, field("role"), "enum"))
+	assert.Equal(t, bson.D{bson.E{Key: "bsonType", Value: "string"}}, field("note"))
+	assert.Equal(t, bson.A{"string", "null"}, lookupD(t, field("nickname"), "bsonType"))
+	assert.Equal(t, "date", lookupD(t, field("created_at"), "bsonType"))
+	assert.Equal(t, "objectId", lookupD(t, field("_id"), "bsonType"))
+	assert.Equal(t, 0.0, lookupD(t, field("age"), "minimum"))
+	assert.Equal(t, 130.0, lookupD(t, field("age"), "maximum"))
+
+	tags := field("tags")
+	assert.Equal(t, bson.A{"array", "null"}, lookupD(t, tags, "bsonType"))
+	assert.Equal(t, int64(5), lookupD(t, tags, "maxItems"))
+	items, ok := lookupD(t, tags, "items").(bson.D)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), lookupD(t, items, "minLength"))
+
+	address := field("address")
+	assert.Equal(t, bson.A{"object", "null"}, lookupD(t, address, "bsonType"))
+	assert.Equal(t, bson.A{"city"}, lookupD(t, address, "required"))
+}
+
+func TestGenerateJSONSchemaEncryptedFields(t *testing.T) {
+	type secret struct {
+		Token *string `bson:"token" vulpes:"encrypt"`
+		Email string  `bson:"email" validate:"required,email" vulpes:"encrypt,deterministic"`
+	}
+
+	// Act
+	schema, err := mgo.GenerateJSONSchema(&secret{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, bson.A{"email"}, lookupD(t, schema, "required"))
+	properties, ok := lookupD(t, schema, "properties").(bson.D)
+	require.True(t, ok)
+	assert.Equal(t, bson.D{
+		bson.E{Key: "token", Value: bson.D{bson.E{Key: "bsonType", Value: bson.A{"binData", "null"}}}},
+		bson.E{Key: "email", Value: bson.D{bson.E{Key: "bsonType", Value: "binData"}}},
+	}, properties)
+}
+
+func TestGenerateJSONSchemaRejectsNonStruct(t *testing.T) {
+	_, err := mgo.GenerateJSONSchema(time.Second)
+
+	require.ErrorIs(t, err, mgo.ErrInvalidDocument)
+}
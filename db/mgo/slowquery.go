@@ -0,0 +1,272 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SlowQueryOptions configures the logging of slow queries, see SetSlowQueryLog.
+type SlowQueryOptions struct {
+	// RedactFields lists the fields whose values are replaced by "<redacted>" in the logged
+	// statement, e.g. "email". A field also matches as the last segment of a dotted path,
+	// e.g. "profile.email".
+	RedactFields []string
+	// Threshold is the duration from which a query is logged. Zero disables the log.
+	Threshold time.Duration
+	// ExplainTimeout bounds the explain command. It defaults to 5s.
+	ExplainTimeout time.Duration
+	// Explain runs explain in the background for each slow query and logs its winning plan,
+	// telling whether the whole collection was scanned (COLLSCAN).
+	Explain bool
+}
+
+var slowQueryOptions SlowQueryOptions
+
+// SetSlowQueryLog logs, through the log package, the queries taking Threshold or more:
+// finds (Find, FindOne, Paginate, PaginateCursor, Export) and aggregations (PipeFind,
+// PipeFindOne, PipeFacet, PaginateByPipeline, PaginatePipe, GeoNear). Iterators are left out
+// since their duration includes the caller's loop. The log carries the collection, the
+// operation, the duration and the redacted filter or pipeline:
+//
+//	mgo.SetSlowQueryLog(mgo.SlowQueryOptions{
+//		Threshold:    200 * time.Millisecond,
+//		RedactFields: []string{"email", "phone"},
+//		Explain:      true,
+//	})
+//
+// It returns a function restoring the previous options.
+func SetSlowQueryLog(o SlowQueryOptions) (restore func()) {
+	original := slowQueryOptions
+	slowQueryOptions = o
+	return func() {
+		slowQueryOptions = original
+	}
+}
+
+// slowQueryCommands maps the operations watched for slow queries to the command explaining them.
+var slowQueryCommands = map[string]string{
+	"find":               "find",
+	"findOne":            "find",
+	"paginate":           "find",
+	"paginateCursor":     "find",
+	"export":             "find",
+	"pipeFindByPipeline": "aggregate",
+	"pipeFindOne":        "aggregate",
+	"pipeFacet":          "aggregate",
+	"paginateByPipeline": "aggregate",
+	"paginatePipe":       "aggregate",
+	"geoNear":            "aggregate",
+}
+
+// explainFunc returns the explain output of a find filter or aggregation pipeline.
+type explainFunc func(ctx context.Context, collection, command string, statement any) (bson.Raw, error)
+
+var (
+	// slowQueryLog writes the slow query logs; tests replace it.
+	slowQueryLog = log.Warn
+	// explainSlots bounds the number of explain commands running at once; slow queries
+	// arriving while they are all taken are not explained.
+	explainSlots = make(chan struct{}, 2)
+)
+
+// slowQuerySpan logs its query when it ends after the threshold.
+type slowQuerySpan struct {
+	trace.Span
+	statement  any
+	explain    explainFunc
+	start      time.Time
+	collection string
+	operation  string
+	options    SlowQueryOptions
+}
+
+// watchSlowQuery wraps span so that a slow query is logged, when slow query logging is
+// enabled and operation is a query. explain may be nil when the store cannot explain.
+func watchSlowQuery(span trace.Span, collection, operation string, statement any, explain explainFunc) trace.Span {
+	o := slowQueryOptions
+	if o.Threshold <= 0 || statement == nil || slowQueryCommands[operation] == "" {
+		return span
+	}
+	return &slowQuerySpan{
+		Span: span, statement: statement, explain: explain, start: time.Now(),
+		collection: collection, operation: operation, options: o,
+	}
+}
+
+// Unwrap returns the wrapped span.
+func (s *slowQuerySpan) Unwrap() trace.Span {
+	return s.Span
+}
+
+func (s *slowQuerySpan) End(opts ...trace.SpanEndOption) {
+	s.Span.End(opts...)
+	elapsed := time.Since(s.start)
+	if elapsed < s.options.Threshold {
+		return
+	}
+	statement := redact(s.statement, s.options.RedactFields)
+	slowQueryLog("mongo slow query",
+		log.String("collection", s.collection),
+		log.String("operation", s.operation),
+		log.Duration("duration", elapsed),
+		log.String("statement", formatStatement(statement)),
+	)
+	if !s.options.Explain || s.explain == nil {
+		return
+	}
+	select {
+	case explainSlots <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-explainSlots }()
+		s.logPlan()
+	}()
+}
+
+// logPlan explains the query and logs its winning plan.
+func (s *slowQuerySpan) logPlan() {
+	timeout := s.options.ExplainTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fields := []log.Field{log.String("collection", s.collection), log.String("operation", s.operation)}
+	raw, err := s.explain(ctx, s.collection, slowQueryCommands[s.operation], s.statement)
+	if err != nil {
+		slowQueryLog("mongo slow query explain failed", append(fields, log.Err(err))...)
+		return
+	}
+	stages := planStages(raw.Lookup("queryPlanner", "winningPlan"))
+	if len(stages) == 0 {
+		// Aggregations report the plan of their leading $cursor stage.
+		if pipeline, ok := raw.Lookup("stages").ArrayOK(); ok {
+			if first, err := pipeline.IndexErr(0); err == nil && first.Type == bson.TypeEmbeddedDocument {
+				stages = planStages(first.Document().Lookup("$cursor", "queryPlanner", "winningPlan"))
+			}
+		}
+	}
+	slowQueryLog("mongo slow query plan", append(fields,
+		log.String("plan", strings.Join(stages, " <- ")),
+		log.Bool("collscan", slices.Contains(stages, "COLLSCAN")),
+	)...)
+}
+
+// planStages lists the stages of a winning plan from the root, following inputStage and
+// inputStages.
+func planStages(plan bson.RawValue) []string {
+	doc, ok := plan.DocumentOK()
+	if !ok {
+		return nil
+	}
+	if q, ok := doc.Lookup("queryPlan").DocumentOK(); ok {
+		// Plans of the slot-based engine are nested in queryPlan.
+		doc = q
+	}
+	var stages []string
+	if stage, ok := doc.Lookup("stage").StringValueOK(); ok {
+		stages = append(stages, stage)
+	}
+	stages = append(stages, planStages(doc.Lookup("inputStage"))...)
+	if inputs, ok := doc.Lookup("inputStages").ArrayOK(); ok {
+		values, _ := inputs.Values()
+		for _, input := range values {
+			stages = append(stages, planStages(input)...)
+		}
+	}
+	return stages
+}
+
+// redactedValue replaces the values of redacted fields.
+const redactedValue = "<redacted>"
+
+// redact returns a copy of statement, a filter or pipeline, where the values of fields are
+// replaced by redactedValue. Statements that cannot be marshaled to BSON are returned as is.
+func redact(statement any, fields []string) any {
+	if len(fields) == 0 {
+		return statement
+	}
+	data, err := bson.Marshal(bson.D{bson.E{Key: "s", Value: statement}})
+	if err != nil {
+		return statement
+	}
+	var wrapped bson.D
+	if err := bson.Unmarshal(data, &wrapped); err != nil || len(wrapped) != 1 {
+		return statement
+	}
+	return redactValue(wrapped[0].Value, fields)
+}
+
+func redactValue(v any, fields []string) any {
+	switch v := v.(type) {
+	case bson.D:
+		for i, e := range v {
+			if isRedacted(e.Key, fields) {
+				v[i].Value = redactedValue
+			} else {
+				v[i].Value = redactValue(e.Value, fields)
+			}
+		}
+		return v
+	case bson.A:
+		for i, e := range v {
+			v[i] = redactValue(e, fields)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isRedacted(key string, fields []string) bool {
+	for _, f := range fields {
+		if key == f || strings.HasSuffix(key, "."+f) {
+			return true
+		}
+	}
+	return false
+}
+
+// explainFor returns the explain function of the store of the tenant of ctx, the context of
+// the operation, as explain runs after the operation with a context of its own.
+func (m *mongoStore) explainFor(ctx context.Context) explainFunc {
+	return func(explainCtx context.Context, collection, command string, statement any) (bson.Raw, error) {
+		routed, err := m.forTenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return routed.explain(explainCtx, collection, command, statement)
+	}
+}
+
+// explain runs the explain command of a find filter or aggregation pipeline.
+func (m *mongoStore) explain(ctx context.Context, collection, command string, statement any) (bson.Raw, error) {
+	collection = m.getCollection(collection).Name()
+	var cmd bson.D
+	switch command {
+	case "find":
+		cmd = bson.D{bson.E{Key: "find", Value: collection}, bson.E{Key: "filter", Value: statement}}
+	case "aggregate":
+		cmd = bson.D{
+			bson.E{Key: "aggregate", Value: collection},
+			bson.E{Key: "pipeline", Value: statement},
+			bson.E{Key: "cursor", Value: bson.D{}},
+		}
+	default:
+		return nil, fmt.Errorf("cannot explain %s", command)
+	}
+	return m.db.RunCommand(ctx, bson.D{
+		bson.E{Key: "explain", Value: cmd},
+		bson.E{Key: "verbosity", Value: "queryPlanner"},
+	}).Raw()
+}
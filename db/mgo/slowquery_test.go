@@ -0,0 +1,145 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// slowQueryEntry is a log written by the slow query log.
+type slowQueryEntry struct {
+	fields map[string]log.Field
+	msg    string
+}
+
+// captureSlowQueries sends the slow query logs to the returned channel until restore is called.
+func captureSlowQueries() (entries chan slowQueryEntry, restore func()) {
+	entries = make(chan slowQueryEntry, 10)
+	original := slowQueryLog
+	slowQueryLog = func(msg string, fields ...log.Field) {
+		entry := slowQueryEntry{msg: msg, fields: map[string]log.Field{}}
+		for _, f := range fields {
+			entry.fields[f.Key] = f
+		}
+		entries <- entry
+	}
+	return entries, func() { slowQueryLog = original }
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	restoreStore := SetDatastore(NewMemoryDatastore())
+	defer restoreStore()
+	ctx := context.Background()
+	filter := bson.D{
+		{Key: "email", Value: "a@x.io"},
+		{Key: "$or", Value: bson.A{bson.D{{Key: "profile.email", Value: "b@x.io"}}, bson.D{{Key: "age", Value: 30}}}},
+	}
+
+	t.Run("Logs slow queries with redacted fields", func(t *testing.T) {
+		// Arrange
+		entries, restore := captureSlowQueries()
+		defer restore()
+		defer SetSlowQueryLog(SlowQueryOptions{Threshold: time.Nanosecond, RedactFields: []string{"email"}})()
+
+		// Act
+		_, err := Find(ctx, &testUser{}, filter, 0)
+		require.NoError(t, err)
+
+		// Assert
+		require.Len(t, entries, 1)
+		entry := <-entries
+		assert.Equal(t, "mongo slow query", entry.msg)
+		assert.Equal(t, "users", entry.fields["collection"].String)
+		assert.Equal(t, "find", entry.fields["operation"].String)
+		assert.Equal(t,
+			`{"email":"<redacted>","$or":[{"profile.email":"<redacted>"},{"age":30}]}`,
+			entry.fields["statement"].String)
+		assert.Equal(t, "a@x.io", filter[0].Value, "the filter itself is left untouched")
+	})
+
+	t.Run("Ignores fast queries and writes", func(t *testing.T) {
+		// Arrange
+		entries, restore := captureSlowQueries()
+		defer restore()
+
+		// Act
+		restoreOptions := SetSlowQueryLog(SlowQueryOptions{Threshold: time.Hour})
+		_, err := Find(ctx, &testUser{}, filter, 0)
+		require.NoError(t, err)
+		restoreOptions()
+		defer SetSlowQueryLog(SlowQueryOptions{Threshold: time.Nanosecond})()
+		_, err = UpdateMany(ctx, &testUser{}, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "age", Value: 1}}}})
+		require.NoError(t, err)
+
+		// Assert
+		assert.Empty(t, entries)
+	})
+}
+
+func TestSlowQueryExplain(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantPlan     string
+		plan         bson.D
+		wantCollscan bool
+	}{
+		{
+			name: "Find with collection scan",
+			plan: bson.D{{Key: "queryPlanner", Value: bson.D{{Key: "winningPlan", Value: bson.D{
+				{Key: "stage", Value: "LIMIT"},
+				{Key: "inputStage", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}},
+			}}}}},
+			wantPlan:     "LIMIT <- COLLSCAN",
+			wantCollscan: true,
+		},
+		{
+			name: "Aggregation using an index",
+			plan: bson.D{{Key: "stages", Value: bson.A{
+				bson.D{{Key: "$cursor", Value: bson.D{{Key: "queryPlanner", Value: bson.D{{Key: "winningPlan", Value: bson.D{
+					{Key: "queryPlan", Value: bson.D{
+						{Key: "stage", Value: "FETCH"},
+						{Key: "inputStage", Value: bson.D{{Key: "stage", Value: "IXSCAN"}}},
+					}},
+				}}}}}}},
+				bson.D{{Key: "$group", Value: bson.D{}}},
+			}}},
+			wantPlan: "FETCH <- IXSCAN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			entries, restore := captureSlowQueries()
+			defer restore()
+			defer SetSlowQueryLog(SlowQueryOptions{Threshold: time.Nanosecond, Explain: true})()
+			var command string
+			explain := func(_ context.Context, _, cmd string, _ any) (bson.Raw, error) {
+				command = cmd
+				return bson.Marshal(tt.plan)
+			}
+			pipeline := NewPipeline().Match(bson.D{{Key: "age", Value: 30}}).Build()
+
+			// Act
+			watchSlowQuery(noop.Span{}, "users", "pipeFindByPipeline", pipeline, explain).End()
+
+			// Assert
+			assert.Equal(t, "mongo slow query", (<-entries).msg)
+			select {
+			case entry := <-entries:
+				assert.Equal(t, "mongo slow query plan", entry.msg)
+				assert.Equal(t, "aggregate", command)
+				assert.Equal(t, tt.wantPlan, entry.fields["plan"].String)
+				assert.Equal(t, tt.wantCollscan, entry.fields["collscan"].Integer == 1)
+			case <-time.After(time.Second):
+				t.Fatal("the plan was not logged")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package mgo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const fieldDeletedAt = "deleted_at"
+
+// SoftDeleter is implemented by models that are soft-deleted: DeleteById sets their
+// deleted_at field instead of removing them, and Find, FindOne, FindIter and the pagination
+// helpers skip documents whose deleted_at is set unless the context is marked with IncludeDeleted.
+// DeleteOne and DeleteMany still remove documents permanently. Embed SoftDelete to implement it.
+type SoftDeleter interface {
+	SetDeletedAt(t time.Time)
+	IsDeleted() bool
+}
+
+// SoftDelete is an embeddable implementation of SoftDeleter.
+// Like Timestamps, embed it with `bson:",inline"`.
+type SoftDelete struct {
+	DeletedAt *time.Time `bson:"deleted_at,omitempty"`
+}
+
+func (s *SoftDelete) SetDeletedAt(at time.Time) {
+	s.DeletedAt = &at
+}
+
+func (s *SoftDelete) IsDeleted() bool {
+	return s.DeletedAt != nil
+}
+
+type includeDeletedKey struct{}
+
+// IncludeDeleted returns a context that makes the read helpers return soft-deleted documents too.
+func IncludeDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+func isIncludeDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(includeDeletedKey{}).(bool)
+	return include
+}
+
+// excludeDeleted restricts filter to documents that are not soft-deleted when softDelete is set.
+// Filters that already mention deleted_at are left untouched.
+func excludeDeleted(ctx context.Context, softDelete bool, filter any) any {
+	if !softDelete || isIncludeDeleted(ctx) {
+		return filter
+	}
+	notDeleted := bson.E{Key: fieldDeletedAt, Value: nil}
+	switch f := filter.(type) {
+	case bson.D:
+		if hasKey(f, fieldDeletedAt) {
+			return f
+		}
+	case bson.M:
+		if _, ok := f[fieldDeletedAt]; ok {
+			return f
+		}
+	}
+	return andFilter(filter, notDeleted)
+}
+
+func isSoftDeleter(doc any) bool {
+	_, ok := doc.(SoftDeleter)
+	return ok
+}
+
+// softDeleteById marks the document identified by the _id of doc as deleted.
+// Already deleted documents are not touched, so the count is 0 for them.
+func softDeleteById[T DocInter](ctx context.Context, store Datastore, doc T) (int64, error) {
+	filter := bson.D{
+		bson.E{Key: "_id", Value: doc.GetId()},
+		bson.E{Key: fieldDeletedAt, Value: nil},
+	}
+	_, span := store.startTraceSpan(ctx, doc.C(), "softDeleteById", filter)
+	defer span.End()
+	now := time.Now()
+	update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: fieldDeletedAt, Value: now}}}}
+	if _, ok := any(doc).(Timestamper); ok {
+		update = withUpdatedAt(update, now)
+	}
+	affected, err := retryValue(ctx, span, true, func() (int64, error) {
+		return store.UpdateOne(ctx, doc.C(), filter, update)
+	})
+	if err != nil {
+		return 0, spanErrorHandler(err, span)
+	}
+	if deleter, ok := any(doc).(SoftDeleter); ok && affected > 0 {
+		deleter.SetDeletedAt(now)
+	}
+	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
+	return affected, spanErrorHandler(nil, span)
+}
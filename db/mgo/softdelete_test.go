@@ -0,0 +1,225 @@
+package mgo_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// softUser is a model that opts in to timestamps and soft deletion.
+type softUser struct {
+	mgo.Timestamps `bson:",inline"`
+	mgo.SoftDelete `bson:",inline"`
+	Name           string        `bson:"name"`
+	ID             bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*softUser) C() string                   { return "soft_users" }
+func (*softUser) Indexes() []mongo.IndexModel { return nil }
+func (*softUser) Validate() error             { return nil }
+func (u *softUser) GetId() any                { return u.ID }
+func (u *softUser) SetId(id any)              { u.ID, _ = id.(bson.ObjectID) }
+
+func TestTimestamps(t *testing.T) {
+	t.Run("Save stamps creation time", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{OnSave: mgo.NewOnSaveMock()})
+		defer restore()
+		before := time.Now()
+
+		// Act
+		user, err := mgo.Save(context.Background(), &softUser{Name: "Peter"})
+
+		// Assert
+		require.NoError(t, err)
+		assert.False(t, user.CreatedAt.Before(before))
+		assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+	})
+
+	t.Run("Update sets updated_at", func(t *testing.T) {
+		// Arrange
+		var captured bson.D
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnUpdateOne: func(_ context.Context, _ string, _ bson.D, update bson.D) (int64, error) {
+				captured = update
+				return 1, nil
+			},
+		})
+		defer restore()
+		update := bson.D{bson.E{Key: "$inc", Value: bson.D{bson.E{Key: "age", Value: 1}}}}
+
+		// Act
+		_, err := mgo.UpdateById(context.Background(), &softUser{ID: bson.NewObjectID()}, update)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, captured, 2)
+		assert.Equal(t, "$set", captured[1].Key)
+		set, ok := captured[1].Value.(bson.D)
+		require.True(t, ok)
+		assert.Equal(t, "updated_at", set[0].Key)
+		assert.Len(t, update, 1, "the caller's update must not be modified")
+	})
+
+	t.Run("Models without timestamps are untouched", func(t *testing.T) {
+		// Arrange
+		var captured bson.D
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnUpdateOne: func(_ context.Context, _ string, _ bson.D, update bson.D) (int64, error) {
+				captured = update
+				return 1, nil
+			},
+		})
+		defer restore()
+		update := bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "name", Value: "Alice"}}}}
+
+		// Act
+		_, err := mgo.UpdateById(context.Background(), &testUser{ID: bson.NewObjectID()}, update)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, update, captured)
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	t.Run("DeleteById marks the document as deleted", func(t *testing.T) {
+		// Arrange
+		var capturedFilter, capturedUpdate bson.D
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnUpdateOne: func(_ context.Context, _ string, filter bson.D, update bson.D) (int64, error) {
+				capturedFilter, capturedUpdate = filter, update
+				return 1, nil
+			},
+			OnDeleteOne: func(context.Context, string, bson.D) (int64, error) {
+				t.Fatal("DeleteOne must not be called for soft-deleted models")
+				return 0, nil
+			},
+		})
+		defer restore()
+		user := &softUser{ID: bson.NewObjectID()}
+
+		// Act
+		affected, err := mgo.DeleteById(context.Background(), user)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+		assert.True(t, user.IsDeleted())
+		assert.Equal(t, bson.E{Key: "deleted_at", Value: nil}, capturedFilter[1])
+		set, ok := capturedUpdate[0].Value.(bson.D)
+		require.True(t, ok)
+		assert.Equal(t, []string{"deleted_at", "updated_at"}, []string{set[0].Key, set[1].Key})
+	})
+
+	t.Run("Find excludes deleted documents", func(t *testing.T) {
+		// Arrange
+		var captured any
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnFind: func(
+				_ context.Context, _ string, filter any, _ ...options.Lister[options.FindOptions],
+			) (*mongo.Cursor, error) {
+				captured = filter
+				return mgo.NewOnFindMock()(context.Background(), "", nil)
+			},
+		})
+		defer restore()
+		filter := bson.D{bson.E{Key: "name", Value: "Peter"}}
+
+		// Act
+		_, err := mgo.Find(context.Background(), &softUser{}, filter, 10)
+		require.NoError(t, err)
+		excluded := captured
+		_, err = mgo.Find(mgo.IncludeDeleted(context.Background()), &softUser{}, filter, 10)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, append(filter, bson.E{Key: "deleted_at", Value: nil}), excluded)
+		assert.Equal(t, filter, captured)
+	})
+
+	t.Run("CountDocument honours WithSoftDelete", func(t *testing.T) {
+		// Arrange
+		const collection = "soft_count_users"
+		mgo.RegisterIndex(mgo.NewCollectDef(collection, func() []mongo.IndexModel { return nil }, mgo.WithSoftDelete()))
+		var captured any
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnCountDocument: func(_ context.Context, _ string, filter any) (int64, error) {
+				captured = filter
+				return 0, nil
+			},
+		})
+		defer restore()
+
+		// Act
+		_, err := mgo.CountDocument(context.Background(), collection, bson.M{"name": "Peter"})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.D{bson.E{Key: "$and", Value: bson.A{
+			bson.M{"name": "Peter"}, bson.D{bson.E{Key: "deleted_at", Value: nil}},
+		}}}, captured)
+	})
+
+	t.Run("Model variants skip deleted documents", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		ctx := context.Background()
+		deleted, err := mgo.Save(ctx, &softUser{Name: "Peter"})
+		require.NoError(t, err)
+		_, err = mgo.Save(ctx, &softUser{Name: "Amy"})
+		require.NoError(t, err)
+		_, err = mgo.DeleteById(ctx, deleted)
+		require.NoError(t, err)
+
+		// Act
+		count, err := mgo.CountModel(ctx, &softUser{}, bson.D{})
+		require.NoError(t, err)
+		all, err := mgo.CountModel(mgo.IncludeDeleted(ctx), &softUser{}, bson.D{})
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		exported, err := mgo.ExportModel(ctx, &softUser{}, &buf, nil)
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(1), count)
+		assert.Equal(t, int64(2), all)
+		assert.Equal(t, int64(1), exported)
+		assert.Contains(t, buf.String(), `"name":"Amy"`)
+	})
+
+	t.Run("CountDocument honours registered SoftDeleter models", func(t *testing.T) {
+		// Arrange
+		defer mgo.SetDatastore(mgo.NewMemoryDatastore())()
+		mgo.RegisterIndex(&registeredSoftUser{})
+		ctx := context.Background()
+		user := &registeredSoftUser{softUser: softUser{Name: "Peter"}}
+		_, err := mgo.Save(ctx, user)
+		require.NoError(t, err)
+		_, err = mgo.DeleteById(ctx, user)
+		require.NoError(t, err)
+
+		// Act
+		count, err := mgo.CountDocument(ctx, user.C(), bson.D{})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
+
+// registeredSoftUser is a soft-deleted model registered with RegisterIndex.
+type registeredSoftUser struct {
+	softUser `bson:",inline"`
+}
+
+func (*registeredSoftUser) C() string { return "registered_soft_users" }
@@ -0,0 +1,384 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"google.golang.org/grpc/metadata"
+)
+
+// TenantStrategy tells how the documents of the tenants are kept apart.
+type TenantStrategy int
+
+const (
+	// TenantDatabase routes each tenant to its own database, named "<database>_<tenant>" by default.
+	TenantDatabase TenantStrategy = iota + 1
+	// TenantCollectionPrefix keeps the tenants in the same database, in collections named
+	// "<tenant>_<collection>" by default.
+	TenantCollectionPrefix
+)
+
+// TenantResolver returns the tenant of a request from its context, and false when it has none.
+type TenantResolver func(ctx context.Context) (string, bool)
+
+// TenancyOptions configures multi-tenant routing, see SetTenancy.
+type TenancyOptions struct {
+	// Resolver reads the tenant from the context, e.g. MetadataTenant("merchant-id"). A tenant set
+	// with WithTenant takes precedence. When nil, only WithTenant sets the tenant.
+	Resolver TenantResolver
+	// Tenants lists the known tenants, for SyncIndexes, PlanIndexes and SyncCollections.
+	Tenants func(ctx context.Context) ([]string, error)
+	// Name returns the database (TenantDatabase) or collection (TenantCollectionPrefix) of
+	// tenant for the shared one named base. It overrides the default naming.
+	Name func(base, tenant string) string
+	// Strategy selects how tenants are separated. The zero value disables tenancy.
+	Strategy TenantStrategy
+	// Required rejects the operations without a tenant with ErrInvalidTenant instead of
+	// running them on the shared database and collections.
+	Required bool
+}
+
+var tenancy TenancyOptions
+
+// SetTenancy makes the helpers route each operation to the database or collections of the
+// tenant of its context:
+//
+//	mgo.SetTenancy(mgo.TenancyOptions{
+//		Strategy: mgo.TenantDatabase,
+//		Resolver: mgo.MetadataTenant("merchant-id"),
+//		Tenants:  merchants.IDs,
+//	})
+//
+// DocInter.C() keeps returning the shared collection name; routing happens in the datastore,
+// so that the same models serve every tenant. Cached documents are keyed per tenant.
+// MockDatastore receives the shared names and is not routed.
+//
+// It returns a function restoring the previous options.
+func SetTenancy(o TenancyOptions) (restore func()) {
+	original := tenancy
+	tenancy = o
+	return func() {
+		tenancy = original
+	}
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose operations run for tenant, e.g. in background jobs or to
+// act on behalf of another tenant. It takes precedence over the resolver of SetTenancy.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// MetadataTenant returns a resolver reading the tenant from the incoming gRPC metadata key,
+// e.g. "merchant-id" as set by ezgrpc.
+func MetadataTenant(key string) TenantResolver {
+	return func(ctx context.Context) (string, bool) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return "", false
+		}
+		values := md.Get(key)
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[0], values[0] != ""
+	}
+}
+
+// resolveTenant returns the tenant of ctx. It returns false when tenancy is disabled or ctx has no
+// tenant, and ErrInvalidTenant when a required tenant is missing or cannot name a database.
+func resolveTenant(ctx context.Context) (string, bool, error) {
+	if tenancy.Strategy == 0 {
+		return "", false, nil
+	}
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok && tenancy.Resolver != nil {
+		tenant, ok = tenancy.Resolver(ctx)
+	}
+	if !ok || tenant == "" {
+		if tenancy.Required {
+			return "", false, fmt.Errorf("%w: no tenant in context", ErrInvalidTenant)
+		}
+		return "", false, nil
+	}
+	// Characters forbidden in database names, plus "$" which collection names cannot hold.
+	if strings.ContainsAny(tenant, "/\\. \"$*<>:|?\x00") {
+		return "", false, fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	return tenant, true, nil
+}
+
+// tenantName returns the database or collection of tenant for the shared one named base.
+func tenantName(base, tenant string) string {
+	switch {
+	case tenancy.Name != nil:
+		return tenancy.Name(base, tenant)
+	case tenancy.Strategy == TenantCollectionPrefix:
+		return tenant + "_" + base
+	default:
+		return base + "_" + tenant
+	}
+}
+
+// forTenant returns the store of the tenant of ctx, or m itself when there is none.
+func (m *mongoStore) forTenant(ctx context.Context) (*mongoStore, error) {
+	if m.tenant != "" {
+		return m, nil
+	}
+	tenant, ok, err := resolveTenant(ctx)
+	if err != nil || !ok {
+		return m, err
+	}
+	routed := *m
+	routed.tenant = tenant
+	if tenancy.Strategy == TenantDatabase {
+		routed.db = m.db.Client().Database(tenantName(m.db.Name(), tenant))
+	} else {
+		routed.prefixCollections = true
+	}
+	return &routed, nil
+}
+
+func (m *mongoStore) tenantStore(ctx context.Context) (Datastore, error) {
+	return m.forTenant(ctx)
+}
+
+// forTenant returns the store of the tenant of ctx, or m itself when there is none.
+// Both strategies give each tenant its own collections.
+func (m *MemoryDatastore) forTenant(ctx context.Context) (*MemoryDatastore, error) {
+	if m.tenant != "" {
+		return m, nil
+	}
+	tenant, ok, err := resolveTenant(ctx)
+	if err != nil || !ok {
+		return m, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tenants == nil {
+		m.tenants = make(map[string]*MemoryDatastore)
+	}
+	routed, ok := m.tenants[tenant]
+	if !ok {
+		routed = NewMemoryDatastore()
+		routed.tenant = tenant
+		m.tenants[tenant] = routed
+	}
+	return routed, nil
+}
+
+func (m *MemoryDatastore) tenantStore(ctx context.Context) (Datastore, error) {
+	return m.forTenant(ctx)
+}
+
+// tenantCacheKey scopes the cache key of c to the tenant of ctx. It returns false when ctx
+// has no valid tenant while one is required.
+func tenantCacheKey(ctx context.Context, c Cacheable) (string, bool) {
+	tenant, ok, err := resolveTenant(ctx)
+	if err != nil {
+		return "", false
+	}
+	if !ok {
+		return c.CacheKey(), true
+	}
+	return tenant + ":" + c.CacheKey(), true
+}
+
+// tenantsToSync lists the stores SyncIndexes and SyncCollections provision: the shared one,
+// unless a tenant is required, and the one of each known tenant.
+func tenantsToSync(ctx context.Context, store Datastore) ([]Datastore, error) {
+	tenants, err := syncTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stores := make([]Datastore, 0, len(tenants))
+	for _, tenant := range tenants {
+		routed, err := storeOfTenant(ctx, store, tenant)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, routed)
+	}
+	return stores, nil
+}
+
+// syncTenants lists the tenants of tenantsToSync, "" standing for the shared store.
+func syncTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	if tenancy.Strategy == 0 || !tenancy.Required {
+		tenants = append(tenants, "")
+	}
+	if tenancy.Strategy == 0 || tenancy.Tenants == nil {
+		return tenants, nil
+	}
+	known, err := tenancy.Tenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	return append(tenants, known...), nil
+}
+
+// storeOfTenant returns the store of tenant, or store itself for "".
+func storeOfTenant(ctx context.Context, store Datastore, tenant string) (Datastore, error) {
+	if tenant == "" {
+		return store, nil
+	}
+	return store.tenantStore(WithTenant(ctx, tenant))
+}
+
+// prefixPipeline returns a copy of pipeline whose stages name the collections of the tenant of
+// m. Under TenantCollectionPrefix, getCollection only routes the collection the pipeline runs
+// on, while $lookup, $graphLookup, $unionWith, $out and $merge name their own. Stages that
+// cannot be routed, such as an $out to another database, fail with ErrInvalidTenant.
+func (m *mongoStore) prefixPipeline(pipeline mongo.Pipeline) (mongo.Pipeline, error) {
+	if !m.prefixCollections {
+		return pipeline, nil
+	}
+	routed := make(mongo.Pipeline, len(pipeline))
+	for i, stage := range pipeline {
+		v, err := m.prefixStage(stage)
+		if err != nil {
+			return nil, err
+		}
+		routed[i] = v.(bson.D)
+	}
+	return routed, nil
+}
+
+// prefixStage routes the collections named by stage, a bson.D or bson.M.
+func (m *mongoStore) prefixStage(stage any) (any, error) {
+	return mapDocument(stage, "stage", func(op string, v any) (any, error) {
+		switch op {
+		case "$lookup", "$graphLookup":
+			return mapDocument(v, op, func(key string, v any) (any, error) {
+				switch key {
+				case "from":
+					return m.prefixCollection(op, v)
+				case "pipeline":
+					return m.prefixSubPipeline(op, v)
+				}
+				return v, nil
+			})
+		case "$unionWith":
+			if _, ok := v.(string); ok {
+				return m.prefixCollection(op, v)
+			}
+			return mapDocument(v, op, func(key string, v any) (any, error) {
+				switch key {
+				case "coll":
+					return m.prefixCollection(op, v)
+				case "pipeline":
+					return m.prefixSubPipeline(op, v)
+				}
+				return v, nil
+			})
+		case "$out":
+			return m.prefixTarget(op, v)
+		case "$merge":
+			return mapDocument(v, op, func(key string, v any) (any, error) {
+				if key == "into" {
+					return m.prefixTarget(op, v)
+				}
+				return v, nil
+			})
+		case "$facet":
+			return mapDocument(v, op, func(_ string, v any) (any, error) {
+				return m.prefixSubPipeline(op, v)
+			})
+		}
+		return v, nil
+	})
+}
+
+// prefixTarget routes the output collection of $out or $merge, either a name or {db, coll}.
+// Another database is outside the tenancy and is rejected.
+func (m *mongoStore) prefixTarget(op string, v any) (any, error) {
+	if _, ok := v.(string); ok {
+		return m.prefixCollection(op, v)
+	}
+	return mapDocument(v, op, func(key string, v any) (any, error) {
+		switch key {
+		case "db":
+			return nil, fmt.Errorf("%w: %s to database %v", ErrInvalidTenant, op, v)
+		case "coll":
+			return m.prefixCollection(op, v)
+		}
+		return v, nil
+	})
+}
+
+func (m *mongoStore) prefixCollection(op string, v any) (any, error) {
+	name, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot route the collection of %s", ErrInvalidTenant, op)
+	}
+	return tenantName(name, m.tenant), nil
+}
+
+func (m *mongoStore) prefixSubPipeline(op string, v any) (any, error) {
+	var stages []any
+	switch p := v.(type) {
+	case mongo.Pipeline:
+		routed, err := m.prefixPipeline(p)
+		return routed, err
+	case []bson.D:
+		routed, err := m.prefixPipeline(p)
+		return []bson.D(routed), err
+	case bson.A:
+		stages = p
+	case []any:
+		stages = p
+	case []bson.M:
+		routed := make([]bson.M, len(p))
+		for i, stage := range p {
+			s, err := m.prefixStage(stage)
+			if err != nil {
+				return nil, err
+			}
+			routed[i] = s.(bson.M)
+		}
+		return routed, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot route the pipeline of %s", ErrInvalidTenant, op)
+	}
+	routed := make(bson.A, len(stages))
+	for i, stage := range stages {
+		s, err := m.prefixStage(stage)
+		if err != nil {
+			return nil, err
+		}
+		routed[i] = s
+	}
+	return routed, nil
+}
+
+// mapDocument returns a copy of doc, a bson.D or bson.M, with fn applied to each value.
+func mapDocument(doc any, what string, fn func(key string, v any) (any, error)) (any, error) {
+	switch d := doc.(type) {
+	case bson.D:
+		mapped := make(bson.D, len(d))
+		for i, e := range d {
+			v, err := fn(e.Key, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			mapped[i] = bson.E{Key: e.Key, Value: v}
+		}
+		return mapped, nil
+	case bson.M:
+		mapped := make(bson.M, len(d))
+		for k, v := range d {
+			v, err := fn(k, v)
+			if err != nil {
+				return nil, err
+			}
+			mapped[k] = v
+		}
+		return mapped, nil
+	}
+	return nil, fmt.Errorf("%w: cannot route the %s %T", ErrInvalidTenant, what, doc)
+}
@@ -0,0 +1,281 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTenantRouting(t *testing.T) {
+	// Arrange: the client connects lazily, so no server is needed to resolve names.
+	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://localhost:1"))
+	require.NoError(t, err)
+	defer func() { _ = client.Disconnect(context.Background()) }()
+	store := &mongoStore{db: client.Database("app")}
+	ctx := WithTenant(context.Background(), "acme")
+
+	tests := []struct {
+		name           string
+		wantDatabase   string
+		wantCollection string
+		options        TenancyOptions
+	}{
+		{name: "Disabled", wantDatabase: "app", wantCollection: "users"},
+		{
+			name: "Database per tenant", options: TenancyOptions{Strategy: TenantDatabase},
+			wantDatabase: "app_acme", wantCollection: "users",
+		},
+		{
+			name: "Collection prefix", options: TenancyOptions{Strategy: TenantCollectionPrefix},
+			wantDatabase: "app", wantCollection: "acme_users",
+		},
+		{
+			name: "Custom naming",
+			options: TenancyOptions{
+				Strategy: TenantDatabase,
+				Name:     func(base, tenant string) string { return "tenant-" + tenant },
+			},
+			wantDatabase: "tenant-acme", wantCollection: "users",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer SetTenancy(tt.options)()
+
+			// Act
+			routed, err := store.forTenant(ctx)
+
+			// Assert
+			require.NoError(t, err)
+			collection := routed.getCollection("users")
+			assert.Equal(t, tt.wantDatabase, collection.Database().Name())
+			assert.Equal(t, tt.wantCollection, collection.Name())
+		})
+	}
+}
+
+func TestPrefixPipeline(t *testing.T) {
+	defer SetTenancy(TenancyOptions{Strategy: TenantCollectionPrefix})()
+	store := &mongoStore{tenant: "acme", prefixCollections: true}
+	match := bson.D{{Key: "$match", Value: bson.D{{Key: "age", Value: 30}}}}
+
+	tests := []struct {
+		wantErr  error
+		name     string
+		pipeline mongo.Pipeline
+		want     mongo.Pipeline
+	}{
+		{name: "Stages without collections are kept", pipeline: mongo.Pipeline{match}, want: mongo.Pipeline{match}},
+		{
+			name: "Lookup and its pipeline",
+			pipeline: mongo.Pipeline{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "pipeline", Value: bson.A{bson.M{"$unionWith": "refunds"}}},
+				{Key: "as", Value: "orders"},
+			}}}},
+			want: mongo.Pipeline{{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "acme_orders"},
+				{Key: "pipeline", Value: bson.A{bson.M{"$unionWith": "acme_refunds"}}},
+				{Key: "as", Value: "orders"},
+			}}}},
+		},
+		{
+			name:     "Graph lookup",
+			pipeline: mongo.Pipeline{{{Key: "$graphLookup", Value: bson.M{"from": "users", "as": "tree"}}}},
+			want:     mongo.Pipeline{{{Key: "$graphLookup", Value: bson.M{"from": "acme_users", "as": "tree"}}}},
+		},
+		{
+			name: "Union with a pipeline",
+			pipeline: mongo.Pipeline{{{Key: "$unionWith", Value: bson.D{
+				{Key: "coll", Value: "archive"}, {Key: "pipeline", Value: mongo.Pipeline{match}},
+			}}}},
+			want: mongo.Pipeline{{{Key: "$unionWith", Value: bson.D{
+				{Key: "coll", Value: "acme_archive"}, {Key: "pipeline", Value: mongo.Pipeline{match}},
+			}}}},
+		},
+		{
+			name: "Facets",
+			pipeline: mongo.Pipeline{{{Key: "$facet", Value: bson.D{
+				{Key: "all", Value: []bson.D{{{Key: "$unionWith", Value: "archive"}}}},
+			}}}},
+			want: mongo.Pipeline{{{Key: "$facet", Value: bson.D{
+				{Key: "all", Value: []bson.D{{{Key: "$unionWith", Value: "acme_archive"}}}},
+			}}}},
+		},
+		{
+			name:     "Out",
+			pipeline: mongo.Pipeline{{{Key: "$out", Value: "report"}}},
+			want:     mongo.Pipeline{{{Key: "$out", Value: "acme_report"}}},
+		},
+		{
+			name:     "Merge",
+			pipeline: mongo.Pipeline{{{Key: "$merge", Value: bson.M{"into": bson.M{"coll": "report"}}}}},
+			want:     mongo.Pipeline{{{Key: "$merge", Value: bson.M{"into": bson.M{"coll": "acme_report"}}}}},
+		},
+		{
+			name:     "Out to another database is rejected",
+			pipeline: mongo.Pipeline{{{Key: "$out", Value: bson.M{"db": "other", "coll": "report"}}}},
+			wantErr:  ErrInvalidTenant,
+		},
+		{
+			name:     "Unknown collection type is rejected",
+			pipeline: mongo.Pipeline{{{Key: "$lookup", Value: bson.D{{Key: "from", Value: 1}}}}},
+			wantErr:  ErrInvalidTenant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			got, err := store.prefixPipeline(tt.pipeline)
+
+			// Assert
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("Shared store is not rewritten", func(t *testing.T) {
+		// Arrange
+		pipeline := mongo.Pipeline{{{Key: "$out", Value: "report"}}}
+
+		// Act
+		got, err := (&mongoStore{}).prefixPipeline(pipeline)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, pipeline, got)
+	})
+}
+
+func TestResolveTenant(t *testing.T) {
+	incoming := metadata.NewIncomingContext(context.Background(), metadata.Pairs("merchant-id", "m1"))
+
+	tests := []struct {
+		ctx        context.Context
+		wantErr    error
+		name       string
+		wantTenant string
+		required   bool
+	}{
+		{name: "From metadata", ctx: incoming, wantTenant: "m1"},
+		{name: "WithTenant takes precedence", ctx: WithTenant(incoming, "m2"), wantTenant: "m2"},
+		{name: "No tenant", ctx: context.Background()},
+		{name: "Required tenant missing", ctx: context.Background(), required: true, wantErr: ErrInvalidTenant},
+		{name: "Invalid tenant", ctx: WithTenant(context.Background(), "a.b"), wantErr: ErrInvalidTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			defer SetTenancy(TenancyOptions{
+				Strategy: TenantDatabase, Resolver: MetadataTenant("merchant-id"), Required: tt.required,
+			})()
+
+			// Act
+			tenant, _, err := resolveTenant(tt.ctx)
+
+			// Assert
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantTenant, tenant)
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	// Arrange
+	defer SetTenancy(TenancyOptions{Strategy: TenantCollectionPrefix})()
+	restore := SetDatastore(NewMemoryDatastore())
+	defer restore()
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+
+	// Act
+	_, err := Save(acme, &testUser{Name: "alice"})
+	require.NoError(t, err)
+	_, err = Save(globex, &testUser{Name: "bob"})
+	require.NoError(t, err)
+	err = WithTransaction(globex, func(txCtx context.Context) error {
+		_, err := Save(txCtx, &testUser{Name: "carol"})
+		require.NoError(t, err)
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	// Assert
+	acmeUsers, err := Find(acme, &testUser{}, bson.D{}, 0)
+	require.NoError(t, err)
+	require.Len(t, acmeUsers, 1)
+	assert.Equal(t, "alice", acmeUsers[0].Name)
+	globexCount, err := CountDocument(globex, (&testUser{}).C(), bson.D{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), globexCount)
+	sharedCount, err := CountDocument(context.Background(), (&testUser{}).C(), bson.D{})
+	require.NoError(t, err)
+	assert.Zero(t, sharedCount)
+}
+
+func TestTenantRequired(t *testing.T) {
+	// Arrange
+	defer SetTenancy(TenancyOptions{Strategy: TenantDatabase, Required: true})()
+	restore := SetDatastore(NewMemoryDatastore())
+	defer restore()
+
+	// Act
+	_, saveErr := Save(context.Background(), &testUser{Name: "alice"})
+	findErr := FindOne(context.Background(), &testUser{}, bson.D{})
+
+	// Assert
+	assert.ErrorIs(t, saveErr, ErrInvalidTenant)
+	assert.ErrorIs(t, findErr, ErrInvalidTenant)
+	assert.Equal(t, StatusMongoDBInvalidTenant.Code(), ToStatus(saveErr).Code())
+}
+
+func TestTenantsToSync(t *testing.T) {
+	store := NewMemoryDatastore()
+	tenants := func(context.Context) ([]string, error) { return []string{"acme", "globex"}, nil }
+
+	tests := []struct {
+		name        string
+		wantTenants []string
+		options     TenancyOptions
+	}{
+		{name: "Disabled", wantTenants: []string{""}},
+		{
+			name:        "Shared and known tenants",
+			options:     TenancyOptions{Strategy: TenantDatabase, Tenants: tenants},
+			wantTenants: []string{"", "acme", "globex"},
+		},
+		{
+			name:        "Known tenants only when required",
+			options:     TenancyOptions{Strategy: TenantDatabase, Tenants: tenants, Required: true},
+			wantTenants: []string{"acme", "globex"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			defer SetTenancy(tt.options)()
+
+			// Act
+			stores, err := tenantsToSync(context.Background(), store)
+
+			// Assert
+			require.NoError(t, err)
+			got := make([]string, len(stores))
+			for i, s := range stores {
+				got[i] = s.(*MemoryDatastore).tenant
+			}
+			assert.Equal(t, tt.wantTenants, got)
+		})
+	}
+}
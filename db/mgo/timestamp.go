@@ -0,0 +1,45 @@
+package mgo
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	fieldUpdatedAt = "updated_at"
+)
+
+// Timestamper is implemented by models whose creation and modification times are
+// maintained by the helpers: Save stamps both, and UpdateOne, UpdateById, UpdateMany and
+// ReplaceOne refresh the modification time. Embed Timestamps to implement it.
+type Timestamper interface {
+	SetCreatedAt(t time.Time)
+	SetUpdatedAt(t time.Time)
+}
+
+// Timestamps is an embeddable implementation of Timestamper.
+// Embed it inline so that its fields are stored at the top level of the document:
+//
+//	type User struct {
+//		mgo.Index      `bson:"-"`
+//		mgo.Timestamps `bson:",inline"`
+//		...
+//	}
+type Timestamps struct {
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+func (t *Timestamps) SetCreatedAt(at time.Time) {
+	t.CreatedAt = at
+}
+
+func (t *Timestamps) SetUpdatedAt(at time.Time) {
+	t.UpdatedAt = at
+}
+
+// withUpdatedAt returns a copy of update that also sets updated_at to now.
+func withUpdatedAt(update bson.D, now time.Time) bson.D {
+	return withOperatorField(update, "$set", fieldUpdatedAt, now)
+}
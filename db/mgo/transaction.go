@@ -0,0 +1,78 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// WithTransaction runs fn inside a multi-document transaction.
+// The session is propagated through txCtx, so every helper of this package
+// (Save, UpdateOne, DeleteOne, bulk operations, ...) called with txCtx takes
+// part in the transaction automatically.
+//
+// The transaction is committed when fn returns nil and aborted otherwise, in which case
+// the error of fn is returned as is. Failures to start or commit the transaction are
+// wrapped in ErrTransactionFailed.
+// Errors labelled TransientTransactionError or UnknownTransactionCommitResult
+// are retried by the driver, so fn may run more than once and must be idempotent.
+// Transactions require a replica set or a sharded cluster.
+//
+// Example:
+//
+//	err := mgo.WithTransaction(ctx, func(txCtx context.Context) error {
+//		if _, err := mgo.Save(txCtx, order); err != nil {
+//			return err
+//		}
+//		_, err := mgo.UpdateById(txCtx, stock, update)
+//		return err
+//	})
+func WithTransaction(
+	ctx context.Context, fn func(txCtx context.Context) error,
+	opts ...options.Lister[options.TransactionOptions],
+) error {
+	return WithTransactionOn(ctx, defaultDB, fn, opts...)
+}
+
+// WithTransactionOn is like WithTransaction but starts the transaction on db.
+// Only the ...On helpers called with the same db take part in it.
+func WithTransactionOn(
+	ctx context.Context, db *DB, fn func(txCtx context.Context) error,
+	opts ...options.Lister[options.TransactionOptions],
+) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	ctx, span := store.startTraceSpan(ctx, "", "transaction", nil)
+	defer span.End()
+	var fnErr error
+	err := store.WithTransaction(ctx, func(txCtx context.Context) error {
+		fnErr = fn(txCtx)
+		return fnErr
+	}, opts...)
+	if err != nil && fnErr != nil && errors.Is(err, fnErr) {
+		return spanErrorHandler(err, span)
+	}
+	if err != nil {
+		return spanErrorHandler(fmt.Errorf("%w: %w", ErrTransactionFailed, err), span)
+	}
+	return spanErrorHandler(nil, span)
+}
+
+func (m *mongoStore) WithTransaction(
+	ctx context.Context, fn func(txCtx context.Context) error,
+	opts ...options.Lister[options.TransactionOptions],
+) error {
+	sess, err := m.getClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+	_, err = sess.WithTransaction(ctx, func(txCtx context.Context) (any, error) {
+		return nil, fn(txCtx)
+	}, opts...)
+	return err
+}
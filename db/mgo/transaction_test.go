@@ -0,0 +1,107 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+func TestWithTransaction(t *testing.T) {
+	t.Run("Runs callback with mock", func(t *testing.T) {
+		// Arrange
+		mockDB := &mgo.MockDatastore{
+			OnSave: mgo.NewOnSaveMock(),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		var saved *testUser
+		err := mgo.WithTransaction(context.Background(), func(txCtx context.Context) error {
+			var err error
+			saved, err = mgo.Save(txCtx, &testUser{Name: "Peter"})
+			return err
+		})
+
+		// Assert
+		require.NoError(t, err)
+		require.NotNil(t, saved)
+		assert.False(t, saved.ID.IsZero())
+	})
+
+	t.Run("Error from callback", func(t *testing.T) {
+		// Arrange
+		expectedErr := errors.New("callback failed")
+		called := false
+		mockDB := &mgo.MockDatastore{
+			OnWithTransaction: func(
+				ctx context.Context, fn func(txCtx context.Context) error,
+				_ ...options.Lister[options.TransactionOptions],
+			) error {
+				called = true
+				return fn(ctx)
+			},
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		err := mgo.WithTransaction(context.Background(), func(context.Context) error {
+			return expectedErr
+		})
+
+		// Assert
+		assert.True(t, called)
+		require.Error(t, err)
+		assert.Equal(t, expectedErr, err, "the callback error is returned unwrapped")
+		assert.NotErrorIs(t, err, mgo.ErrTransactionFailed)
+	})
+
+	t.Run("Commit failure", func(t *testing.T) {
+		// Arrange
+		commitErr := errors.New("commit failed")
+		mockDB := &mgo.MockDatastore{
+			OnWithTransaction: func(
+				ctx context.Context, fn func(txCtx context.Context) error,
+				_ ...options.Lister[options.TransactionOptions],
+			) error {
+				if err := fn(ctx); err != nil {
+					return err
+				}
+				return commitErr
+			},
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		err := mgo.WithTransaction(context.Background(), func(context.Context) error {
+			return nil
+		})
+
+		// Assert
+		require.ErrorIs(t, err, commitErr)
+		require.ErrorIs(t, err, mgo.ErrTransactionFailed)
+		assert.Equal(t, codes.Aborted, mgo.ToStatus(err).Code())
+	})
+
+	t.Run("Not connected", func(t *testing.T) {
+		restore := mgo.SetDatastore(nil)
+		defer restore()
+
+		err := mgo.WithTransaction(context.Background(), func(context.Context) error {
+			_, err := mgo.UpdateById(context.Background(), &testUser{ID: bson.NewObjectID()}, bson.D{})
+			return err
+		})
+
+		require.ErrorIs(t, err, mgo.ErrNotConnected)
+	})
+}
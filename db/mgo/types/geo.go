@@ -0,0 +1,138 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ErrInvalidGeoJSON is returned by the Validate methods of the GeoJSON types.
+var ErrInvalidGeoJSON = errors.New("invalid GeoJSON")
+
+// Area is a GeoJSON geometry enclosing an area, usable with mgo.Within.
+// It is implemented by Polygon and MultiPolygon.
+type Area interface {
+	Validate() error
+	area()
+}
+
+// LineString represents a GeoJSON LineString, a path through two or more positions.
+type LineString struct {
+	// Type is "LineString".
+	Type string `bson:"type"`
+	// Coordinates holds the positions of the path, each in the format [longitude, latitude].
+	Coordinates [][]float64 `bson:"coordinates"`
+}
+
+// NewLineString creates a LineString through the given [longitude, latitude] positions.
+func NewLineString(positions ...[]float64) *LineString {
+	return &LineString{Type: "LineString", Coordinates: positions}
+}
+
+// Validate checks the type, that there are at least two positions and that each is in range.
+func (l *LineString) Validate() error {
+	if l.Type != "LineString" {
+		return fmt.Errorf("%w: type %q is not LineString", ErrInvalidGeoJSON, l.Type)
+	}
+	if len(l.Coordinates) < 2 {
+		return fmt.Errorf("%w: a LineString needs at least 2 positions", ErrInvalidGeoJSON)
+	}
+	for _, p := range l.Coordinates {
+		if err := validatePosition(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Polygon represents a GeoJSON Polygon: an exterior ring optionally followed by holes.
+type Polygon struct {
+	// Type is "Polygon".
+	Type string `bson:"type"`
+	// Coordinates holds the rings of the polygon. Each ring is a closed list of
+	// [longitude, latitude] positions whose first and last positions are equal.
+	Coordinates [][][]float64 `bson:"coordinates"`
+}
+
+// NewPolygon creates a Polygon from its exterior ring followed by any holes.
+func NewPolygon(rings ...[][]float64) *Polygon {
+	return &Polygon{Type: "Polygon", Coordinates: rings}
+}
+
+// Validate checks the type and that every ring is closed, has at least four positions and
+// lies within the coordinate ranges.
+func (p *Polygon) Validate() error {
+	if p.Type != "Polygon" {
+		return fmt.Errorf("%w: type %q is not Polygon", ErrInvalidGeoJSON, p.Type)
+	}
+	return validateRings(p.Coordinates)
+}
+
+func (*Polygon) area() {}
+
+// MultiPolygon represents a GeoJSON MultiPolygon, a set of polygons.
+type MultiPolygon struct {
+	// Type is "MultiPolygon".
+	Type string `bson:"type"`
+	// Coordinates holds the rings of each polygon, as in Polygon.
+	Coordinates [][][][]float64 `bson:"coordinates"`
+}
+
+// NewMultiPolygon creates a MultiPolygon from the rings of each polygon.
+func NewMultiPolygon(polygons ...[][][]float64) *MultiPolygon {
+	return &MultiPolygon{Type: "MultiPolygon", Coordinates: polygons}
+}
+
+// Validate checks the type and validates every polygon like Polygon.Validate.
+func (m *MultiPolygon) Validate() error {
+	if m.Type != "MultiPolygon" {
+		return fmt.Errorf("%w: type %q is not MultiPolygon", ErrInvalidGeoJSON, m.Type)
+	}
+	if len(m.Coordinates) == 0 {
+		return fmt.Errorf("%w: a MultiPolygon needs at least 1 polygon", ErrInvalidGeoJSON)
+	}
+	for i, rings := range m.Coordinates {
+		if err := validateRings(rings); err != nil {
+			return fmt.Errorf("polygon %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (*MultiPolygon) area() {}
+
+func validateRings(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("%w: a Polygon needs an exterior ring", ErrInvalidGeoJSON)
+	}
+	for i, ring := range rings {
+		if len(ring) < 4 {
+			return fmt.Errorf("%w: ring %d has %d positions, at least 4 are needed", ErrInvalidGeoJSON, i, len(ring))
+		}
+		for _, p := range ring {
+			if err := validatePosition(p); err != nil {
+				return err
+			}
+		}
+		if !slices.Equal(ring[0], ring[len(ring)-1]) {
+			return fmt.Errorf("%w: ring %d is not closed", ErrInvalidGeoJSON, i)
+		}
+	}
+	return nil
+}
+
+// validatePosition checks a [longitude, latitude] position, optionally followed by an altitude.
+func validatePosition(p []float64) error {
+	if len(p) != 2 && len(p) != 3 {
+		return fmt.Errorf("%w: position %v must be [longitude, latitude]", ErrInvalidGeoJSON, p)
+	}
+	lng, lat := p[0], p[1]
+	if math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return fmt.Errorf("%w: longitude %v is out of range [-180, 180]", ErrInvalidGeoJSON, lng)
+	}
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("%w: latitude %v is out of range [-90, 90]", ErrInvalidGeoJSON, lat)
+	}
+	return nil
+}
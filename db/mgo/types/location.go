@@ -1,6 +1,8 @@
 // Package types provides shared, specialized data types for use with MongoDB documents.
 package types
 
+import "fmt"
+
 // Location represents a GeoJSON Point, a standard format for encoding geographic coordinates.
 // This struct is compatible with MongoDB's geospatial queries.
 // See MongoDB documentation for more details: https://www.mongodb.com/docs/manual/reference/geojson/
@@ -23,3 +25,11 @@ func NewLocationPoint(longitude, latitude float64) *Location {
 		Coordinates: []float64{longitude, latitude},
 	}
 }
+
+// Validate checks that l is a Point with a longitude within [-180, 180] and a latitude within [-90, 90].
+func (l *Location) Validate() error {
+	if l.Type != "Point" {
+		return fmt.Errorf("%w: type %q is not Point", ErrInvalidGeoJSON, l.Type)
+	}
+	return validatePosition(l.Coordinates)
+}
@@ -3,6 +3,8 @@ package mgo
 import (
 	"context"
 	"fmt"
+	"maps"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
@@ -17,8 +19,40 @@ import (
 //	It is also used to determine the target collection.
 //
 // update: The update document, e.g., bson.D{{"$set", bson.D{{"field", "value"}}}}.
+//
+// For Versioner documents the update only applies if the stored version equals doc's,
+// and ErrVersionConflict is returned otherwise.
 func UpdateById[T DocInter](ctx context.Context, doc T, update bson.D) (int64, error) {
-	return UpdateOne(ctx, doc, bson.D{bson.E{Key: "_id", Value: doc.GetId()}}, update)
+	return UpdateByIdOn(ctx, defaultDB, doc, update)
+}
+
+// UpdateByIdOn is like UpdateById but writes to db.
+func UpdateByIdOn[T DocInter](ctx context.Context, db *DB, doc T, update bson.D) (int64, error) {
+	if err := beforeUpdate(ctx, doc); err != nil {
+		return 0, err
+	}
+	filter := bson.D{bson.E{Key: "_id", Value: doc.GetId()}}
+	versioned, ok := any(doc).(Versioner)
+	if !ok {
+		affected, err := updateOneOn(ctx, db, doc, filter, update)
+		if err != nil || affected == 0 {
+			return affected, err
+		}
+		invalidateCache(ctx, doc)
+		return affected, afterUpdate(ctx, doc)
+	}
+	current := versioned.GetVersion()
+	filter = append(filter, versionCondition(current))
+	affected, err := updateOneOn(ctx, db, doc, filter, withOperatorField(update, "$inc", fieldVersion, 1))
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, fmt.Errorf("%w: %s at version %d", ErrVersionConflict, doc.C(), current)
+	}
+	versioned.SetVersion(current + 1)
+	invalidateCache(ctx, doc)
+	return affected, afterUpdate(ctx, doc)
 }
 
 // UpdateOne updates the first document that matches a given filter.
@@ -28,31 +62,109 @@ func UpdateById[T DocInter](ctx context.Context, doc T, update bson.D) (int64, e
 // filter: The filter to select the document to update.
 // update: The update document, e.g., bson.D{{"$set", bson.D{{"field", "value"}}}}.
 func UpdateOne[T DocInter](ctx context.Context, doc T, filter bson.D, update bson.D) (int64, error) {
-	if dataStore == nil {
+	return UpdateOneOn(ctx, defaultDB, doc, filter, update)
+}
+
+// UpdateOneOn is like UpdateOne but writes to db.
+func UpdateOneOn[T DocInter](ctx context.Context, db *DB, doc T, filter bson.D, update bson.D) (int64, error) {
+	if err := beforeUpdate(ctx, doc); err != nil {
+		return 0, err
+	}
+	affected, err := updateOneOn(ctx, db, doc, filter, update)
+	if err != nil || affected == 0 {
+		return affected, err
+	}
+	return affected, afterUpdate(ctx, doc)
+}
+
+// updateOneOn is UpdateOneOn without the hooks of doc.
+func updateOneOn[T DocInter](ctx context.Context, db *DB, doc T, filter bson.D, update bson.D) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
+	if _, ok := any(doc).(Timestamper); ok {
+		update = withUpdatedAt(update, time.Now())
+	}
+	filter, err := sealFilterD(ctx, doc, filter)
+	if err != nil {
+		return 0, err
+	}
+	if update, err = sealUpdate(ctx, doc, update); err != nil {
+		return 0, err
+	}
 	collectionName := doc.C()
-	_, span := dataStore.startTraceSpan(ctx, collectionName, "updateOne", filter)
+	_, span := store.startTraceSpan(ctx, collectionName, "updateOne", filter)
 	defer span.End()
-	affected, err := dataStore.UpdateOne(ctx, doc.C(), filter, update)
+	before := auditBefore(ctx, store, collectionName, filter)
+	idempotent := isIdempotentUpdate(update) && pinsSingleID(filter)
+	affected, err := retryValue(ctx, span, idempotent, func() (int64, error) {
+		return store.UpdateOne(ctx, doc.C(), filter, update)
+	})
 	if err != nil {
 		return 0, spanErrorHandler(err, span)
 	}
+	if affected > 0 {
+		recordAudit(ctx, auditWrite{
+			model: doc, filter: filter, update: update,
+			before: before, after: auditAfter(ctx, store, collectionName, documentID(before)),
+			collection: collectionName, operation: "updateOne",
+		})
+	}
 	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
 	return affected, spanErrorHandler(nil, span)
 }
 
 func UpdateMany[T DocInter](ctx context.Context, doc T, filter bson.D, update bson.D) (int64, error) {
-	if dataStore == nil {
+	return UpdateManyOn(ctx, defaultDB, doc, filter, update)
+}
+
+// UpdateManyOn is like UpdateMany but writes to db.
+func UpdateManyOn[T DocInter](ctx context.Context, db *DB, doc T, filter bson.D, update bson.D) (int64, error) {
+	store := db.datastore()
+	if store == nil {
 		return 0, ErrNotConnected
 	}
-	return dataStore.UpdateMany(ctx, doc.C(), filter, update)
+	if err := beforeUpdate(ctx, doc); err != nil {
+		return 0, err
+	}
+	if _, ok := any(doc).(Timestamper); ok {
+		update = withUpdatedAt(update, time.Now())
+	}
+	filter, err := sealFilterD(ctx, doc, filter)
+	if err != nil {
+		return 0, err
+	}
+	if update, err = sealUpdate(ctx, doc, update); err != nil {
+		return 0, err
+	}
+	collectionName := doc.C()
+	_, span := store.startTraceSpan(ctx, collectionName, "updateMany", filter)
+	defer span.End()
+	affected, err := retryValue(ctx, span, isIdempotentUpdate(update), func() (int64, error) {
+		return store.UpdateMany(ctx, collectionName, filter, update)
+	})
+	if err != nil {
+		return 0, spanErrorHandler(err, span)
+	}
+	if affected > 0 {
+		recordAudit(ctx, auditWrite{
+			model: doc, filter: filter, update: update, collection: collectionName, operation: "updateMany",
+		})
+		err = afterUpdate(ctx, doc)
+	}
+	span.SetAttributes(attribute.Int64("db.affected_number_of_documents", affected))
+	return affected, spanErrorHandler(err, span)
 }
 
 func (m *mongoStore) UpdateOne(
 	ctx context.Context, collection string, filter bson.D, update bson.D,
 	opts ...options.Lister[options.UpdateOneOptions],
 ) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
 	result, err := m.getCollection(collection).UpdateOne(ctx, filter, update, opts...)
 	if err != nil {
 		return 0, fmt.Errorf("%w: %w", ErrWriteFailed, err)
@@ -62,9 +174,51 @@ func (m *mongoStore) UpdateOne(
 
 // UpdateMany updates all documents that match a given filter.
 func (m *mongoStore) UpdateMany(ctx context.Context, collection string, filter bson.D, update bson.D) (int64, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
 	result, err := m.getCollection(collection).UpdateMany(ctx, filter, update)
 	if err != nil {
 		return 0, fmt.Errorf("%w: %w", ErrWriteFailed, err)
 	}
 	return result.ModifiedCount, nil
 }
+
+// withOperatorField returns a copy of update whose operator op (e.g. "$set") also assigns value to key.
+// update is returned unchanged when op already assigns key or its argument is not a document.
+func withOperatorField(update bson.D, op, key string, value any) bson.D {
+	result := make(bson.D, 0, len(update)+1)
+	found := false
+	for _, e := range update {
+		if e.Key == op {
+			found = true
+			switch fields := e.Value.(type) {
+			case bson.D:
+				if !hasKey(fields, key) {
+					e.Value = append(fields[:len(fields):len(fields)], bson.E{Key: key, Value: value})
+				}
+			case bson.M:
+				if _, ok := fields[key]; !ok {
+					m := maps.Clone(fields)
+					m[key] = value
+					e.Value = m
+				}
+			}
+		}
+		result = append(result, e)
+	}
+	if !found {
+		result = append(result, bson.E{Key: op, Value: bson.D{bson.E{Key: key, Value: value}}})
+	}
+	return result
+}
+
+func hasKey(d bson.D, key string) bool {
+	for _, e := range d {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package mgo
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const fieldVersion = "version"
+
+// Versioner is implemented by models protected by optimistic concurrency control.
+// ReplaceOne and UpdateById only write the document if its stored version still equals
+// GetVersion, increment the version on success and return ErrVersionConflict otherwise.
+// Save stores new documents with version 1. Embed Versioned to implement it.
+type Versioner interface {
+	GetVersion() int64
+	SetVersion(v int64)
+}
+
+// Versioned is an embeddable implementation of Versioner.
+// Like Timestamps, embed it with `bson:",inline"`.
+type Versioned struct {
+	Version int64 `bson:"version"`
+}
+
+func (v *Versioned) GetVersion() int64 {
+	return v.Version
+}
+
+func (v *Versioned) SetVersion(version int64) {
+	v.Version = version
+}
+
+// versionCondition matches documents whose version is current.
+// Version 0 also matches documents stored before versioning was introduced.
+func versionCondition(current int64) bson.E {
+	if current == 0 {
+		return bson.E{Key: fieldVersion, Value: bson.D{bson.E{Key: "$in", Value: bson.A{nil, int64(0)}}}}
+	}
+	return bson.E{Key: fieldVersion, Value: current}
+}
@@ -0,0 +1,133 @@
+package mgo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/grpc/codes"
+)
+
+// versionedUser is a model protected by optimistic concurrency control.
+type versionedUser struct {
+	Name          string `bson:"name"`
+	mgo.Versioned `bson:",inline"`
+	ID            bson.ObjectID `bson:"_id,omitempty"`
+}
+
+func (*versionedUser) C() string                   { return "versioned_users" }
+func (*versionedUser) Indexes() []mongo.IndexModel { return nil }
+func (*versionedUser) Validate() error             { return nil }
+func (u *versionedUser) GetId() any                { return u.ID }
+func (u *versionedUser) SetId(id any)              { u.ID, _ = id.(bson.ObjectID) }
+
+func TestVersionedUpdateById(t *testing.T) {
+	t.Run("Success increments the version", func(t *testing.T) {
+		// Arrange
+		var capturedFilter, capturedUpdate bson.D
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnUpdateOne: func(_ context.Context, _ string, filter bson.D, update bson.D) (int64, error) {
+				capturedFilter, capturedUpdate = filter, update
+				return 1, nil
+			},
+		})
+		defer restore()
+		user := &versionedUser{ID: bson.NewObjectID(), Versioned: mgo.Versioned{Version: 3}}
+
+		// Act
+		_, err := mgo.UpdateById(context.Background(), user,
+			bson.D{bson.E{Key: "$set", Value: bson.D{bson.E{Key: "name", Value: "Peter"}}}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), user.Version)
+		assert.Equal(t, bson.E{Key: "version", Value: int64(3)}, capturedFilter[1])
+		assert.Equal(t, bson.E{Key: "$inc", Value: bson.D{bson.E{Key: "version", Value: 1}}}, capturedUpdate[1])
+	})
+
+	t.Run("Conflict when nothing matched", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnUpdateOne: func(context.Context, string, bson.D, bson.D) (int64, error) {
+				return 0, nil
+			},
+		})
+		defer restore()
+		user := &versionedUser{ID: bson.NewObjectID(), Versioned: mgo.Versioned{Version: 3}}
+
+		// Act
+		_, err := mgo.UpdateById(context.Background(), user, bson.D{})
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrVersionConflict)
+		assert.Equal(t, codes.Aborted, mgo.ToStatus(err).Code())
+		assert.Equal(t, int64(3), user.Version)
+	})
+}
+
+func TestVersionedReplaceOne(t *testing.T) {
+	t.Run("Success increments the version", func(t *testing.T) {
+		// Arrange
+		var capturedFilter any
+		var storedVersion int64
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnReplaceOne: func(
+				_ context.Context, _ string, filter any, replacement any, _ ...options.Lister[options.ReplaceOptions],
+			) (*mongo.UpdateResult, error) {
+				capturedFilter = filter
+				storedVersion = replacement.(*versionedUser).Version
+				return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			},
+		})
+		defer restore()
+		user := &versionedUser{ID: bson.NewObjectID()}
+
+		// Act
+		_, err := mgo.ReplaceOne(context.Background(), user, bson.D{bson.E{Key: "_id", Value: user.ID}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), storedVersion)
+		assert.Equal(t, int64(1), user.Version)
+		assert.Equal(t, bson.D{
+			bson.E{Key: "_id", Value: user.ID},
+			bson.E{Key: "version", Value: bson.D{bson.E{Key: "$in", Value: bson.A{nil, int64(0)}}}},
+		}, capturedFilter)
+	})
+
+	t.Run("Conflict restores the version", func(t *testing.T) {
+		// Arrange
+		restore := mgo.SetDatastore(&mgo.MockDatastore{
+			OnReplaceOne: func(
+				context.Context, string, any, any, ...options.Lister[options.ReplaceOptions],
+			) (*mongo.UpdateResult, error) {
+				return &mongo.UpdateResult{}, nil
+			},
+		})
+		defer restore()
+		user := &versionedUser{ID: bson.NewObjectID(), Versioned: mgo.Versioned{Version: 5}}
+
+		// Act
+		_, err := mgo.ReplaceOne(context.Background(), user, bson.D{bson.E{Key: "_id", Value: user.ID}})
+
+		// Assert
+		require.ErrorIs(t, err, mgo.ErrVersionConflict)
+		assert.Equal(t, int64(5), user.Version)
+	})
+}
+
+func TestVersionedSave(t *testing.T) {
+	restore := mgo.SetDatastore(&mgo.MockDatastore{OnSave: mgo.NewOnSaveMock()})
+	defer restore()
+
+	user, err := mgo.Save(context.Background(), &versionedUser{Name: "Peter"})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), user.Version)
+}
@@ -0,0 +1,346 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/94peter/vulpes/log"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	defaultWatchMinBackoff = 500 * time.Millisecond
+	defaultWatchMaxBackoff = 30 * time.Second
+)
+
+// ChangeStream is the subset of *mongo.ChangeStream used by Watch.
+// It allows MockDatastore to feed fake events; see NewOnWatchMock.
+type ChangeStream interface {
+	Next(ctx context.Context) bool
+	Decode(val any) error
+	ResumeToken() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// ChangeEvent is a decoded change stream event.
+// FullDocument holds the document after the change; it is the zero value for delete events.
+type ChangeEvent[T any] struct {
+	FullDocument  T              `bson:"fullDocument"`
+	OperationType string         `bson:"operationType"`
+	ResumeToken   bson.Raw       `bson:"_id"`
+	DocumentKey   bson.Raw       `bson:"documentKey"`
+	ClusterTime   bson.Timestamp `bson:"clusterTime"`
+}
+
+// ResumeTokenStore persists change stream resume tokens so that a watcher
+// continues where it stopped after a restart.
+type ResumeTokenStore interface {
+	// LoadResumeToken returns the last saved token for key, or nil if there is none.
+	LoadResumeToken(ctx context.Context, key string) (bson.Raw, error)
+	SaveResumeToken(ctx context.Context, key string, token bson.Raw) error
+}
+
+type watchConfig struct {
+	store       ResumeTokenStore
+	storeKey    string
+	pipeline    mongo.Pipeline
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	withoutFull bool
+}
+
+// WatchOption configures Watch.
+type WatchOption func(*watchConfig)
+
+// WithWatchPipeline filters or reshapes the events with an aggregation pipeline,
+// e.g. mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}}}.
+func WithWatchPipeline(pipeline mongo.Pipeline) WatchOption {
+	return func(c *watchConfig) {
+		c.pipeline = pipeline
+	}
+}
+
+// WithResumeTokenStore persists the resume token of every handled event under key.
+// The key identifies the consumer, so several watchers of the same collection need distinct keys.
+func WithResumeTokenStore(store ResumeTokenStore, key string) WatchOption {
+	return func(c *watchConfig) {
+		c.store = store
+		c.storeKey = key
+	}
+}
+
+// WithWatchBackoff sets the minimum and maximum delay between reconnection attempts.
+func WithWatchBackoff(minDelay, maxDelay time.Duration) WatchOption {
+	return func(c *watchConfig) {
+		if minDelay > 0 {
+			c.minBackoff = minDelay
+		}
+		if maxDelay >= c.minBackoff {
+			c.maxBackoff = maxDelay
+		}
+	}
+}
+
+// WithoutFullDocument skips the lookup of the current document for update events,
+// leaving FullDocument empty for them. This saves a read per update.
+func WithoutFullDocument() WatchOption {
+	return func(c *watchConfig) {
+		c.withoutFull = true
+	}
+}
+
+// handlerError marks errors returned by the Watch handler, which are never retried.
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string { return e.err.Error() }
+func (e *handlerError) Unwrap() error { return e.err }
+
+// Watch opens a change stream on doc.C() and calls handler for every event, until ctx is cancelled.
+// Full documents are decoded into T. When a ResumeTokenStore is configured, the token of each
+// handled event is saved after handler returns, and the stream resumes from it on the next start.
+// Transient errors (network failures, primary step-downs, ...) reopen the stream with
+// exponential backoff after the last handled event, with or without a ResumeTokenStore;
+// any other error, or an error returned by handler, stops the watcher.
+//
+// Watch blocks and returns nil once ctx is cancelled or the server closes the stream.
+func Watch[T DocInter](
+	ctx context.Context, doc T, handler func(ctx context.Context, event ChangeEvent[T]) error,
+	opts ...WatchOption,
+) error {
+	return WatchOn(ctx, defaultDB, doc, handler, opts...)
+}
+
+// WatchOn is like Watch but watches the collection of db.
+func WatchOn[T DocInter](
+	ctx context.Context, db *DB, doc T, handler func(ctx context.Context, event ChangeEvent[T]) error,
+	opts ...WatchOption,
+) error {
+	store := db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	cfg := &watchConfig{
+		minBackoff: defaultWatchMinBackoff,
+		maxBackoff: defaultWatchMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	collectionName := doc.C()
+	backoff := cfg.minBackoff
+	// resumeToken is the token of the last handled event, from which a reconnection resumes.
+	var resumeToken bson.Raw
+	for {
+		received, err := watchOnce(ctx, store, collectionName, handler, cfg, &resumeToken)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			return nil
+		}
+		if hErr := (*handlerError)(nil); errors.As(err, &hErr) {
+			return hErr.err
+		}
+		if !isTransientError(err) {
+			return fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		if received {
+			backoff = cfg.minBackoff
+		}
+		log.Warn("change stream interrupted, reconnecting",
+			log.String("collection", collectionName), log.Duration("backoff", backoff), log.Err(err))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, cfg.maxBackoff)
+	}
+}
+
+// watchOnce consumes one change stream until it fails or ends, resuming after resumeToken
+// and setting it to the token of every handled event. Without a token, the stream resumes
+// after the token of the ResumeTokenStore, if any. It reports whether at least one event
+// was handled.
+func watchOnce[T any](
+	ctx context.Context, store Datastore, collectionName string,
+	handler func(ctx context.Context, event ChangeEvent[T]) error, cfg *watchConfig, resumeToken *bson.Raw,
+) (bool, error) {
+	csOpts := options.ChangeStream()
+	if !cfg.withoutFull {
+		csOpts.SetFullDocument(options.UpdateLookup)
+	}
+	if *resumeToken == nil && cfg.store != nil {
+		token, err := cfg.store.LoadResumeToken(ctx, cfg.storeKey)
+		if err != nil {
+			return false, err
+		}
+		*resumeToken = token
+	}
+	if *resumeToken != nil {
+		csOpts.SetResumeAfter(*resumeToken)
+	}
+	stream, err := store.Watch(ctx, collectionName, cfg.pipeline, csOpts)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = stream.Close(context.WithoutCancel(ctx))
+	}()
+	received := false
+	for stream.Next(ctx) {
+		event, err := decodeChangeEvent[T](ctx, stream)
+		if err != nil {
+			return received, err
+		}
+		if err := handleChangeEvent(ctx, store, collectionName, handler, event); err != nil {
+			return received, &handlerError{err: err}
+		}
+		received = true
+		*resumeToken = stream.ResumeToken()
+		if cfg.store != nil {
+			if err := cfg.store.SaveResumeToken(ctx, cfg.storeKey, *resumeToken); err != nil {
+				return received, err
+			}
+		}
+	}
+	return received, stream.Err()
+}
+
+// decodeChangeEvent decodes the current event of stream, decrypting the encrypted fields of its
+// full document.
+func decodeChangeEvent[T any](ctx context.Context, stream ChangeStream) (ChangeEvent[T], error) {
+	var event ChangeEvent[T]
+	if !hasEncryptedFields[T]() {
+		return event, stream.Decode(&event)
+	}
+	var raw ChangeEvent[bson.Raw]
+	if err := stream.Decode(&raw); err != nil {
+		return event, err
+	}
+	event = ChangeEvent[T]{
+		OperationType: raw.OperationType,
+		ResumeToken:   raw.ResumeToken,
+		DocumentKey:   raw.DocumentKey,
+		ClusterTime:   raw.ClusterTime,
+	}
+	if len(raw.FullDocument) == 0 {
+		return event, nil
+	}
+	var err error
+	event.FullDocument, err = decodeRaw[T](ctx, raw.FullDocument)
+	return event, err
+}
+
+func handleChangeEvent[T any](
+	ctx context.Context, store Datastore, collectionName string,
+	handler func(ctx context.Context, event ChangeEvent[T]) error, event ChangeEvent[T],
+) error {
+	ctx, span := store.startTraceSpan(ctx, collectionName, "watch."+event.OperationType, nil)
+	defer span.End()
+	return spanErrorHandler(handler(ctx, event), span)
+}
+
+func (m *mongoStore) Watch(
+	ctx context.Context, collection string, pipeline mongo.Pipeline,
+	opts ...options.Lister[options.ChangeStreamOptions],
+) (ChangeStream, error) {
+	m, err := m.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+	stream, err := m.getCollection(collection).Watch(ctx, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// memoryTokenStore keeps resume tokens in process memory.
+type memoryTokenStore struct {
+	tokens map[string]bson.Raw
+	mu     sync.RWMutex
+}
+
+// NewMemoryTokenStore returns a ResumeTokenStore that keeps tokens in memory.
+// It survives reconnections but not restarts of the process.
+func NewMemoryTokenStore() ResumeTokenStore {
+	return &memoryTokenStore{tokens: make(map[string]bson.Raw)}
+}
+
+func (s *memoryTokenStore) LoadResumeToken(_ context.Context, key string) (bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[key], nil
+}
+
+func (s *memoryTokenStore) SaveResumeToken(_ context.Context, key string, token bson.Raw) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+// mongoTokenStore keeps resume tokens in a MongoDB collection, one document per key.
+type mongoTokenStore struct {
+	db         *DB
+	collection string
+}
+
+// NewMongoTokenStore returns a ResumeTokenStore that saves tokens in the given collection
+// of the connected database, so consumers survive restarts.
+func NewMongoTokenStore(collection string) ResumeTokenStore {
+	return NewMongoTokenStoreOn(defaultDB, collection)
+}
+
+// NewMongoTokenStoreOn is like NewMongoTokenStore but saves the tokens in db.
+func NewMongoTokenStoreOn(db *DB, collection string) ResumeTokenStore {
+	return &mongoTokenStore{db: db, collection: collection}
+}
+
+func (s *mongoTokenStore) LoadResumeToken(ctx context.Context, key string) (bson.Raw, error) {
+	store := s.db.datastore()
+	if store == nil {
+		return nil, ErrNotConnected
+	}
+	var result struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := store.FindOne(ctx, s.collection, bson.D{bson.E{Key: "_id", Value: key}}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return result.Token, nil
+}
+
+func (s *mongoTokenStore) SaveResumeToken(ctx context.Context, key string, token bson.Raw) error {
+	store := s.db.datastore()
+	if store == nil {
+		return ErrNotConnected
+	}
+	_, err := store.UpdateOne(
+		ctx, s.collection,
+		bson.D{bson.E{Key: "_id", Value: key}},
+		bson.D{bson.E{Key: "$set", Value: bson.D{
+			bson.E{Key: "token", Value: token},
+			bson.E{Key: "updated_at", Value: time.Now()},
+		}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,195 @@
+package mgo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/94peter/vulpes/db/mgo"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func newChangeEvent(token, op string, user testUser) bson.D {
+	return bson.D{
+		bson.E{Key: "_id", Value: bson.D{bson.E{Key: "_data", Value: token}}},
+		bson.E{Key: "operationType", Value: op},
+		bson.E{Key: "fullDocument", Value: user},
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Run("Handles events and saves resume token", func(t *testing.T) {
+		// Arrange
+		mockDB := &mgo.MockDatastore{
+			OnWatch: mgo.NewOnWatchMock(
+				newChangeEvent("1", "insert", testUser{Name: "Peter"}),
+				newChangeEvent("2", "update", testUser{Name: "Alice"}),
+			),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+		store := mgo.NewMemoryTokenStore()
+
+		// Act
+		var ops, names []string
+		err := mgo.Watch(context.Background(), &testUser{},
+			func(_ context.Context, event mgo.ChangeEvent[*testUser]) error {
+				ops = append(ops, event.OperationType)
+				names = append(names, event.FullDocument.Name)
+				return nil
+			},
+			mgo.WithResumeTokenStore(store, "consumer"),
+		)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{"insert", "update"}, ops)
+		assert.Equal(t, []string{"Peter", "Alice"}, names)
+		token, err := store.LoadResumeToken(context.Background(), "consumer")
+		require.NoError(t, err)
+		assert.Equal(t, "2", token.Lookup("_data").StringValue())
+	})
+
+	t.Run("Resumes after saved token", func(t *testing.T) {
+		// Arrange
+		var csOpts options.ChangeStreamOptions
+		mockDB := &mgo.MockDatastore{
+			OnWatch: func(
+				_ context.Context, _ string, _ mongo.Pipeline, opts ...options.Lister[options.ChangeStreamOptions],
+			) (mgo.ChangeStream, error) {
+				for _, opt := range opts {
+					for _, set := range opt.List() {
+						_ = set(&csOpts)
+					}
+				}
+				return mgo.NewOnWatchMock()(context.Background(), "", nil)
+			},
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+		store := mgo.NewMemoryTokenStore()
+		saved, err := bson.Marshal(bson.D{bson.E{Key: "_data", Value: "9"}})
+		require.NoError(t, err)
+		require.NoError(t, store.SaveResumeToken(context.Background(), "consumer", saved))
+
+		// Act
+		err = mgo.Watch(context.Background(), &testUser{},
+			func(context.Context, mgo.ChangeEvent[*testUser]) error { return nil },
+			mgo.WithResumeTokenStore(store, "consumer"),
+		)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, bson.Raw(saved), csOpts.ResumeAfter)
+	})
+
+	t.Run("Reconnects after the last handled event without a token store", func(t *testing.T) {
+		// Arrange: the first stream fails with a transient error after one event.
+		var resumeAfter []any
+		connections := 0
+		mockDB := &mgo.MockDatastore{
+			OnWatch: func(
+				ctx context.Context, c string, p mongo.Pipeline, opts ...options.Lister[options.ChangeStreamOptions],
+			) (mgo.ChangeStream, error) {
+				var csOpts options.ChangeStreamOptions
+				for _, opt := range opts {
+					for _, set := range opt.List() {
+						_ = set(&csOpts)
+					}
+				}
+				resumeAfter = append(resumeAfter, csOpts.ResumeAfter)
+				connections++
+				if connections == 1 {
+					stream, err := mgo.NewOnWatchMock(newChangeEvent("1", "insert", testUser{Name: "Peter"}))(ctx, c, p)
+					return &interruptedStream{ChangeStream: stream}, err
+				}
+				return mgo.NewOnWatchMock(newChangeEvent("2", "insert", testUser{Name: "Alice"}))(ctx, c, p)
+			},
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		var names []string
+		err := mgo.Watch(context.Background(), &testUser{},
+			func(_ context.Context, event mgo.ChangeEvent[*testUser]) error {
+				names = append(names, event.FullDocument.Name)
+				return nil
+			},
+			mgo.WithWatchBackoff(time.Millisecond, time.Millisecond),
+		)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Peter", "Alice"}, names)
+		require.Len(t, resumeAfter, 2)
+		assert.Nil(t, resumeAfter[0])
+		token, ok := resumeAfter[1].(bson.Raw)
+		require.True(t, ok)
+		assert.Equal(t, "1", token.Lookup("_data").StringValue())
+	})
+
+	t.Run("Handler error stops the watcher", func(t *testing.T) {
+		// Arrange
+		expectedErr := errors.New("handler failed")
+		mockDB := &mgo.MockDatastore{
+			OnWatch: mgo.NewOnWatchMock(
+				newChangeEvent("1", "insert", testUser{Name: "Peter"}),
+				newChangeEvent("2", "insert", testUser{Name: "Alice"}),
+			),
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		calls := 0
+		err := mgo.Watch(context.Background(), &testUser{},
+			func(context.Context, mgo.ChangeEvent[*testUser]) error {
+				calls++
+				return expectedErr
+			},
+		)
+
+		// Assert
+		require.ErrorIs(t, err, expectedErr)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Non-transient error is returned", func(t *testing.T) {
+		// Arrange
+		expectedErr := errors.New("watch failed")
+		mockDB := &mgo.MockDatastore{
+			OnWatch: func(
+				context.Context, string, mongo.Pipeline, ...options.Lister[options.ChangeStreamOptions],
+			) (mgo.ChangeStream, error) {
+				return nil, expectedErr
+			},
+		}
+		restore := mgo.SetDatastore(mockDB)
+		defer restore()
+
+		// Act
+		err := mgo.Watch(context.Background(), &testUser{},
+			func(context.Context, mgo.ChangeEvent[*testUser]) error { return nil },
+		)
+
+		// Assert
+		require.ErrorIs(t, err, expectedErr)
+		assert.ErrorIs(t, err, mgo.ErrReadFailed)
+	})
+}
+
+// interruptedStream is a change stream that fails with a network error once its events are read.
+type interruptedStream struct {
+	mgo.ChangeStream
+}
+
+func (s *interruptedStream) Err() error {
+	return mongo.CommandError{Labels: []string{"NetworkError"}}
+}